@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/benchmark"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+func main() {
+	experiment := flag.String("experiment", benchmark.Rumor, "experiment to run (rumor, consensus)")
+	nodes := flag.String("nodes", "6,8,10,12,14,16,18,20,22,24", "comma separated node counts")
+	edgeRatios := flag.String("edge-ratio", "1.5", "comma separated edges per node of the random graphs")
+	c := flag.String("c", "2,3", "comma separated rumor C")
+	s := flag.String("s", "3", "comma separated consensus s (nodes initiating the vote)")
+	m := flag.String("m", "5", "comma separated consensus m (discrete timestamps)")
+	p := flag.String("p", "2", "comma separated consensus p (random neighbours per proposal)")
+	amax := flag.String("amax", "3", "comma separated consensus amax (max voting rounds)")
+	repeat := flag.Int("repeat", 1, "runs per parameter combination")
+	seed := flag.Int64("seed", 1, "graph seed of the first run, counting up for every run")
+	warmup := flag.Duration("warmup", time.Second, "time between STARTUP and the trigger of the experiment")
+	timeout := flag.Duration("timeout", 60*time.Second, "time until a run is reported as not completed")
+	settle := flag.Duration("settle", 2*time.Second, "a rumor run is complete once no rumor message was sent for this long")
+	interval := flag.Duration("interval", 100*time.Millisecond, "polling interval of metrics and state")
+	dir := flag.String("dir", "", "directory for generated files and the node binary; temporary if empty")
+	inProcess := flag.Bool("in-process", false, "run all nodes in this process; consensus messages are counted in the shared registry")
+	nodeBin := flag.String("node-bin", "", "node binary; built from cmd/node if empty")
+	nodeLogs := flag.String("node-logs", "", "file for the aggregated node logs; - for stderr, discarded if empty")
+	csvOut := flag.String("csv", "results.csv", "CSV result file; disabled if empty")
+	jsonOut := flag.String("json", "", "JSON result file; disabled if empty")
+	flag.Parse()
+
+	sweep := &benchmark.Sweep{Experiment: *experiment, Repeat: *repeat, Seed: *seed}
+	var err error
+	for _, l := range []struct {
+		flag string
+		dst  *[]int
+	}{{*c, &sweep.C}, {*s, &sweep.S}, {*m, &sweep.M}, {*p, &sweep.P}, {*amax, &sweep.AMax}} {
+		if *l.dst, err = ints(l.flag); err != nil {
+			log.Err(err).Msg("Invalid parameters")
+			os.Exit(1)
+		}
+	}
+	ns, err := ints(*nodes)
+	if err != nil {
+		log.Err(err).Msg("Invalid node counts")
+		os.Exit(1)
+	}
+	for _, n := range ns {
+		if n < 2 {
+			log.Error().Msgf("At least 2 nodes required, got %d", n)
+			os.Exit(1)
+		}
+		sweep.Nodes = append(sweep.Nodes, uint(n))
+	}
+	for _, v := range strings.Split(*edgeRatios, ",") {
+		r, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			log.Err(err).Msg("Invalid edge ratio")
+			os.Exit(1)
+		}
+		sweep.EdgeRatios = append(sweep.EdgeRatios, r)
+	}
+
+	var logs io.Writer
+	switch *nodeLogs {
+	case "":
+	case "-":
+		logs = os.Stderr
+	default:
+		f, err := os.Create(*nodeLogs)
+		if err != nil {
+			log.Err(err).Msg("Failed to create node log")
+			os.Exit(1)
+		}
+		defer f.Close()
+		logs = f
+	}
+
+	r, err := benchmark.NewRunner(benchmark.Options{
+		Dir:       *dir,
+		InProcess: *inProcess,
+		NodeBin:   *nodeBin,
+		Logs:      logs,
+		Warmup:    *warmup,
+		Timeout:   *timeout,
+		Settle:    *settle,
+		Interval:  *interval,
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to prepare benchmark")
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		osc := make(chan os.Signal, 1)
+		signal.Notify(osc, os.Interrupt, syscall.SIGTERM)
+		<-osc
+		log.Info().Msg("Received CTRL+C, writing the results of the completed runs")
+		cancel()
+	}()
+
+	runs := sweep.Runs()
+	results := []*benchmark.Result{}
+	for i, run := range runs {
+		res := r.Run(ctx, run)
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, res)
+		l := log.Info()
+		if res.Error != "" {
+			l = log.Warn().Str("error", res.Error)
+		}
+		l.Bool("completed", res.Completed).Float64("duration", res.Duration).Uint64("messages", res.Messages).
+			Msgf("Run %d/%d: %d nodes, %d edges, seed %d", i+1, len(runs), run.Nodes, run.Edges, run.Seed)
+		// Results are rewritten after every run, an interrupted sweep keeps the completed runs
+		if err := write(results, *csvOut, *jsonOut); err != nil {
+			log.Err(err).Msg("Failed to write results")
+			os.Exit(1)
+		}
+	}
+}
+
+func write(results []*benchmark.Result, csvOut, jsonOut string) error {
+	for _, out := range []struct {
+		path  string
+		write func(io.Writer, []*benchmark.Result) error
+	}{{csvOut, benchmark.WriteCSV}, {jsonOut, benchmark.WriteJSON}} {
+		if out.path == "" {
+			continue
+		}
+		f, err := os.Create(out.path)
+		if err != nil {
+			return err
+		}
+		if err := out.write(f, results); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ints parses a comma separated list
+func ints(s string) ([]int, error) {
+	vs := []int{}
+	for _, v := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in %q", v, s)
+		}
+		vs = append(vs, i)
+	}
+	return vs, nil
+}
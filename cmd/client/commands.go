@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xvzf/vaa/internal/cluster"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// command is a node of the command tree; leafs have a setup, inner nodes subcommands
+type command struct {
+	name  string
+	help  string
+	subs  []*command
+	setup func(c *client, fs *flag.FlagSet) func() error // registers the flags and returns the action
+	// choices are the valid values of flags, used for validation and completion
+	choices map[string][]string
+	// hints are values of flags offered by the completion only
+	hints map[string][]string
+}
+
+// commands of the client; node UIDs refer to the config
+var commands = []*command{
+	{name: "send", help: "send a raw message", setup: setupSend},
+	{name: "startup", help: "CONTROL STARTUP, the nodes register at their neighbours", setup: setupControl("STARTUP")},
+	{name: "shutdown", help: "CONTROL SHUTDOWN, graceful shutdown", setup: setupControl("SHUTDOWN")},
+	{name: "reload", help: "CONTROL RELOAD, re-read config and graph", setup: setupControl("RELOAD")},
+	{name: "rumor", help: "rumor experiment", subs: []*command{
+		{name: "spread", help: "the node distributes a rumor to its neighbours", setup: setupRumorSpread},
+	}},
+	{name: "election", help: "leader election", subs: []*command{
+		{name: "start", help: "the node becomes a candidate of the election", setup: setupElectionStart,
+			choices: map[string][]string{"type": {"BANKING", "CONSENSUS"}}},
+	}},
+	{name: "banking", help: "banking experiment", subs: []*command{
+		{name: "snapshot", help: "consistent snapshot of all balances", setup: setupBankingSnapshot},
+	}},
+	{name: "membership", help: "cluster membership", subs: []*command{
+		{name: "leave", help: "the node leaves the cluster", setup: setupMembershipLeave},
+	}},
+	{name: "watch", help: "print the events of the nodes, --until blocks until an event occurs", setup: setupWatch,
+		hints: map[string][]string{"until": node.EventKinds}},
+}
+
+// usageError is returned for invalid arguments
+type usageError struct{ error }
+
+// client holds the global flags and the lazily loaded config
+type client struct {
+	configPath string
+	connect    string // overrides the targets of all commands
+	uid        uint
+	out        io.Writer
+	config     *neigh.Config
+}
+
+// loadConfig loads the config once
+func (c *client) loadConfig() (*neigh.Config, error) {
+	if c.config == nil {
+		config, err := neigh.LoadConfig(c.configPath)
+		if err != nil {
+			return nil, err
+		}
+		c.config = config
+	}
+	return c.config, nil
+}
+
+// uids returns the UIDs of the config in ascending order
+func (c *client) uids() []uint {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil
+	}
+	uids := []uint{}
+	for uid := range config.Nodes {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// exec resolves the command of args and executes it
+func (c *client) exec(args []string) error {
+	cmd, path, rest := resolve(args)
+	if cmd == nil {
+		if len(args) == 0 {
+			return usageError{errors.New("command missing")}
+		}
+		return usageError{fmt.Errorf("unknown command %q", strings.Join(args, " "))}
+	}
+	if cmd.setup == nil {
+		return usageError{fmt.Errorf("%s requires a subcommand: %s", path, strings.Join(names(cmd.subs), ", "))}
+	}
+
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	fs.Usage = func() {
+		fmt.Fprintf(c.out, "Usage: %s [flags]\n  %s\n", path, cmd.help)
+		fs.PrintDefaults()
+	}
+	run := cmd.setup(c, fs)
+	if err := fs.Parse(rest); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return usageError{err}
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments %v", fs.Args())}
+	}
+	for name, valid := range cmd.choices {
+		v := fs.Lookup(name).Value.String()
+		if !contains(valid, v) {
+			return usageError{fmt.Errorf("invalid --%s %q, one of %s", name, v, strings.Join(valid, ", "))}
+		}
+	}
+	return run()
+}
+
+// resolve walks the command tree; returns the deepest command, its path and the remaining arguments
+func resolve(args []string) (*command, string, []string) {
+	var cmd *command
+	path := []string{}
+	subs := commands
+	for len(args) > 0 {
+		var next *command
+		for _, s := range subs {
+			if s.name == args[0] {
+				next = s
+			}
+		}
+		if next == nil {
+			break
+		}
+		cmd, subs, args = next, next.subs, args[1:]
+		path = append(path, cmd.name)
+	}
+	return cmd, strings.Join(path, " "), args
+}
+
+// usage lists all commands
+func usage(w io.Writer, extra ...[2]string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var walk func(prefix string, cmds []*command)
+	walk = func(prefix string, cmds []*command) {
+		for _, cmd := range cmds {
+			if cmd.setup != nil {
+				fmt.Fprintf(tw, "  %s%s\t%s\n", prefix, cmd.name, cmd.help)
+			}
+			walk(prefix+cmd.name+" ", cmd.subs)
+		}
+	}
+	walk("", commands)
+	for _, e := range extra {
+		fmt.Fprintf(tw, "  %s\t%s\n", e[0], e[1])
+	}
+	tw.Flush()
+}
+
+// targets selects the nodes a message is sent to; the node with the lowest UID by default
+type targets struct {
+	nodes string
+	all   bool
+}
+
+func targetFlags(fs *flag.FlagSet) *targets {
+	t := &targets{}
+	fs.StringVar(&t.nodes, "node", "", "comma separated UIDs of the target nodes; the lowest UID of the config if empty")
+	fs.BoolVar(&t.all, "all", false, "send to all nodes of the config")
+	return t
+}
+
+// addrs resolves the targets to addresses
+func (c *client) addrs(t *targets) ([]string, error) {
+	if c.connect != "" {
+		if t.nodes != "" || t.all {
+			return nil, usageError{errors.New("--connect can not be combined with --node or --all")}
+		}
+		return []string{c.connect}, nil
+	}
+	if t.nodes != "" && t.all {
+		return nil, usageError{errors.New("--node can not be combined with --all")}
+	}
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	uids := c.uids()
+	if len(uids) == 0 {
+		return nil, errors.New("config without nodes")
+	}
+	switch {
+	case t.all:
+	case t.nodes != "":
+		if uids, err = parseUIDs(t.nodes); err != nil {
+			return nil, usageError{err}
+		}
+	default:
+		uids = uids[:1]
+	}
+	addrs := []string{}
+	for _, uid := range uids {
+		addr, ok := config.Nodes[uid]
+		if !ok {
+			return nil, usageError{fmt.Errorf("node %d is not part of the config", uid)}
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// send delivers a message to all targets at roughly the same time
+func (c *client) send(t *targets, msgType, payload string) error {
+	addrs, err := c.addrs(t)
+	if err != nil {
+		return err
+	}
+	return c.sendTo(addrs, com.Msg(c.uid, msgType, payload))
+}
+
+func (c *client) sendTo(addrs []string, msg *com.Message) error {
+	if err := deliver(addrs, msg); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Sent %s %q to %d node(s)\n", *msg.Type, *msg.Payload, len(addrs))
+	return nil
+}
+
+// deliver sends a message to all addresses in parallel
+func deliver(addrs []string, msg *com.Message) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := com.Send(addr, msg); err != nil {
+				errs <- fmt.Errorf("%s: %v", addr, err)
+			}
+		}(addr)
+	}
+	wg.Wait()
+	close(errs)
+	failed := []string{}
+	for err := range errs {
+		failed = append(failed, err.Error())
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func setupSend(c *client, fs *flag.FlagSet) func() error {
+	t := targetFlags(fs)
+	msgType := fs.String("type", "CONTROL", "message type")
+	payload := fs.String("payload", "", "message payload")
+	return func() error {
+		if *msgType == "" || *payload == "" {
+			return usageError{errors.New("--type and --payload are required")}
+		}
+		return c.send(t, *msgType, *payload)
+	}
+}
+
+func setupControl(payload string) func(c *client, fs *flag.FlagSet) func() error {
+	return func(c *client, fs *flag.FlagSet) func() error {
+		t := targetFlags(fs)
+		return func() error {
+			return c.send(t, "CONTROL", payload)
+		}
+	}
+}
+
+func setupRumorSpread(c *client, fs *flag.FlagSet) func() error {
+	t := targetFlags(fs)
+	count := fs.Int("c", 2, "number of neighbours a node has to hear the rumor from before trusting it")
+	text := fs.String("text", "", "the rumor")
+	return func() error {
+		switch {
+		case *count < 1:
+			return usageError{fmt.Errorf("--c has to be at least 1, got %d", *count)}
+		case *text == "":
+			return usageError{errors.New("--text is required")}
+		case strings.Contains(*text, ";"):
+			return usageError{errors.New("--text must not contain ;")}
+		}
+		return c.send(t, "CONTROL", fmt.Sprintf("DISTRIBUTE RUMOR %d;%s", *count, *text))
+	}
+}
+
+func setupElectionStart(c *client, fs *flag.FlagSet) func() error {
+	t := targetFlags(fs)
+	msgType := fs.String("type", "CONSENSUS", "extension electing a leader (BANKING, CONSENSUS)")
+	return func() error {
+		return c.send(t, *msgType, "coordinator")
+	}
+}
+
+func setupMembershipLeave(c *client, fs *flag.FlagSet) func() error {
+	t := targetFlags(fs)
+	return func() error {
+		return c.send(t, "MEMBERSHIP", "leave")
+	}
+}
+
+func setupBankingSnapshot(c *client, fs *flag.FlagSet) func() error {
+	node := fs.Uint("node", 0, "UID of the node initiating the snapshot; the lowest UID of the config if 0")
+	listen := fs.String("listen", "", "address the nodes report their state to; a free port on 127.0.0.1 if empty")
+	timeout := fs.Duration("timeout", 10*time.Second, "time to wait for all nodes to report")
+	return func() error {
+		config, err := c.loadConfig()
+		if err != nil {
+			return err
+		}
+		if len(config.Nodes) == 0 {
+			return errors.New("config without nodes")
+		}
+		connect := c.connect
+		if connect == "" {
+			uid := *node
+			if uid == 0 {
+				uid = c.uids()[0]
+			}
+			var ok bool
+			if connect, ok = config.Nodes[uid]; !ok {
+				return usageError{fmt.Errorf("node %d is not part of the config", uid)}
+			}
+		}
+		if *listen == "" {
+			ports, err := cluster.FreePorts(1)
+			if err != nil {
+				return err
+			}
+			*listen = fmt.Sprintf("127.0.0.1:%d", ports[0])
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		co, err := cluster.NewCollector(ctx, *listen)
+		if err != nil {
+			return err
+		}
+		snap := co.Collect(ctx, config.Nodes, connect, *timeout)
+
+		tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "UID\tBALANCE\tCRITICAL SECTION\tLAMPORT CLOCK")
+		total := 0
+		for _, uid := range c.uids() {
+			s, ok := snap.States[uid]
+			if !ok || !s.Has("BANKING") {
+				continue
+			}
+			total += s.Int("BANKING", "balance")
+			fmt.Fprintf(tw, "%d\t%d\t%t\t%d\n", uid, s.Int("BANKING", "balance"), s.Bool("BANKING", "critical_section"), s.Int("BANKING", "lamport_clock"))
+		}
+		fmt.Fprintf(tw, "total\t%d\t\t\n", total)
+		tw.Flush()
+		if len(snap.Missing) > 0 {
+			return fmt.Errorf("nodes %v did not report their state", snap.Missing)
+		}
+		return nil
+	}
+}
+
+// parseUIDs parses a comma separated list of UIDs
+func parseUIDs(s string) ([]uint, error) {
+	uids := []uint{}
+	for _, v := range strings.Split(s, ",") {
+		uid, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		if err != nil || uid == 0 {
+			return nil, fmt.Errorf("invalid UID %q", v)
+		}
+		uids = append(uids, uint(uid))
+	}
+	return uids, nil
+}
+
+func names(cmds []*command) []string {
+	ns := []string{}
+	for _, cmd := range cmds {
+		ns = append(ns, cmd.name)
+	}
+	return ns
+}
+
+func contains(vs []string, v string) bool {
+	for _, s := range vs {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
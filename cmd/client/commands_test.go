@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func testClient() *client {
+	return &client{out: ioutil.Discard, config: &neigh.Config{Nodes: map[uint]string{1: "127.0.0.1:1", 2: "127.0.0.1:2", 12: "127.0.0.1:12"}}}
+}
+
+func TestResolve(t *testing.T) {
+	cmd, path, rest := resolve([]string{"rumor", "spread", "--c=3"})
+	assert.Equal(t, "spread", cmd.name)
+	assert.Equal(t, "rumor spread", path)
+	assert.Equal(t, []string{"--c=3"}, rest)
+
+	cmd, path, rest = resolve([]string{"rumor", "--c=3"})
+	assert.Equal(t, "rumor", cmd.name, "groups are resolved as well")
+	assert.Equal(t, "rumor", path)
+	assert.Equal(t, []string{"--c=3"}, rest)
+
+	cmd, _, rest = resolve([]string{"unknown", "spread"})
+	assert.Nil(t, cmd)
+	assert.Equal(t, []string{"unknown", "spread"}, rest)
+}
+
+func TestExec_usage(t *testing.T) {
+	c := testClient()
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"rumor"},
+		{"startup", "--unknown"},
+		{"startup", "extra"},
+		{"election", "start", "--type=FOO"},
+		{"rumor", "spread", "--c=0", "--text=hi"},
+		{"rumor", "spread"},
+		{"rumor", "spread", "--text=a;b"},
+		{"send", "--payload=STARTUP", "--type="},
+		{"startup", "--node=1", "--all"},
+		{"startup", "--node=0"},
+		{"startup", "--node=1,x"},
+		{"startup", "--node=3"},
+	} {
+		err := c.exec(args)
+		assert.IsType(t, usageError{}, err, "%v", args)
+	}
+
+	c.connect = "127.0.0.1:1"
+	assert.IsType(t, usageError{}, c.exec([]string{"startup", "--all"}), "--connect and --all")
+
+	assert.Nil(t, c.exec([]string{"startup", "--help"}), "help is no error")
+}
+
+func TestExec_emptyConfig(t *testing.T) {
+	c := &client{out: ioutil.Discard, config: &neigh.Config{Nodes: map[uint]string{}}}
+	for _, args := range [][]string{
+		{"startup", "--all"},
+		{"banking", "snapshot"},
+	} {
+		assert.EqualError(t, c.exec(args), "config without nodes", "%v", args)
+	}
+}
+
+func TestParseUIDs(t *testing.T) {
+	uids, err := parseUIDs("3, 1,12")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{3, 1, 12}, uids)
+	_, err = parseUIDs("")
+	assert.NotNil(t, err)
+}
@@ -2,57 +2,86 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
-	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/xvzf/vaa/pkg/com"
-	"github.com/xvzf/vaa/pkg/neigh"
 )
 
 func init() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	// debug := flag.Bool("debug", true, "enable debug mode")
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
 }
 
 func main() {
-	config := flag.String("config", "", "path to config file")
+	config := flag.String("config", "./config.txt", "path to config file")
+	connect := flag.String("connect", "", "address of the target node; replaces --node and --all of the commands")
 	uid := flag.Uint("uid", 0, "UID to set for originating request")
-	t := flag.String("type", "CONTROL", "message type")
-	connect := flag.String("connect", "127.0.0.1:4000", "target node")
-	p := flag.String("payload", "STARTUP", "message payload")
-
+	debug := flag.Bool("debug", false, "enable debug logs")
+	// Single message without command, same as send
+	t := flag.String("type", "", "message type of a single message without command (deprecated, use send)")
+	p := flag.String("payload", "", "payload of a single message without command (deprecated, use send)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: client [flags] <command> [<subcommand>] [command flags]\n\nCommands:\n")
+		usage(os.Stderr, [2]string{"repl", "interactive shell with tab completion"})
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	log.Info().Msgf("Loading configuration from file %s", *config)
+	if *debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+	c := &client{configPath: *config, connect: *connect, uid: *uid, out: os.Stdout}
 
-	// Construct message
-	msg := com.Msg(*uid, *t, *p)
+	var err error
+	switch {
+	case flag.NArg() == 0 && (*t != "" || *p != ""):
+		err = c.legacy(*t, *p)
+	case flag.NArg() == 0:
+		flag.Usage()
+		os.Exit(2)
+	case flag.Arg(0) == "repl":
+		err = c.repl(os.Stdin)
+	default:
+		err = c.exec(flag.Args())
+	}
 
-	if *config != "" {
-		// Send requests to all nodes
-		c, err := neigh.LoadConfig(*config)
+	if _, ok := err.(usageError); ok {
+		fmt.Fprintf(os.Stderr, "%v\nRun client -h for a list of commands, client <command> -h for its flags\n", err)
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// legacy sends a single message like the client before the commands: to all nodes of an explicitly set config,
+// otherwise to --connect (default 127.0.0.1:4000)
+func (c *client) legacy(msgType, payload string) error {
+	if msgType == "" {
+		msgType = "CONTROL"
+	}
+	if payload == "" {
+		payload = "STARTUP"
+	}
+	configSet := false
+	flag.Visit(func(f *flag.Flag) { configSet = configSet || f.Name == "config" })
+
+	addrs := []string{c.connect}
+	switch {
+	case configSet && c.connect == "":
+		config, err := c.loadConfig()
 		if err != nil {
-			log.Err(err).Msg("Failed loading config")
-			return
-		}
-		// Try to send to all nodes at roughly the same time
-		var wg sync.WaitGroup
-		for _, netaddr := range c.Nodes {
-			wg.Add(1)
-			go func(addr string) {
-				defer wg.Done()
-				if err := com.Send(addr, msg); err != nil {
-					log.Err(err).Msg("Request failed")
-				}
-			}(netaddr)
+			return err
 		}
-		wg.Wait()
-	} else {
-		// Send request to single node
-		if err := com.Send(*connect, msg); err != nil {
-			log.Err(err).Msg("Request failed")
+		addrs = []string{}
+		for _, addr := range config.Nodes {
+			addrs = append(addrs, addr)
 		}
+	case c.connect == "":
+		addrs = []string{"127.0.0.1:4000"}
 	}
+	return c.sendTo(addrs, com.Msg(c.uid, msgType, payload))
 }
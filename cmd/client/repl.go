@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// builtins of the REPL in addition to the commands
+var builtins = []string{"help", "exit"}
+
+// repl executes commands read from in until exit or EOF
+func (c *client) repl(in *os.File) error {
+	r := newLineReader(in, c.out, c.completions)
+	if r.scanner == nil {
+		fmt.Fprintln(c.out, "Type help for a list of commands; TAB completes commands, flags and node UIDs")
+	}
+	for {
+		line, err := r.readLine("vaa> ")
+		if err == errInterrupt {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+			continue
+		}
+		switch {
+		case len(args) == 0:
+			continue
+		case args[0] == "exit" || args[0] == "quit":
+			return nil
+		case args[0] == "help":
+			usage(c.out, [2]string{"exit", "leave the shell"})
+			continue
+		}
+		if err := c.exec(args); err != nil {
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+		}
+	}
+}
+
+// completions returns the candidates replacing the last word of line
+func (c *client) completions(line string) []string {
+	words := strings.Fields(line)
+	cur := ""
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		cur, words = words[len(words)-1], words[:len(words)-1]
+	}
+
+	// Commands
+	var cmd *command
+	subs := commands
+	i := 0
+	for ; i < len(words) && (cmd == nil || cmd.setup == nil); i++ {
+		var next *command
+		for _, s := range subs {
+			if s.name == words[i] {
+				next = s
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		cmd, subs = next, next.subs
+	}
+	if cmd == nil || cmd.setup == nil {
+		candidates := names(subs)
+		if cmd == nil {
+			candidates = append(candidates, builtins...)
+		}
+		return withPrefix(candidates, cur)
+	}
+
+	// Flags and their values
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cmd.setup(c, fs)
+	if strings.HasPrefix(cur, "-") {
+		if i := strings.Index(cur, "="); i >= 0 {
+			values := c.values(cmd, strings.TrimLeft(cur[:i], "-"), cur[i+1:])
+			for j := range values {
+				values[j] = cur[:i+1] + values[j]
+			}
+			return values
+		}
+		flags := []string{}
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, "--"+f.Name) })
+		return withPrefix(flags, cur)
+	}
+	if i < len(words) {
+		if prev := words[len(words)-1]; strings.HasPrefix(prev, "-") && !strings.Contains(prev, "=") {
+			if f := fs.Lookup(strings.TrimLeft(prev, "-")); f != nil && !isBool(f) {
+				return c.values(cmd, f.Name, cur)
+			}
+		}
+	}
+	return nil
+}
+
+// values completes the value of a flag; UIDs of the config for --node (comma separated), choices or hints otherwise
+func (c *client) values(cmd *command, name, value string) []string {
+	if name != "node" {
+		return withPrefix(append(append([]string{}, cmd.choices[name]...), cmd.hints[name]...), value)
+	}
+	head, tail := "", value
+	if i := strings.LastIndex(value, ","); i >= 0 {
+		head, tail = value[:i+1], value[i+1:]
+	}
+	used := map[string]bool{}
+	for _, v := range strings.Split(head, ",") {
+		used[v] = true
+	}
+	candidates := []string{}
+	for _, uid := range c.uids() {
+		if s := fmt.Sprint(uid); !used[s] && strings.HasPrefix(s, tail) {
+			candidates = append(candidates, head+s)
+		}
+	}
+	return candidates
+}
+
+func withPrefix(vs []string, prefix string) []string {
+	matching := []string{}
+	for _, v := range vs {
+		if strings.HasPrefix(v, prefix) {
+			matching = append(matching, v)
+		}
+	}
+	return matching
+}
+
+func isBool(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// splitArgs splits a line into arguments; single and double quotes group words, e.g. --text "some rumor"
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`rumor spread  --text "some rumor" --c='2'`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rumor", "spread", "--text", "some rumor", "--c=2"}, args)
+
+	args, err = splitArgs("\t ")
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+
+	args, err = splitArgs(`send --payload ""`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"send", "--payload", ""}, args, "empty quotes are an argument")
+
+	_, err = splitArgs(`rumor spread --text "some rumor`)
+	assert.NotNil(t, err)
+}
+
+func TestCompletions(t *testing.T) {
+	c := testClient()
+	for line, expected := range map[string][]string{
+		"":                        append(names(commands), builtins...),
+		"ru":                      {"rumor"},
+		"e":                       {"election", "exit"},
+		"rumor ":                  {"spread"},
+		"rumor spread --t":        {"--text"},
+		"startup --node=":         {"--node=1", "--node=2", "--node=12"},
+		"startup --node=1,":       {"--node=1,2", "--node=1,12"},
+		"startup --node 1":        {"1", "12"},
+		"startup --all ":          nil,
+		"election start --type=B": {"--type=BANKING"},
+		"election start --type ":  {"BANKING", "CONSENSUS"},
+		"unknown ":                nil,
+	} {
+		actual := c.completions(line)
+		if expected == nil {
+			assert.Empty(t, actual, line)
+			continue
+		}
+		assert.Equal(t, expected, actual, line)
+	}
+	assert.Equal(t, []string{"--until=lock_acquired", "--until=lock_released"}, c.completions("watch --until=lock_"), "hints")
+}
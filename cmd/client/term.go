@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errInterrupt is returned by readLine on CTRL+C
+var errInterrupt = errors.New("interrupted")
+
+// lineReader reads lines from a terminal with history and tab completion; plain lines if stdin is not a terminal or
+// raw mode is not supported on this platform
+type lineReader struct {
+	in       *os.File
+	out      io.Writer
+	scanner  *bufio.Scanner // input is not a terminal
+	complete func(line string) []string
+	history  []string
+}
+
+func newLineReader(in *os.File, out io.Writer, complete func(string) []string) *lineReader {
+	r := &lineReader{in: in, out: out, complete: complete}
+	if !isTerminal(in) {
+		r.scanner = bufio.NewScanner(in)
+	}
+	return r
+}
+
+// readLine reads a line; the terminal is only in raw mode while reading
+func (r *lineReader) readLine(prompt string) (string, error) {
+	if r.scanner != nil {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return r.scanner.Text(), nil
+	}
+
+	restore, err := makeRaw(r.in)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	line := ""
+	pos := len(r.history) // position in the history, len(history) is the current line
+	redraw := func() { fmt.Fprintf(r.out, "\r\x1b[K%s%s", prompt, line) }
+	redraw()
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.in.Read(buf); err != nil {
+			return "", err
+		}
+		switch b := buf[0]; {
+		case b == '\r' || b == '\n':
+			fmt.Fprint(r.out, "\r\n")
+			if strings.TrimSpace(line) != "" {
+				r.history = append(r.history, line)
+			}
+			return line, nil
+		case b == 3: // CTRL+C
+			fmt.Fprint(r.out, "^C\r\n")
+			return "", errInterrupt
+		case b == 4: // CTRL+D
+			if line == "" {
+				fmt.Fprint(r.out, "\r\n")
+				return "", io.EOF
+			}
+		case b == 127 || b == 8: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				redraw()
+			}
+		case b == '\t':
+			line = r.completeLine(line, redraw)
+			redraw()
+		case b == 0x1b: // Escape sequence, only up/down are supported
+			seq := make([]byte, 2)
+			if _, err := io.ReadFull(r.in, seq); err != nil {
+				return "", err
+			}
+			switch {
+			case seq[0] == '[' && seq[1] == 'A' && pos > 0:
+				pos--
+				line = r.history[pos]
+			case seq[0] == '[' && seq[1] == 'B' && pos < len(r.history):
+				pos++
+				line = ""
+				if pos < len(r.history) {
+					line = r.history[pos]
+				}
+			}
+			redraw()
+		case b >= 0x20 && b < 0x7f:
+			line += string(b)
+			fmt.Fprint(r.out, string(b))
+		}
+	}
+}
+
+// completeLine replaces the last word with the single candidate or their common prefix; candidates are listed if
+// the word can not be extended
+func (r *lineReader) completeLine(line string, redraw func()) string {
+	candidates := r.complete(line)
+	if len(candidates) == 0 {
+		return line
+	}
+	start := strings.LastIndexAny(line, " ") + 1
+	word := line[start:]
+	if len(candidates) == 1 {
+		c := candidates[0]
+		if !strings.HasSuffix(c, "=") && !strings.HasSuffix(c, ",") {
+			c += " "
+		}
+		return line[:start] + c
+	}
+	if p := commonPrefix(candidates); len(p) > len(word) {
+		return line[:start] + p
+	}
+	sort.Strings(candidates)
+	fmt.Fprintf(r.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	return line
+}
+
+func commonPrefix(vs []string) string {
+	p := vs[0]
+	for _, v := range vs[1:] {
+		for !strings.HasPrefix(v, p) {
+			p = p[:len(p)-1]
+		}
+	}
+	return p
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// isTerminal is always false, input is read line by line without history and completion
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
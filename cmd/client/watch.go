@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xvzf/vaa/internal/cluster"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// condition matches events of a kind, optionally with attributes, e.g. `consensus_result:agreement=true`
+type condition struct {
+	kind  string
+	attrs map[string]string
+}
+
+func parseCondition(s string) (*condition, error) {
+	ps := strings.SplitN(s, ":", 2)
+	if !contains(node.EventKinds, ps[0]) {
+		return nil, fmt.Errorf("invalid event %q, one of %s", ps[0], strings.Join(node.EventKinds, ", "))
+	}
+	cond := &condition{kind: ps[0], attrs: map[string]string{}}
+	if len(ps) == 2 {
+		for _, kv := range strings.Split(ps[1], ",") {
+			p := strings.SplitN(kv, "=", 2)
+			if len(p) != 2 || p[0] == "" {
+				return nil, fmt.Errorf("invalid attribute %q, expected key=value", kv)
+			}
+			cond.attrs[p[0]] = p[1]
+		}
+	}
+	return cond, nil
+}
+
+func (cond *condition) matches(e *node.Event) bool {
+	if e.Kind != cond.kind {
+		return false
+	}
+	for k, v := range cond.attrs {
+		if a, ok := e.Attrs[k]; !ok || fmt.Sprint(a) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// formatEvent prints an event on a single line, attributes sorted by key
+func formatEvent(e *node.Event) string {
+	keys := []string{}
+	for k := range e.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := []string{}
+	for _, k := range keys {
+		attrs = append(attrs, fmt.Sprintf("%s=%v", k, e.Attrs[k]))
+	}
+	return fmt.Sprintf("%s  node %-3d  %-16s  %s", e.Time.Local().Format("15:04:05.000"), e.UID, e.Kind, strings.Join(attrs, " "))
+}
+
+func setupWatch(c *client, fs *flag.FlagSet) func() error {
+	t := targetFlags(fs)
+	listen := fs.String("listen", "", "address the nodes report their events to; a free port on 127.0.0.1 if empty")
+	until := fs.String("until", "", "exit once an event matching <kind>[:<key>=<value>,...] occurred, e.g. leader_elected:type=CONSENSUS")
+	count := fs.Int("count", 1, "number of matching events for --until, e.g. the number of nodes")
+	timeout := fs.Duration("timeout", 0, "give up waiting after this duration; no limit if 0")
+	asJSON := fs.Bool("json", false, "print the events as JSON lines")
+	return func() error {
+		var cond *condition
+		if *until != "" {
+			var err error
+			if cond, err = parseCondition(*until); err != nil {
+				return usageError{err}
+			}
+		}
+		if *count < 1 {
+			return usageError{fmt.Errorf("--count has to be at least 1, got %d", *count)}
+		}
+		// Events are reported by every node, watch all of them unless selected otherwise
+		if t.nodes == "" && c.connect == "" {
+			t.all = true
+		}
+		addrs, err := c.addrs(t)
+		if err != nil {
+			return err
+		}
+		if *listen == "" {
+			ports, err := cluster.FreePorts(1)
+			if err != nil {
+				return err
+			}
+			*listen = fmt.Sprintf("127.0.0.1:%d", ports[0])
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		recv, err := listenEvents(ctx, *listen)
+		if err != nil {
+			return err
+		}
+		if err := deliver(addrs, com.Msg(c.uid, "CONTROL", "WATCH "+*listen)); err != nil {
+			return err
+		}
+		defer deliver(addrs, com.Msg(c.uid, "CONTROL", "UNWATCH "+*listen))
+
+		matched := 0
+		for {
+			select {
+			case e := <-recv:
+				if *asJSON {
+					b, _ := json.Marshal(e)
+					fmt.Fprintln(c.out, string(b))
+				} else {
+					fmt.Fprintln(c.out, formatEvent(e))
+				}
+				if cond != nil && cond.matches(e) {
+					if matched = matched + 1; matched >= *count {
+						return nil
+					}
+				}
+			case <-ctx.Done():
+				if cond == nil {
+					return nil
+				}
+				if ctx.Err() == context.DeadlineExceeded {
+					return fmt.Errorf("timeout waiting for %s, %d/%d events", *until, matched, *count)
+				}
+				return errors.New("interrupted")
+			}
+		}
+	}
+}
+
+// listenEvents decodes the events reported to listen until ctx is cancelled
+func listenEvents(ctx context.Context, listen string) (<-chan *node.Event, error) {
+	msgs := make(chan *com.Message, 64)
+	d := com.NewDispatcher(listen, msgs)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.Run(ctx)
+	}()
+	// Give the dispatcher time to listen before subscribing
+	select {
+	case err := <-errs:
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	events := make(chan *node.Event, 64)
+	go func() {
+		for {
+			select {
+			case msg := <-msgs:
+				if !strings.HasPrefix(*msg.Payload, "EVENT ") {
+					continue
+				}
+				e := &node.Event{}
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(*msg.Payload, "EVENT ")), e); err != nil {
+					fmt.Fprintf(os.Stderr, "invalid event from node %d: %v\n", *msg.SourceUID, err)
+					continue
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
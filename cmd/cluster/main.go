@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/cluster"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+func main() {
+	config := flag.String("config", "", "path to config file; generated with free ports if empty")
+	graph := flag.String("graph", "", "path to graph; generated from --topology if empty")
+	topology := flag.String("topology", "random", "topology of a generated graph ("+strings.Join(neigh.Topologies(), ", ")+"); the nodes form a random overlay of degree 3 if empty")
+	n := flag.Uint("n", 7, "number of nodes of a generated config and graph")
+	m := flag.Uint("m", 0, "number of edges of a generated graph (random), edges per new node (barabasi-albert); derived from --n if 0")
+	k := flag.Uint("k", 4, "degree of a generated graph (tree, watts-strogatz, regular)")
+	seed := flag.Int64("seed", 1, "seed of a generated graph")
+	dir := flag.String("dir", "", "directory for generated files and the node binary; temporary if empty")
+	inProcess := flag.Bool("in-process", false, "run all nodes in this process instead of spawning node processes")
+	nodeBin := flag.String("node-bin", "", "node binary; built from cmd/node if empty")
+	readyTimeout := flag.Duration("ready-timeout", 30*time.Second, "time to wait for all nodes to accept connections")
+	startup := flag.Bool("startup", false, "send CONTROL STARTUP to all nodes once they are ready")
+	flag.Usage = func() {
+		os.Stderr.WriteString("Usage: cluster [flags] [-- <node flags>...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	c, err := cluster.New(cluster.Options{
+		Config:    *config,
+		Graph:     *graph,
+		Topology:  *topology,
+		Params:    neigh.TopologyParams{N: *n, M: *m, K: *k, Rows: 3, Cols: 3, Dim: 3, P: 0.3, Beta: 0.2, Connectivity: 1},
+		Seed:      *seed,
+		Dir:       *dir,
+		InProcess: *inProcess,
+		NodeBin:   *nodeBin,
+		NodeArgs:  flag.Args(),
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to prepare cluster")
+		os.Exit(1)
+	}
+	configFile, graphFile := c.Files()
+	log.Info().Str("config", configFile).Str("graph", graphFile).Msgf("Starting %d nodes", len(c.Config.Nodes))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		log.Err(err).Msg("Failed to start cluster")
+		os.Exit(1)
+	}
+	if err := c.Ready(ctx, *readyTimeout); err != nil {
+		log.Err(err).Msg("Cluster not ready")
+		c.Stop()
+		os.Exit(1)
+	}
+	log.Info().Msgf("All %d nodes are ready, stop with CTRL+C", len(c.Config.Nodes))
+	if *startup {
+		if err := c.SendAll("CONTROL", "STARTUP"); err != nil {
+			log.Err(err).Msg("Failed to send STARTUP")
+		}
+	}
+
+	osc := make(chan os.Signal, 1)
+	signal.Notify(osc, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-osc:
+		log.Info().Msg("Received CTRL+C, shutting down all nodes")
+		c.Stop()
+	case <-c.Done():
+		log.Info().Msg("All nodes exited")
+	}
+}
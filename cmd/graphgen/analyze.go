@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// analyze implements `graphgen analyze`, it prints the structure of a graph
+func analyze(args []string) int {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	graph := fs.String("graph", "./graph.txt", "path to graph definition")
+	inFormat := fs.String("in-format", "", "format of the graph ("+strings.Join(neigh.Formats, ", ")+"); guessed from the file extension if empty")
+	config := fs.String("config", "", "path to config file; its nodes are analyzed (including isolated ones) instead of the nodes of the graph")
+	asJSON := fs.Bool("json", false, "print the analysis as JSON")
+	fs.Parse(args)
+
+	nm, err := neigh.LoadGraphFormat(*graph, formatOf(*inFormat, *graph))
+	if err != nil {
+		log.Err(err).Msg("Failed to read graph file")
+		return 1
+	}
+	uids := nm.UIDs()
+	if *config != "" {
+		c, err := neigh.LoadConfig(*config)
+		if err != nil {
+			log.Err(err).Msg("Failed to read config file")
+			return 1
+		}
+		uids = []uint{}
+		for uid := range c.Nodes {
+			uids = append(uids, uid)
+		}
+	}
+
+	a := neigh.AnalyzeNodes(nm, uids)
+	if *asJSON {
+		b, _ := json.MarshalIndent(a, "", "  ")
+		fmt.Println(string(b))
+		return 0
+	}
+	printAnalysis(a)
+	return 0
+}
+
+func printAnalysis(a *neigh.Analysis) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	kind := "undirected"
+	if a.Directed {
+		kind = "directed (analyzed as undirected)"
+	}
+	sizes := []string{}
+	for _, c := range a.Components {
+		sizes = append(sizes, fmt.Sprint(len(c)))
+	}
+	fmt.Fprintf(w, "graph:\t%s\n", kind)
+	fmt.Fprintf(w, "nodes:\t%d\n", a.Nodes)
+	fmt.Fprintf(w, "edges:\t%d\n", a.Edges)
+	fmt.Fprintf(w, "components:\t%d (sizes %s)\n", len(a.Components), strings.Join(sizes, ", "))
+	if !a.Connected() {
+		fmt.Fprintf(w, "\tdiameter, radius and center refer to the components\n")
+	}
+	fmt.Fprintf(w, "diameter:\t%d\n", a.Diameter)
+	fmt.Fprintf(w, "radius:\t%d\n", a.Radius)
+	fmt.Fprintf(w, "center:\t%s\n", join(a.Center))
+	fmt.Fprintf(w, "degree:\tmin %d, mean %.2f, max %d\n", a.MinDegree, a.MeanDegree, a.MaxDegree)
+	degrees := []uint{}
+	for d := range a.DegreeDistribution {
+		degrees = append(degrees, d)
+	}
+	sort.Slice(degrees, func(i, j int) bool { return degrees[i] < degrees[j] })
+	for _, d := range degrees {
+		fmt.Fprintf(w, "\t%3d: %s %d\n", d, strings.Repeat("#", int(a.DegreeDistribution[d]*40/a.Nodes)), a.DegreeDistribution[d])
+	}
+	bridges := []string{}
+	for _, e := range a.Bridges {
+		bridges = append(bridges, fmt.Sprintf("%d -- %d", e.From, e.To))
+	}
+	fmt.Fprintf(w, "bridges:\t%d %s\n", len(a.Bridges), list(bridges))
+	points := []string{}
+	for _, uid := range a.ArticulationPoints {
+		points = append(points, fmt.Sprint(uid))
+	}
+	fmt.Fprintf(w, "articulation points:\t%d %s\n", len(a.ArticulationPoints), list(points))
+	if a.Cycles == 0 {
+		fmt.Fprintf(w, "cycles:\tnone (forest)\n")
+	} else {
+		fmt.Fprintf(w, "cycles:\t%d independent, girth %d\n", a.Cycles, a.Girth)
+	}
+	fmt.Fprintf(w, "vertex connectivity:\t%d\n", a.VertexConnectivity)
+}
+
+func join(uids []uint) string {
+	s := []string{}
+	for _, uid := range uids {
+		s = append(s, fmt.Sprint(uid))
+	}
+	return strings.Join(s, ", ")
+}
+
+// list formats at most 20 elements, the JSON output contains all
+func list(s []string) string {
+	if len(s) == 0 {
+		return ""
+	} else if len(s) > 20 {
+		return "(" + strings.Join(s[:20], ", ") + fmt.Sprintf(", ... %d more)", len(s)-20)
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"flag"
+	"math/rand"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -16,23 +19,78 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "analyze":
+			os.Exit(analyze(os.Args[2:]))
+		case "render":
+			os.Exit(render(os.Args[2:]))
+		}
+	}
+
 	graph := flag.String("graph", "./graph.txt", "path to graph definition")
+	topology := flag.String("topology", "random", "topology of a created graph ("+strings.Join(neigh.Topologies(), ", ")+")")
 	n := flag.Int("n", 6, "number of nodes")
-	m := flag.Int("m", 10, "number of edges")
+	m := flag.Int("m", 10, "number of edges (random), edges per new node (barabasi-albert)")
+	k := flag.Int("k", 2, "children per node (tree), neighbours in the ring lattice (watts-strogatz)")
+	rows := flag.Int("rows", 3, "rows (grid, torus)")
+	cols := flag.Int("cols", 3, "columns (grid, torus)")
+	dim := flag.Int("dim", 3, "dimension (hypercube)")
+	p := flag.Float64("p", 0.3, "edge probability (erdos-renyi)")
+	beta := flag.Float64("beta", 0.2, "rewiring probability (watts-strogatz)")
+	seed := flag.Int64("seed", 0, "seed of a created graph; the same seed and parameters yield the same graph, random if 0")
+	connectivity := flag.Uint("connectivity", 1, "minimum vertex connectivity of a created graph; random topologies are augmented, others fail")
 	create := flag.Bool("create", false, "Create or read the file")
+	formats := strings.Join(neigh.Formats, ", ")
+	inFormat := flag.String("in-format", "", "format of the graph that is read ("+formats+"); guessed from the file extension if empty")
+	format := flag.String("format", "", "format of the written graph ("+formats+"); guessed from the file extension if empty")
+	out := flag.String("out", "", "convert the graph and store it in this file")
+	maxWeight := flag.Int("max-weight", 0, "assign random edge weights in [1, max-weight] when creating a graph; no weights if 0")
 	flag.Parse()
 
 	if *create {
-		log.Info().Msgf("Generating graph with n: %d, m: %d", *n, *m)
-		err := neigh.GenGraph(*graph, uint(*n), uint(*m))
+		if *seed == 0 {
+			*seed = time.Now().UTC().UnixNano()
+		}
+		log.Info().Msgf("Generating %s graph with n: %d, m: %d, seed: %d", *topology, *n, *m, *seed)
+		r := rand.New(rand.NewSource(*seed))
+		nm, err := neigh.GenTopology(r, *topology, neigh.TopologyParams{
+			N: uint(*n), M: uint(*m), K: uint(*k), Rows: uint(*rows), Cols: uint(*cols), Dim: uint(*dim), P: *p, Beta: *beta,
+			Connectivity: *connectivity,
+		})
 		if err != nil {
 			log.Err(err).Msg("Failed to generate graph file with given arguments")
+			os.Exit(1)
+		}
+		if *maxWeight > 0 {
+			neigh.RandomWeights(r, nm, *maxWeight)
+		}
+		if err := neigh.WriteGraphFormat(*graph, formatOf(*format, *graph), nm); err != nil {
+			log.Err(err).Msg("Failed to store graph")
+			os.Exit(1)
 		}
 		log.Info().Msgf("Stored generated graph in %s", *graph)
-	} else {
-		_, err := neigh.LoadGraph(*graph)
-		if err != nil {
-			log.Err(err).Msg("Failed to read graph file")
+		return
+	}
+
+	nm, err := neigh.LoadGraphFormat(*graph, formatOf(*inFormat, *graph))
+	if err != nil {
+		log.Err(err).Msg("Failed to read graph file")
+		os.Exit(1)
+	}
+	if *out != "" {
+		if err := neigh.WriteGraphFormat(*out, formatOf(*format, *out), nm); err != nil {
+			log.Err(err).Msg("Failed to store graph")
+			os.Exit(1)
 		}
+		log.Info().Msgf("Converted %s to %s (%s)", *graph, *out, formatOf(*format, *out))
+	}
+}
+
+// formatOf returns the explicitly set format or guesses it from the path
+func formatOf(format, path string) string {
+	if format != "" {
+		return format
 	}
+	return neigh.FormatFromPath(path)
 }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+const (
+	margin     = 40
+	nodeRadius = 12
+	treeColor  = "#d62728"
+	trustColor = "#2ca02c"
+)
+
+type point struct {
+	x, y float64
+}
+
+// render implements `graphgen render`, it draws a graph as SVG with optional overlays from a state dump
+func render(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	graph := fs.String("graph", "./graph.txt", "path to graph definition")
+	inFormat := fs.String("in-format", "", "format of the graph ("+strings.Join(neigh.Formats, ", ")+"); guessed from the file extension if empty")
+	out := fs.String("out", "graph.svg", "output file, `-` for stdout")
+	layout := fs.String("layout", "force", "node placement: `force` (force-directed) or `circular`")
+	size := fs.Int("size", 800, "width and height of the drawing in pixels")
+	seed := fs.Int64("seed", 1, "seed of the force-directed layout")
+	iterations := fs.Int("iterations", 300, "iterations of the force-directed layout")
+	states := fs.String("states", "", "JSON state dump (sim --states, invariants --states) to overlay the spanning tree, trusted rumors and labels")
+	rumor := fs.String("rumor", "", "only color nodes trusting this rumor; any rumor if empty")
+	label := fs.String("label", "", "state value to label nodes with, e.g. `balance` or `t_k`; guessed from the extensions if empty")
+	fs.Parse(args)
+
+	nm, err := neigh.LoadGraphFormat(*graph, formatOf(*inFormat, *graph))
+	if err != nil {
+		log.Err(err).Msg("Failed to read graph file")
+		return 1
+	}
+	o := &overlay{rumor: *rumor, label: *label}
+	if *states != "" {
+		if o.states, err = loadStates(*states); err != nil {
+			log.Err(err).Msg("Failed to read state dump")
+			return 1
+		}
+	}
+
+	uids := nm.UIDs()
+	var pos map[uint]point
+	switch *layout {
+	case "circular":
+		pos = circularLayout(uids, float64(*size))
+	case "force":
+		pos = forceLayout(rand.New(rand.NewSource(*seed)), uids, nm, float64(*size), *iterations)
+	default:
+		log.Error().Msgf("Unsupported layout `%s`", *layout)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		fd, err := os.Create(*out)
+		if err != nil {
+			log.Err(err).Msg("Failed to create output file")
+			return 1
+		}
+		defer fd.Close()
+		w = fd
+	}
+	renderGraph(w, nm, pos, o, *size)
+	log.Info().Msgf("Stored %d nodes in %s", len(uids), *out)
+	return 0
+}
+
+func loadStates(path string) (map[uint]*node.NodeState, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	states := map[uint]*node.NodeState{}
+	return states, json.Unmarshal(b, &states)
+}
+
+// circularLayout places the nodes on a circle in ascending order
+func circularLayout(uids []uint, size float64) map[uint]point {
+	pos := map[uint]point{}
+	r := size/2 - margin
+	for i, uid := range uids {
+		a := 2*math.Pi*float64(i)/float64(len(uids)) - math.Pi/2
+		pos[uid] = point{size/2 + r*math.Cos(a), size/2 + r*math.Sin(a)}
+	}
+	return pos
+}
+
+// forceLayout places the nodes with the Fruchterman-Reingold algorithm: all nodes repel each other, edges attract their
+// end nodes; the maximum displacement cools down linearly
+func forceLayout(r *rand.Rand, uids []uint, nm *neigh.NeighMap, size float64, iterations int) map[uint]point {
+	pos := map[uint]point{}
+	for _, uid := range uids {
+		pos[uid] = point{r.Float64() * size, r.Float64() * size}
+	}
+	if len(uids) < 2 {
+		return normalize(pos, size)
+	}
+	k := math.Sqrt(size * size / float64(len(uids)))
+	for i := 0; i < iterations; i++ {
+		disp := map[uint]point{}
+		push := func(u, v uint, f func(d float64) float64) {
+			dx, dy := pos[u].x-pos[v].x, pos[u].y-pos[v].y
+			d := math.Max(math.Hypot(dx, dy), 0.01)
+			m := f(d) / d
+			disp[u] = point{disp[u].x + dx*m, disp[u].y + dy*m}
+			disp[v] = point{disp[v].x - dx*m, disp[v].y - dy*m}
+		}
+		for a := range uids {
+			for b := a + 1; b < len(uids); b++ {
+				push(uids[a], uids[b], func(d float64) float64 { return k * k / d })
+			}
+		}
+		for a, v := range nm.Neighs {
+			for _, b := range v {
+				push(a, b, func(d float64) float64 { return -d * d / k })
+			}
+		}
+		temp := size / 10 * (1 - float64(i)/float64(iterations))
+		for _, uid := range uids {
+			d := math.Max(math.Hypot(disp[uid].x, disp[uid].y), 0.01)
+			m := math.Min(d, temp) / d
+			pos[uid] = point{pos[uid].x + disp[uid].x*m, pos[uid].y + disp[uid].y*m}
+		}
+	}
+	return normalize(pos, size)
+}
+
+// normalize scales the positions into the drawing area
+func normalize(pos map[uint]point, size float64) map[uint]point {
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range pos {
+		minX, minY, maxX, maxY = math.Min(minX, p.x), math.Min(minY, p.y), math.Max(maxX, p.x), math.Max(maxY, p.y)
+	}
+	scale := func(v, min, max float64) float64 {
+		if max == min {
+			return size / 2
+		}
+		return margin + (v-min)/(max-min)*(size-2*margin)
+	}
+	for uid, p := range pos {
+		pos[uid] = point{scale(p.x, minX, maxX), scale(p.y, minY, maxY)}
+	}
+	return pos
+}
+
+// overlay holds the algorithm results drawn on top of the graph
+type overlay struct {
+	states map[uint]*node.NodeState
+	rumor  string
+	label  string
+}
+
+// treeExt returns the first extension (in alphabetical order) exposing a spanning tree, e.g. BANKING or CONSENSUS
+func (o *overlay) treeExt() string {
+	exts := map[string]bool{}
+	for _, s := range o.states {
+		for ext, v := range s.Extensions {
+			if _, ok := v["parent"]; ok {
+				exts[ext] = true
+			}
+		}
+	}
+	names := []string{}
+	for ext := range exts {
+		names = append(names, ext)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// treeEdges returns the parent of every node in the spanning tree and the leader
+func (o *overlay) treeEdges() (map[neigh.Edge]bool, uint) {
+	ext := o.treeExt()
+	edges := map[neigh.Edge]bool{}
+	var leader uint
+	if ext == "" {
+		return edges, 0
+	}
+	for uid, s := range o.states {
+		if s.Bool(ext, "is_leader") {
+			leader = uid
+		} else if p := uint(s.Int(ext, "parent")); p != 0 {
+			edges[neigh.Edge{From: p, To: uid}] = true
+		}
+	}
+	return edges, leader
+}
+
+// trusts checks if a node trusts the selected rumor (or any if none is selected)
+func (o *overlay) trusts(uid uint) bool {
+	s, ok := o.states[uid]
+	if !ok {
+		return false
+	}
+	for _, rm := range s.Strings("RUMOR", "trusted") {
+		if o.rumor == "" || rm == o.rumor {
+			return true
+		}
+	}
+	return false
+}
+
+// labelKey returns the value nodes are labeled with
+func (o *overlay) labelKey() string {
+	if o.label != "" {
+		return o.label
+	}
+	for _, s := range o.states {
+		switch {
+		case s.Has("BANKING"):
+			return "balance"
+		case s.Has("CONSENSUS"):
+			return "t_k"
+		}
+	}
+	return ""
+}
+
+// value looks up a state value in the extensions of a node (in alphabetical order)
+func (o *overlay) value(uid uint, key string) (string, bool) {
+	s, ok := o.states[uid]
+	if !ok || key == "" {
+		return "", false
+	}
+	exts := []string{}
+	for ext := range s.Extensions {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		if v, ok := s.Extensions[ext][key]; ok {
+			return fmt.Sprint(v), true
+		}
+	}
+	return "", false
+}
+
+// renderGraph writes the graph as SVG; spanning tree edges are drawn thick, the leader with a double ring, nodes trusting
+// the rumor are filled
+func renderGraph(w io.Writer, nm *neigh.NeighMap, pos map[uint]point, o *overlay, size int) {
+	tree, leader := o.treeEdges()
+	key := o.labelKey()
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`+"\n", size, size)
+	fmt.Fprintln(w, `<defs>`)
+	for _, m := range [][2]string{{"arrow", "#999"}, {"arrow-tree", treeColor}} {
+		fmt.Fprintf(w, `<marker id="%s" markerWidth="8" markerHeight="8" markerUnits="userSpaceOnUse" refX="%d" refY="4" orient="auto"><path d="M0,0 L8,4 L0,8 z" fill="%s"/></marker>`+"\n", m[0], 8+nodeRadius, m[1])
+	}
+	fmt.Fprintln(w, `</defs>`)
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", size, size)
+
+	// Edges; tree edges point from the parent to the child
+	for _, a := range sortedNeighs(nm) {
+		for _, b := range nm.Neighs[a] {
+			from, to, stroke, width, marker := a, b, "#999", 1, "arrow"
+			if tree[neigh.Edge{From: b, To: a}] {
+				from, to = b, a
+			}
+			if tree[neigh.Edge{From: from, To: to}] {
+				stroke, width, marker = treeColor, 3, "arrow-tree"
+			}
+			end := ""
+			if nm.Directed || marker == "arrow-tree" {
+				end = fmt.Sprintf(` marker-end="url(#%s)"`, marker)
+			}
+			title := fmt.Sprintf("%d -- %d", a, b)
+			if attrs := nm.Attr(a, b).Map(); len(attrs) > 0 {
+				title = fmt.Sprintf("%s %v", title, attrs)
+			}
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="%d"%s><title>%s</title></line>`+"\n",
+				pos[from].x, pos[from].y, pos[to].x, pos[to].y, stroke, width, end, html.EscapeString(title))
+		}
+	}
+
+	// Nodes
+	uids := []uint{}
+	for uid := range pos {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	for _, uid := range uids {
+		p := pos[uid]
+		fill := "#fff"
+		if o.trusts(uid) {
+			fill = trustColor
+		}
+		title := fmt.Sprintf("node %d", uid)
+		if s, ok := o.states[uid]; ok {
+			b, _ := json.Marshal(s.Extensions)
+			title = fmt.Sprintf("%s %s", title, b)
+		}
+		fmt.Fprintf(w, `<g><title>%s</title>`, html.EscapeString(title))
+		if uid == leader {
+			fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="%d" fill="none" stroke="%s" stroke-width="2"/>`, p.x, p.y, nodeRadius+4, treeColor)
+		}
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="%d" fill="%s" stroke="#000"/>`, p.x, p.y, nodeRadius, fill)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle" dominant-baseline="central">%d</text>`, p.x, p.y, uid)
+		if v, ok := o.value(uid, key); ok {
+			fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle" fill="#1f77b4">%s</text>`, p.x, p.y+nodeRadius+14, html.EscapeString(v))
+		}
+		fmt.Fprintln(w, `</g>`)
+	}
+
+	// Legend
+	legend := []string{}
+	if len(tree) > 0 {
+		legend = append(legend, fmt.Sprintf(`<tspan fill="%s">spanning tree (%s)</tspan>`, treeColor, o.treeExt()))
+	}
+	if o.states != nil {
+		trusted := 0
+		for _, uid := range uids {
+			if o.trusts(uid) {
+				trusted++
+			}
+		}
+		if trusted > 0 {
+			legend = append(legend, fmt.Sprintf(`<tspan fill="%s">%d/%d trust %s</tspan>`, trustColor, trusted, len(uids), html.EscapeString(rumorName(o.rumor))))
+		}
+	}
+	if key != "" && o.states != nil {
+		legend = append(legend, fmt.Sprintf(`<tspan fill="#1f77b4">label: %s</tspan>`, html.EscapeString(key)))
+	}
+	if len(legend) > 0 {
+		fmt.Fprintf(w, `<text x="5" y="15">%s</text>`+"\n", strings.Join(legend, " "))
+	}
+	fmt.Fprintln(w, `</svg>`)
+}
+
+func rumorName(rm string) string {
+	if rm == "" {
+		return "a rumor"
+	}
+	return "`" + rm + "`"
+}
+
+// sortedNeighs returns the source UIDs of all edges in ascending order
+func sortedNeighs(nm *neigh.NeighMap) []uint {
+	uids := []uint{}
+	for uid := range nm.Neighs {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/cluster"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+// report of a single snapshot
+type report struct {
+	Snapshot   string            `json:"snapshot"`
+	Time       time.Time         `json:"time"`
+	Nodes      int               `json:"nodes"`
+	Missing    []uint            `json:"missing,omitempty"` // nodes that did not report in time
+	Violations []*node.Violation `json:"violations"`
+}
+
+func main() {
+	config := flag.String("config", "./config.txt", "path to config file")
+	listen := flag.String("listen", "127.0.0.1:3999", "address the nodes report their state to")
+	connect := flag.String("connect", "", "node initiating the snapshot; first node of the config if empty")
+	invariants := flag.String("invariants", "", "comma separated invariants, e.g. `leader:BANKING,mutex,money`")
+	interval := flag.Duration("interval", 0, "take a snapshot every interval; a single snapshot (including eventually invariants) if 0")
+	timeout := flag.Duration("timeout", 10*time.Second, "time to wait for all nodes to report")
+	states := flag.String("states", "", "write the states of the last snapshot as JSON to this file, e.g. for graphgen render --states")
+	flag.Parse()
+
+	c, err := neigh.LoadConfig(*config)
+	if err != nil {
+		log.Err(err).Msg("Failed loading config")
+		os.Exit(1)
+	}
+	invs, err := node.ParseInvariants(*invariants)
+	if err != nil {
+		log.Err(err).Msg("Invalid invariants")
+		os.Exit(1)
+	}
+	if *connect == "" {
+		if len(c.Nodes) == 0 {
+			log.Err(errors.New("config without nodes")).Msg("No node to initiate the snapshot")
+			os.Exit(1)
+		}
+		uids := []uint{}
+		for uid := range c.Nodes {
+			uids = append(uids, uid)
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+		*connect = c.Nodes[uids[0]]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		osc := make(chan os.Signal, 1)
+		signal.Notify(osc, os.Interrupt)
+		<-osc
+		cancel()
+	}()
+
+	// Nodes report their part of the snapshot here
+	co, err := cluster.NewCollector(ctx, *listen)
+	if err != nil {
+		log.Err(err).Msg("Dispatcher failed")
+		os.Exit(1)
+	}
+
+	violated := false
+	for done := false; !done; {
+		snap := co.Collect(ctx, c.Nodes, *connect, *timeout)
+		r := &report{Snapshot: snap.ID, Time: snap.Time, Nodes: len(snap.States), Missing: snap.Missing}
+		r.Violations = node.CheckInvariants(snap.States, invs, *interval == 0)
+		violated = violated || len(r.Violations) > 0
+
+		b, _ := json.Marshal(r)
+		fmt.Println(string(b))
+		for _, v := range r.Violations {
+			log.Warn().Str("invariant", v.Invariant).Msgf("Invariant violated: %s", v.Error)
+		}
+		if *states != "" {
+			b, _ := json.MarshalIndent(snap.States, "", "  ")
+			if err := os.WriteFile(*states, b, 0644); err != nil {
+				log.Err(err).Msg("Failed to store states")
+			}
+		}
+
+		if *interval == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-time.After(*interval):
+		}
+	}
+
+	if violated {
+		os.Exit(2)
+	}
+}
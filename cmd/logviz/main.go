@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+// parseTime accepts RFC3339 timestamps or an empty string
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func main() {
+	out := flag.String("out", "spacetime.html", "output file (`.svg` or `.html`), `-` for stdout")
+	format := flag.String("format", "", "output format `svg` or `html`; derived from --out if empty")
+	scale := flag.String("scale", "lamport", "x-axis: `lamport` (logical time) or `time` (wall clock)")
+	types := flag.String("type", "", "comma separated list of message types to include, e.g. CONSENSUS,BANKING")
+	from := flag.String("from", "", "only include events after this RFC3339 timestamp")
+	to := flag.String("to", "", "only include events before this RFC3339 timestamp")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Error().Msg("Usage: logviz [flags] <node-log.json>...")
+		os.Exit(1)
+	}
+	if *scale != "lamport" && *scale != "time" {
+		log.Error().Msgf("Unsupported scale `%s`", *scale)
+		os.Exit(1)
+	}
+
+	// Filters
+	f := &filter{types: map[string]bool{}}
+	for _, t := range strings.Split(*types, ",") {
+		if t != "" {
+			f.types[t] = true
+		}
+	}
+	var err error
+	if f.from, err = parseTime(*from); err != nil {
+		log.Err(err).Msg("Invalid --from")
+		os.Exit(1)
+	}
+	if f.to, err = parseTime(*to); err != nil {
+		log.Err(err).Msg("Invalid --to")
+		os.Exit(1)
+	}
+
+	// Read logs of all nodes
+	entries := []*logEntry{}
+	for _, path := range flag.Args() {
+		e, err := readEntries(path)
+		if err != nil {
+			log.Err(err).Msgf("Failed reading %s", path)
+			os.Exit(1)
+		}
+		log.Info().Msgf("Read %d message events from %s", len(e), path)
+		entries = append(entries, e...)
+	}
+
+	t := buildTrace(entries, f)
+	log.Info().Msgf("Matched %d messages across %d nodes", len(t.messages), len(t.nodes))
+
+	// Render
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*out), ".")
+	}
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		fd, err := os.Create(*out)
+		if err != nil {
+			log.Err(err).Msg("Failed to create output file")
+			os.Exit(1)
+		}
+		defer fd.Close()
+		w = fd
+	}
+	switch *format {
+	case "svg":
+		renderSVG(w, t, *scale)
+	case "html":
+		renderHTML(w, t, *scale)
+	default:
+		log.Error().Msgf("Unsupported format `%s`", *format)
+		os.Exit(1)
+	}
+	log.Info().Msgf("Stored space-time diagram in %s", *out)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	marginLeft  = 80
+	marginTop   = 30
+	rowHeight   = 50
+	lamportStep = 30
+	timeWidth   = 1600
+	dangling    = 20 // length of arrows without matching send/receive
+)
+
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// layout maps events to coordinates
+type layout struct {
+	t      *trace
+	scale  string
+	row    map[uint]int
+	colors map[string]string
+	width  int
+	height int
+	x      func(e *event) float64
+}
+
+func newLayout(t *trace, scale string) *layout {
+	l := &layout{t: t, scale: scale, row: map[uint]int{}, colors: map[string]string{}}
+	for i, uid := range t.nodes {
+		l.row[uid] = i
+	}
+
+	types := []string{}
+	for _, m := range t.messages {
+		if _, ok := l.colors[m.msgType]; !ok {
+			l.colors[m.msgType] = ""
+			types = append(types, m.msgType)
+		}
+	}
+	sort.Strings(types)
+	for i, mt := range types {
+		l.colors[mt] = palette[i%len(palette)]
+	}
+
+	if scale == "time" {
+		first, last := t.bounds()
+		span := last.Sub(first).Seconds()
+		if span == 0 {
+			span = 1
+		}
+		l.x = func(e *event) float64 {
+			return marginLeft + dangling + e.time.Sub(first).Seconds()/span*timeWidth
+		}
+		l.width = marginLeft + 2*dangling + timeWidth
+	} else {
+		maxLC := 0
+		for _, events := range t.events {
+			for _, e := range events {
+				if e.lamport > maxLC {
+					maxLC = e.lamport
+				}
+			}
+		}
+		l.x = func(e *event) float64 {
+			return float64(marginLeft + dangling + e.lamport*lamportStep)
+		}
+		l.width = marginLeft + 2*dangling + (maxLC+1)*lamportStep
+	}
+	l.height = marginTop + len(t.nodes)*rowHeight
+	return l
+}
+
+func (l *layout) y(uid uint) float64 {
+	return float64(marginTop + l.row[uid]*rowHeight)
+}
+
+// renderSVG writes the space-time diagram as SVG
+func renderSVG(w io.Writer, t *trace, scale string) {
+	l := newLayout(t, scale)
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`+"\n", l.width, l.height)
+	fmt.Fprintln(w, `<defs>`)
+	for mt, c := range l.colors {
+		fmt.Fprintf(w, `<marker id="arrow-%s" markerWidth="8" markerHeight="8" refX="8" refY="4" orient="auto"><path d="M0,0 L8,4 L0,8 z" fill="%s"/></marker>`+"\n", markerID(mt), c)
+	}
+	fmt.Fprintln(w, `</defs>`)
+
+	// Process lines
+	for _, uid := range t.nodes {
+		y := l.y(uid)
+		fmt.Fprintf(w, `<text x="5" y="%.1f" dominant-baseline="middle">node %d</text>`+"\n", y, uid)
+		fmt.Fprintf(w, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#000"/>`+"\n", marginLeft, y, l.width, y)
+	}
+
+	// Messages
+	for _, m := range t.messages {
+		c := l.colors[m.msgType]
+		title := html.EscapeString(fmt.Sprintf("%s %s (%s)", m.msgType, m.payload, m.reqID))
+		var x1, y1, x2, y2 float64
+		switch {
+		case m.src != nil && m.dst != nil:
+			x1, y1, x2, y2 = l.x(m.src), l.y(m.src.node), l.x(m.dst), l.y(m.dst.node)
+		case m.src != nil: // never received (or receiver log missing)
+			x1, y1 = l.x(m.src), l.y(m.src.node)
+			x2, y2 = x1+dangling, y1-dangling
+		default: // sent by the client or sender log missing
+			x2, y2 = l.x(m.dst), l.y(m.dst.node)
+			x1, y1 = x2-dangling, y2-dangling
+		}
+		dash := ""
+		if m.src == nil || m.dst == nil {
+			dash = ` stroke-dasharray="4,2"`
+		}
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" marker-end="url(#arrow-%s)"%s><title>%s</title></line>`+"\n",
+			x1, y1, x2, y2, c, markerID(m.msgType), dash, title)
+	}
+
+	// Events
+	for _, uid := range t.nodes {
+		for _, e := range t.events[uid] {
+			fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s</title></circle>`+"\n",
+				l.x(e), l.y(e.node), l.colors[e.msg.msgType], html.EscapeString(eventTitle(e)))
+		}
+	}
+
+	fmt.Fprintln(w, `</svg>`)
+}
+
+// renderHTML wraps the SVG with a legend
+func renderHTML(w io.Writer, t *trace, scale string) {
+	l := newLayout(t, scale)
+	types := []string{}
+	for mt := range l.colors {
+		types = append(types, mt)
+	}
+	sort.Strings(types)
+
+	fmt.Fprintln(w, `<!DOCTYPE html><html><head><meta charset="utf-8"><title>vaa space-time diagram</title>`)
+	fmt.Fprintln(w, `<style>body{font-family:monospace} line:hover{stroke-width:3} .legend span{margin-right:1em}</style></head><body>`)
+	fmt.Fprintf(w, "<p>%d nodes, %d messages, scale: %s</p>\n", len(t.nodes), len(t.messages), html.EscapeString(scale))
+	fmt.Fprintln(w, `<p class="legend">`)
+	for _, mt := range types {
+		fmt.Fprintf(w, `<span style="color:%s">&#9632; %s</span>`+"\n", l.colors[mt], html.EscapeString(mt))
+	}
+	fmt.Fprintln(w, `</p>`)
+	renderSVG(w, t, scale)
+	fmt.Fprintln(w, `</body></html>`)
+}
+
+func eventTitle(e *event) string {
+	dir := "recv"
+	if e.send {
+		dir = "send"
+	}
+	return fmt.Sprintf("%s node=%d lc=%d %s %s;%s (%s)", dir, e.node, e.lamport, e.time.Format("15:04:05.000000"), e.msg.msgType, e.msg.payload, e.msg.reqID)
+}
+
+func markerID(msgType string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, msgType)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// logEntry is a single JSON log line written by a node (`--log-format=json`)
+type logEntry struct {
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+	Direction string    `json:"msg_direction"`
+	ReqID     string    `json:"req_id"`
+	UID       *uint     `json:"uid"`
+	SrcUID    *uint     `json:"src_uid"`
+	Type      string    `json:"type"`
+	Payload   string    `json:"payload"`
+}
+
+// event is a send or receive on a node
+type event struct {
+	node    uint
+	send    bool
+	time    time.Time
+	lamport int
+	msg     *message
+}
+
+// message links the send and receive event of a single transmission (matched by the request id)
+type message struct {
+	reqID   string
+	msgType string
+	payload string
+	src     *event
+	dst     *event
+}
+
+// trace holds all events of all nodes
+type trace struct {
+	nodes    []uint
+	events   map[uint][]*event // ordered by time per node
+	messages []*message
+}
+
+// filter restricts the trace to certain message types/time ranges
+type filter struct {
+	types map[string]bool
+	from  time.Time
+	to    time.Time
+}
+
+func (f *filter) match(e *logEntry) bool {
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	if !f.from.IsZero() && e.Time.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && e.Time.After(f.to) {
+		return false
+	}
+	return true
+}
+
+// readEntries reads all message related log lines from a file; other lines are skipped
+func readEntries(path string) ([]*logEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*logEntry{}
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 1024*1024), 16*1024*1024) // snapshot states can be large
+	for s.Scan() {
+		e := &logEntry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			continue // not a JSON log line (e.g. console output of go run)
+		}
+		if e.ReqID == "" || e.SrcUID == nil {
+			continue
+		}
+		switch {
+		case e.Direction == "outgoing" && strings.HasPrefix(e.Message, ">>>"):
+		case e.Direction == "incoming" && e.Message == "<<<" && e.UID != nil:
+		default:
+			continue // e.g. debug output of the dispatcher
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// buildTrace matches sends to receives and computes the lamport timestamps
+func buildTrace(entries []*logEntry, f *filter) *trace {
+	t := &trace{events: map[uint][]*event{}}
+	byReqID := map[string]*message{}
+
+	get := func(e *logEntry) *message {
+		m, ok := byReqID[e.ReqID]
+		if !ok {
+			m = &message{reqID: e.ReqID, msgType: e.Type, payload: e.Payload}
+			byReqID[e.ReqID] = m
+			t.messages = append(t.messages, m)
+		}
+		return m
+	}
+
+	for _, e := range entries {
+		if !f.match(e) {
+			continue
+		}
+		m := get(e)
+		if e.Direction == "outgoing" {
+			m.src = &event{node: *e.SrcUID, send: true, time: e.Time, msg: m}
+			t.events[m.src.node] = append(t.events[m.src.node], m.src)
+		} else {
+			m.dst = &event{node: *e.UID, send: false, time: e.Time, msg: m}
+			t.events[m.dst.node] = append(t.events[m.dst.node], m.dst)
+		}
+	}
+
+	for uid, events := range t.events {
+		sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+		t.nodes = append(t.nodes, uid)
+	}
+	sort.Slice(t.nodes, func(i, j int) bool { return t.nodes[i] < t.nodes[j] })
+
+	t.computeLamport()
+	return t
+}
+
+// computeLamport assigns lamport timestamps; a receive can only be processed once its send has a timestamp
+func (t *trace) computeLamport() {
+	next := map[uint]int{}
+	clock := map[uint]int{}
+	remaining := 0
+	for _, events := range t.events {
+		remaining += len(events)
+	}
+
+	for remaining > 0 {
+		progress := false
+		for _, uid := range t.nodes {
+			for next[uid] < len(t.events[uid]) {
+				e := t.events[uid][next[uid]]
+				if !e.send && e.msg.src != nil && e.msg.src.lamport == 0 {
+					break // wait for the sending node
+				}
+				clock[uid] = clock[uid] + 1
+				if !e.send && e.msg.src != nil && e.msg.src.lamport >= clock[uid] {
+					clock[uid] = e.msg.src.lamport + 1
+				}
+				e.lamport = clock[uid]
+				next[uid] = next[uid] + 1
+				remaining = remaining - 1
+				progress = true
+			}
+		}
+		if !progress {
+			// Clock skew between log files led to a cycle; break it by ignoring the send of the first blocked receive
+			for _, uid := range t.nodes {
+				if next[uid] < len(t.events[uid]) {
+					e := t.events[uid][next[uid]]
+					clock[uid] = clock[uid] + 1
+					e.lamport = clock[uid]
+					next[uid] = next[uid] + 1
+					remaining = remaining - 1
+					break
+				}
+			}
+		}
+	}
+}
+
+// bounds returns the first and last event time
+func (t *trace) bounds() (time.Time, time.Time) {
+	var first, last time.Time
+	for _, events := range t.events {
+		for _, e := range events {
+			if first.IsZero() || e.time.Before(first) {
+				first = e.time
+			}
+			if e.time.After(last) {
+				last = e.time
+			}
+		}
+	}
+	return first, last
+}
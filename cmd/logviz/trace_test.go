@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sent is the log line of node uid sending a message at second at
+func sent(uid uint, reqID, msgType string, at int) *logEntry {
+	return &logEntry{Message: ">>> addr", Time: time.Unix(int64(at), 0), Direction: "outgoing", ReqID: reqID, SrcUID: &uid, Type: msgType}
+}
+
+// received is the log line of node uid receiving a message of src at second at
+func received(uid, src uint, reqID, msgType string, at int) *logEntry {
+	return &logEntry{Message: "<<<", Time: time.Unix(int64(at), 0), Direction: "incoming", ReqID: reqID, UID: &uid, SrcUID: &src, Type: msgType}
+}
+
+// link summarizes a matched message: the nodes of the send and the receive, 0 if not logged
+type link struct {
+	reqID    string
+	src, dst uint
+}
+
+func links(t *trace) []link {
+	ls := []link{}
+	for _, m := range t.messages {
+		l := link{reqID: m.reqID}
+		if m.src != nil {
+			l.src = m.src.node
+		}
+		if m.dst != nil {
+			l.dst = m.dst.node
+		}
+		ls = append(ls, l)
+	}
+	return ls
+}
+
+func TestBuildTrace(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []*logEntry
+		filter  *filter
+		links   []link
+		nodes   []uint
+	}{
+		{
+			name:    "send and receive matched by request id",
+			entries: []*logEntry{sent(1, "a", "RUMOR", 1), sent(1, "b", "RUMOR", 2), received(2, 1, "b", "RUMOR", 3), received(3, 1, "a", "RUMOR", 4)},
+			filter:  &filter{},
+			links:   []link{{"a", 1, 3}, {"b", 1, 2}},
+			nodes:   []uint{1, 2, 3},
+		},
+		{
+			name:    "receive logged before the send",
+			entries: []*logEntry{received(2, 1, "a", "RUMOR", 2), sent(1, "a", "RUMOR", 1)},
+			filter:  &filter{},
+			links:   []link{{"a", 1, 2}},
+			nodes:   []uint{1, 2},
+		},
+		{
+			name:    "log of the sender or the receiver missing",
+			entries: []*logEntry{received(2, 1, "a", "RUMOR", 1), sent(2, "b", "RUMOR", 2)},
+			filter:  &filter{},
+			links:   []link{{"a", 0, 2}, {"b", 2, 0}},
+			nodes:   []uint{2},
+		},
+		{
+			name:    "filtered by type",
+			entries: []*logEntry{sent(1, "a", "RUMOR", 1), received(2, 1, "a", "RUMOR", 2), sent(1, "b", "BANKING", 3), received(2, 1, "b", "BANKING", 4)},
+			filter:  &filter{types: map[string]bool{"BANKING": true}},
+			links:   []link{{"b", 1, 2}},
+			nodes:   []uint{1, 2},
+		},
+		{
+			name:    "filtered by time",
+			entries: []*logEntry{sent(1, "a", "RUMOR", 1), received(2, 1, "a", "RUMOR", 2), sent(2, "b", "RUMOR", 3), received(3, 2, "b", "RUMOR", 4)},
+			filter:  &filter{from: time.Unix(2, 0), to: time.Unix(3, 0)},
+			links:   []link{{"a", 0, 2}, {"b", 2, 0}},
+			nodes:   []uint{2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := buildTrace(tc.entries, tc.filter)
+			assert.ElementsMatch(t, tc.links, links(tr))
+			assert.Equal(t, tc.nodes, tr.nodes)
+		})
+	}
+}
+
+func TestComputeLamport(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []*logEntry
+		lamport map[uint][]int // per node, in the order of the events
+	}{
+		{
+			name:    "chain",
+			entries: []*logEntry{sent(1, "a", "T", 1), received(2, 1, "a", "T", 2), sent(2, "b", "T", 3), received(3, 2, "b", "T", 4)},
+			lamport: map[uint][]int{1: {1}, 2: {2, 3}, 3: {4}},
+		},
+		{
+			name:    "concurrent sends",
+			entries: []*logEntry{sent(2, "c", "T", 0), sent(1, "a", "T", 1), received(2, 1, "a", "T", 2), received(3, 2, "c", "T", 5)},
+			lamport: map[uint][]int{1: {1}, 2: {1, 2}, 3: {2}},
+		},
+		{
+			name:    "receive without a logged send",
+			entries: []*logEntry{received(2, 1, "a", "T", 1), sent(2, "b", "T", 2)},
+			lamport: map[uint][]int{2: {1, 2}},
+		},
+		{
+			name: "clock skew between the log files",
+			// Both nodes log the receive before the send causing it, the first blocked receive ignores its send
+			entries: []*logEntry{received(1, 2, "b", "T", 1), sent(1, "a", "T", 2), received(2, 1, "a", "T", 1), sent(2, "b", "T", 2)},
+			lamport: map[uint][]int{1: {1, 2}, 2: {3, 4}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := buildTrace(tc.entries, &filter{})
+			lamport := map[uint][]int{}
+			for uid, events := range tr.events {
+				for _, e := range events {
+					lamport[uid] = append(lamport[uid], e.lamport)
+				}
+			}
+			assert.Equal(t, tc.lamport, lamport)
+		})
+	}
+}
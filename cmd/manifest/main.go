@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+func main() {
+	manifest := flag.String("manifest", "./cluster.yaml", "path to the cluster manifest (`.json` or `.yaml`)")
+	config := flag.String("config", "./config.txt", "path to config file")
+	graph := flag.String("graph", "./graph.txt", "path to graph definition")
+	export := flag.Bool("export", false, "convert the manifest into config + graph instead of config + graph into a manifest")
+	flag.Parse()
+
+	if *export {
+		m, err := neigh.LoadManifest(*manifest)
+		if err != nil {
+			log.Err(err).Msg("Failed to load manifest")
+			os.Exit(1)
+		}
+		if err := m.WriteFiles(*config, *graph); err != nil {
+			log.Err(err).Msg("Failed to write config + graph")
+			os.Exit(1)
+		}
+		log.Info().Msgf("Stored %d nodes in %s and %d edges in %s", len(m.Nodes), *config, len(m.Edges), *graph)
+		return
+	}
+
+	m, err := neigh.ManifestFromFiles(*config, *graph)
+	if err != nil {
+		log.Err(err).Msg("Failed to load config + graph")
+		os.Exit(1)
+	}
+	if err := m.Write(*manifest); err != nil {
+		log.Err(err).Msg("Failed to write manifest")
+		os.Exit(1)
+	}
+	log.Info().Msgf("Stored %d nodes and %d edges in %s", len(m.Nodes), len(m.Edges), *manifest)
+}
@@ -7,9 +7,11 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
@@ -32,11 +34,25 @@ func main() {
 	var err error
 
 	debug := flag.Bool("debug", false, "enable debug mode")
+	logFormat := flag.String("log-format", "console", "log format, `console` or `json` (required by cmd/logviz)")
 
 	config := flag.String("config", "./config", "path to config file")
-	graph := flag.String("graph", "", "path to graph")
+	graph := flag.String("graph", "", "path to graph; without graph (and manifest) the nodes form a random overlay")
+	overlaySeed := flag.Int64("overlay-seed", 1, "seed of the random overlay; has to be the same on all nodes")
+	overlayDegree := flag.Uint("overlay-degree", 3, "number of neighbours of every node in the random overlay")
+	manifest := flag.String("manifest", "", "path to a cluster manifest (`.json` or `.yaml`) replacing config + graph")
 	uid := flag.Uint("uid", 1, "Node UID")
 	metric := flag.String("metric", ":9111", "metric endpoint")
+	record := flag.String("record", "", "record every input of the node to this trace file")
+	replay := flag.String("replay", "", "replay a recorded trace instead of running the node; outgoing messages are captured")
+	dataDir := flag.String("data-dir", "", "persist the extension state below this directory and restore it on restart")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Second, "interval of the checkpoints compacting the write-ahead log")
+	join := flag.String("join", "", "join a running cluster through the node at this address")
+	addr := flag.String("addr", "", "address `<host>:<port>` of a joining node that is not part of the config")
+	swim := flag.Duration("swim", 0, "maintain the view of all nodes with the SWIM membership protocol, probing in this interval; seeds are `--join` or the config; disabled if 0")
+	heartbeat := flag.Duration("heartbeat", 0, "exchange heartbeats with the neighbours in this interval to detect failures; disabled if 0")
+	suspectAfter := flag.Duration("suspect-after", 3*time.Second, "suspect a neighbour without heartbeat for this long")
+	deadAfter := flag.Duration("dead-after", 10*time.Second, "consider a neighbour without heartbeat for this long dead")
 
 	consensusM := flag.Int("consensus-m", 5, "number of discrete timestamps")
 	consensusAmax := flag.Int("consensus-amax", 3, "max number of voting rounds")
@@ -49,6 +65,16 @@ func main() {
 	if *debug {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
+	// JSON logs (with sub-second precision so events can be ordered afterwards)
+	switch *logFormat {
+	case "console":
+	case "json":
+		zerolog.TimeFieldFormat = time.RFC3339Nano
+		log.Logger = log.Output(os.Stderr)
+	default:
+		log.Error().Msgf("Unsupported log format `%s`", *logFormat)
+		os.Exit(1)
+	}
 
 	// Start metric server
 	log.Info().Msgf("Starting metric endpoint at %s/metrics", *metric)
@@ -60,12 +86,28 @@ func main() {
 	}()
 
 	// Load configuration / construct neighbors for thise node
-	c, err := neigh.LoadConfig(*config)
+	var m *neigh.Manifest
+	var c *neigh.Config
+	if *manifest != "" {
+		if m, err = neigh.LoadManifest(*manifest); err == nil {
+			c = m.Config()
+		}
+	} else {
+		c, err = neigh.LoadConfig(*config)
+	}
+	if err != nil && *swim > 0 && *join != "" && *addr != "" {
+		// SWIM does not need a static configuration
+		log.Info().Msg("No configuration, discovering all nodes through SWIM")
+		c, err = &neigh.Config{Nodes: map[uint]string{}}, nil
+	}
 	if err != nil {
 		log.Err(err).Msg("Failed to load configuration")
 		return
 	}
 	netAddr, ok := c.Nodes[*uid]
+	if !ok && *addr != "" {
+		netAddr, ok = *addr, true
+	}
 	if !ok {
 		log.Error().Msg("UID not in config")
 		return
@@ -77,20 +119,40 @@ func main() {
 	}
 	listen := fmt.Sprintf(":%s", netAddrSplit[1])
 
-	if *graph != "" {
+	if m != nil {
+		log.Info().Msgf("Loading node config from manifest")
+		neighs = neigh.NeighsFor(*uid, c, m.NeighMap())
+	} else if *graph != "" {
 		log.Info().Msgf("Loading node config from configuration file + communication graph")
 		neighs, err = neigh.NeighsFromConfigAndGraph(*uid, *config, *graph)
+	} else if *join != "" || *swim > 0 {
+		log.Info().Msgf("Starting without initial neighbours")
+		neighs = &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: c.Nodes, Registered: map[uint]bool{}}
 	} else {
-		log.Info().Msgf("Loading node config from configuration file")
-		neighs, err = neigh.NeighsFromConfig(*config)
+		log.Info().Msgf("Loading node config from configuration file, random overlay with degree %d", *overlayDegree)
+		neighs, err = neigh.NeighsFromConfig(*uid, *config, *overlaySeed, *overlayDegree)
 	}
 	if err != nil {
 		log.Err(err).Msg("Failed to load configuration")
 		return
 	}
 
+	neighs.AllNodes[*uid] = netAddr // joining nodes are not part of the config
+
 	log.Info().Msgf("Loaded configuration for UID %d", *uid)
 
+	// Extension parameters of the manifest; explicitly set flags take precedence
+	if m != nil {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		p := m.Params("CONSENSUS")
+		for name, v := range map[string]*int{"s": consensusS, "m": consensusM, "p": consensusP, "amax": consensusAmax} {
+			if !set["consensus-"+name] {
+				*v = p.Int(name, *v)
+			}
+		}
+	}
+
 	// Communication channels + Dispatcher
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
@@ -102,10 +164,82 @@ func main() {
 	// Register node extensions
 	n.Register(node.NewControlExtension())
 	n.Register(node.NewDiscoveryExtension())
+	if *swim > 0 {
+		seeds := []string{*join}
+		if *join == "" {
+			seeds = []string{}
+			for _, a := range c.Nodes {
+				seeds = append(seeds, a)
+			}
+		}
+		n.Register(node.NewSwimExtension(seeds, *swim))
+	} else {
+		n.Register(node.NewMembershipExtension(*join))
+	}
 	n.Register(node.NewRumorExtension()) // Rumor experiment
 	n.Register(node.NewDistributedBankingExtension())
 	n.Register(node.NewConsensusExtension(*consensusS, *consensusM, *consensusP, *consensusAmax)) // Consensus experiment
 
+	// Re-read config + graph on CONTROL RELOAD (or SIGHUP)
+	if m != nil {
+		n.Reloadable(func() (*neigh.Neighs, error) {
+			return neigh.NeighsFromManifest(*uid, *manifest)
+		})
+	} else if *graph != "" {
+		n.Reloadable(func() (*neigh.Neighs, error) {
+			return neigh.NeighsFromConfigAndGraph(*uid, *config, *graph)
+		})
+	} else if *join == "" && *swim == 0 {
+		n.Reloadable(func() (*neigh.Neighs, error) {
+			return neigh.NeighsFromConfig(*uid, *config, *overlaySeed, *overlayDegree)
+		})
+	}
+
+	// Failure detector; part of the replay as well since it schedules timers
+	if *heartbeat > 0 {
+		n.DetectFailures(*heartbeat, *suspectAfter, *deadAfter)
+	}
+
+	// Replay a recorded trace synchronously; no network involved
+	if *replay != "" {
+		if *dataDir != "" {
+			// The directory holds the state after the recording, not the one the trace started from
+			log.Error().Msg("--replay cannot be combined with --data-dir")
+			return
+		}
+		f, err := os.Open(*replay)
+		if err != nil {
+			log.Err(err).Msg("Failed to open trace")
+			return
+		}
+		defer f.Close()
+		captured, err := n.Replay(ctx, f)
+		if err != nil {
+			log.Err(err).Msg("Replay failed")
+		}
+		log.Info().Msgf("Captured %d outgoing messages", len(captured))
+		return
+	}
+
+	// Durable state; restored before the preflights
+	if *dataDir != "" {
+		if err := n.Persist(filepath.Join(*dataDir, fmt.Sprintf("node-%d", *uid)), *checkpointInterval); err != nil {
+			log.Err(err).Msg("Failed to restore state")
+			return
+		}
+	}
+
+	// Record inputs
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			log.Err(err).Msg("Failed to create trace")
+			return
+		}
+		defer f.Close()
+		n.Record(f)
+	}
+
 	// Start message dispatcher (aka receiver)
 	wg.Add(1)
 	go func() {
@@ -126,15 +260,31 @@ func main() {
 		}
 	}()
 
-	// Handle ctrl + c
+	// Handle ctrl + c; SIGHUP reloads config + graph through the node loop
 	osc := make(chan os.Signal, 1)
-	signal.Notify(osc, os.Interrupt, syscall.SIGTERM)
-	select {
-	case <-osc:
-		log.Info().Msg("Received CTRL+C, shutting down")
-		cancelCtx()
-	case <-ctx.Done():
-		log.Info().Msg("Control message triggered shutdown")
+	signal.Notify(osc, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for running := true; running; {
+		select {
+		case sig := <-osc:
+			if sig == syscall.SIGHUP {
+				log.Info().Msg("Received SIGHUP, reloading configuration")
+				reload := com.Msg(0, "CONTROL", "RELOAD")
+				reload.UUID = com.StrPointer("sighup") // not sent over the network, no UUID assigned
+				go func() {
+					select {
+					case recvChan <- reload:
+					case <-ctx.Done():
+					}
+				}()
+				continue
+			}
+			log.Info().Msg("Received CTRL+C, shutting down")
+			cancelCtx()
+			running = false
+		case <-ctx.Done():
+			log.Info().Msg("Control message triggered shutdown")
+			running = false
+		}
 	}
 	wg.Wait() // Wait for listeners/handlers to shutdown
 	log.Info().Msg("ByeBye")
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/scenario"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+func main() {
+	dir := flag.String("dir", "", "directory for generated files and the node binary; temporary if empty")
+	inProcess := flag.Bool("in-process", false, "run all nodes in this process, overrides in_process of the scenario")
+	nodeBin := flag.String("node-bin", "", "node binary; built from cmd/node if empty")
+	nodeLogs := flag.String("node-logs", "-", "file for the aggregated node logs; - for stderr, empty to discard them")
+	flag.Usage = func() {
+		os.Stderr.WriteString("Usage: scenario [flags] <scenario.yaml>...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var logs io.Writer = os.Stderr
+	switch *nodeLogs {
+	case "-":
+	case "":
+		logs = ioutil.Discard
+	default:
+		f, err := os.Create(*nodeLogs)
+		if err != nil {
+			log.Err(err).Msg("Failed to create node log")
+			os.Exit(1)
+		}
+		defer f.Close()
+		logs = f
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		osc := make(chan os.Signal, 1)
+		signal.Notify(osc, os.Interrupt, syscall.SIGTERM)
+		<-osc
+		log.Info().Msg("Received CTRL+C, stopping the scenario")
+		cancel()
+	}()
+
+	// Exit code 2 if a step failed, 1 if a scenario could not be run
+	code := 0
+	for _, path := range flag.Args() {
+		s, err := scenario.Load(path)
+		if err != nil {
+			log.Err(err).Str("scenario", path).Msg("Invalid scenario")
+			code = 1
+			continue
+		}
+		if s.Name == "" {
+			s.Name = path
+		}
+		opts := s.Cluster.Options()
+		opts.Dir, opts.NodeBin, opts.Logs = *dir, *nodeBin, logs
+		opts.InProcess = opts.InProcess || *inProcess
+
+		_, err = scenario.Run(ctx, s, opts)
+		switch {
+		case scenario.IsFailure(err):
+			log.Error().Str("scenario", s.Name).Msg(err.Error())
+			code = 2
+		case err != nil:
+			log.Err(err).Str("scenario", s.Name).Msg("Failed to run scenario")
+			code = 1
+		default:
+			log.Info().Str("scenario", s.Name).Msg("Scenario passed")
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	os.Exit(code)
+}
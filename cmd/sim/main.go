@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	// Thousands of nodes; only warnings by default
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+}
+
+func main() {
+	debug := flag.Bool("debug", false, "enable node logs")
+
+	graph := flag.String("graph", "", "path to graph; a random graph with n nodes and m edges is generated if empty")
+	n := flag.Uint("n", 1000, "number of nodes (random graph)")
+	m := flag.Uint("m", 1500, "number of edges (random graph)")
+	seed := flag.Int64("seed", 1, "seed for the graph, the network and all random draws of the nodes")
+
+	latency := flag.Duration("latency", 5*time.Millisecond, "message latency")
+	jitter := flag.Duration("jitter", 5*time.Millisecond, "random additional message latency")
+	until := flag.Duration("until", 60*time.Second, "virtual time to simulate")
+	out := flag.String("out", "-", "write the statistics as JSON to this file, `-` for stdout")
+	states := flag.String("states", "", "write the final state of all nodes as JSON to this file, e.g. for graphgen render --states")
+	invariants := flag.String("invariants", "", "comma separated invariants checked during the run, e.g. `leader:BANKING,mutex,money`")
+	checkInterval := flag.Duration("check-interval", 100*time.Millisecond, "virtual time between invariant checks (0 = after every event)")
+
+	experiment := flag.String("experiment", "rumor", "experiment to run: `rumor`, `consensus`, `banking` or `explore`")
+	rumorC := flag.Int("rumor-c", 2, "rumor: trust threshold")
+	rumorText := flag.String("rumor-text", "SimulatedRumor", "rumor: content")
+	rumorStart := flag.Uint("rumor-start", 1, "rumor: node that distributes the rumor")
+	consensusM := flag.Int("consensus-m", 5, "number of discrete timestamps")
+	consensusAmax := flag.Int("consensus-amax", 3, "max number of voting rounds")
+	consensusP := flag.Int("consensus-p", 2, "How many random neighbours to choose")
+	consensusS := flag.Int("consensus-s", 3, "How many nodes are asked to initiate the voting process")
+	exploreInitiators := flag.String("explore-initiators", "", "explore: comma separated nodes starting the election; all nodes if empty")
+	exploreFIFO := flag.Bool("explore-fifo", true, "explore: channels are FIFO, otherwise messages may overtake each other")
+	exploreMax := flag.Int("explore-max-states", 1000000, "explore: stop after this many states (0 = unlimited)")
+	flag.Parse()
+
+	if *debug {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
+	// Topology
+	var nm *neigh.NeighMap
+	var err error
+	if *graph != "" {
+		nm, err = neigh.LoadGraph(*graph)
+	} else {
+		nm, err = neigh.RandomGraph(rand.New(rand.NewSource(*seed)), *n, *m)
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to construct graph")
+		os.Exit(1)
+	}
+
+	if *experiment == "explore" {
+		if !*debug {
+			zerolog.SetGlobalLevel(zerolog.Disabled) // handler errors are part of the result
+		}
+		os.Exit(explore(nm, *exploreInitiators, *exploreFIFO, *exploreMax, *out))
+	}
+
+	// Simulated nodes don't listen anywhere, the connect string only identifies them
+	c := &neigh.Config{Nodes: map[uint]string{}}
+	for a, v := range nm.Neighs {
+		c.Nodes[a] = fmt.Sprintf("sim:%d", a)
+		for _, b := range v {
+			c.Nodes[b] = fmt.Sprintf("sim:%d", b)
+		}
+	}
+
+	s := node.NewSimulation(*seed, *latency, *jitter)
+	s.AddNodes(c, nm, func(h node.Handler) {
+		h.Register(node.NewControlExtension())
+		h.Register(node.NewDiscoveryExtension())
+		switch *experiment {
+		case "rumor":
+			h.Register(node.NewRumorExtension())
+		case "consensus":
+			h.Register(node.NewConsensusExtension(*consensusS, *consensusM, *consensusP, *consensusAmax))
+		case "banking":
+			h.Register(node.NewDistributedBankingExtension())
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		osc := make(chan os.Signal, 1)
+		signal.Notify(osc, os.Interrupt)
+		<-osc
+		cancel()
+	}()
+
+	invs, err := node.ParseInvariants(*invariants)
+	if err != nil {
+		log.Err(err).Msg("Invalid invariants")
+		os.Exit(1)
+	}
+	s.Check(*checkInterval, invs...)
+
+	if err := s.Start(ctx); err != nil {
+		log.Err(err).Msg("Failed to start simulation")
+		os.Exit(1)
+	}
+
+	// Same sequence as the Makefile targets: startup, then trigger the experiment
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+	switch *experiment {
+	case "rumor":
+		s.Inject(time.Second, *rumorStart, com.Msg(0, "CONTROL", fmt.Sprintf("DISTRIBUTE RUMOR %d;%s", *rumorC, *rumorText)))
+	case "consensus":
+		s.InjectAll(time.Second, com.Msg(0, "CONSENSUS", "coordinator"))
+	case "banking":
+		s.InjectAll(time.Second, com.Msg(0, "BANKING", "coordinator"))
+	default:
+		log.Error().Msgf("Unknown experiment %s", *experiment)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Simulating %s with %d nodes for %s virtual time\n", *experiment, len(c.Nodes), *until)
+	stats := s.Run(ctx, *until)
+
+	// Report
+	b, _ := json.MarshalIndent(stats, "", "  ")
+	if *out == "-" {
+		fmt.Println(string(b))
+	} else if err := os.WriteFile(*out, b, 0644); err != nil {
+		log.Err(err).Msg("Failed to store statistics")
+		os.Exit(1)
+	}
+	if *states != "" {
+		b, _ := json.MarshalIndent(s.States(), "", "  ")
+		if err := os.WriteFile(*states, b, 0644); err != nil {
+			log.Err(err).Msg("Failed to store states")
+			os.Exit(1)
+		}
+	}
+	if len(stats.Violations) > 0 {
+		os.Exit(2)
+	}
+}
+
+// explore checks all delivery orders of the leader election; returns the exit code
+func explore(nm *neigh.NeighMap, initiators string, fifo bool, maxStates int, out string) int {
+	nodes := map[uint]bool{}
+	for a, v := range nm.Neighs {
+		nodes[a] = true
+		for _, b := range v {
+			nodes[b] = true
+		}
+	}
+	if len(nodes) > 6 {
+		log.Warn().Msg("Exploring more than 6 nodes most likely exceeds the state limit")
+	}
+
+	var init []uint
+	if initiators == "" {
+		for uid := range nodes {
+			init = append(init, uid)
+		}
+		sort.Slice(init, func(i, j int) bool { return init[i] < init[j] })
+	} else {
+		for _, s := range strings.Split(initiators, ",") {
+			uid, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				log.Err(err).Msg("Invalid initiator")
+				return 1
+			}
+			init = append(init, uint(uid))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Exploring the election of %v (fifo=%t)\n", init, fifo)
+	res := node.ExploreElection(nm, init, fifo, maxStates)
+
+	b, _ := json.MarshalIndent(res, "", "  ")
+	if out == "-" {
+		fmt.Println(string(b))
+	} else if err := os.WriteFile(out, b, 0644); err != nil {
+		log.Err(err).Msg("Failed to store result")
+		return 1
+	}
+
+	if res.Violation != "" {
+		fmt.Fprintf(os.Stderr, "Invariant `%s` violated after %d deliveries: %s\n", res.Invariant, len(res.Trace), res.Violation)
+		return 2
+	}
+	return 0
+}
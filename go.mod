@@ -6,6 +6,10 @@ require (
 	github.com/awalterschulze/gographviz v2.0.3+incompatible
 	github.com/google/uuid v1.2.0
 	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.26.0
 	github.com/rs/zerolog v1.23.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -0,0 +1,316 @@
+// Package benchmark runs parameter sweeps of the rumor and consensus experiments on local clusters
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/cluster"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// Experiments supported by the benchmark
+const (
+	Rumor     = "rumor"
+	Consensus = "consensus"
+)
+
+// rumorText marks the rumor of a benchmark run
+const rumorText = "BENCHMARK_RUMOR"
+
+// Params of a single run
+type Params struct {
+	Experiment string `json:"experiment"`
+	Run        int    `json:"run"` // repetition of the same parameters
+	Seed       int64  `json:"seed"`
+	Nodes      uint   `json:"nodes"`
+	Edges      uint   `json:"edges"`
+	C          int    `json:"c,omitempty"`    // rumor
+	S          int    `json:"s,omitempty"`    // consensus
+	M          int    `json:"m,omitempty"`    // consensus
+	P          int    `json:"p,omitempty"`    // consensus
+	AMax       int    `json:"amax,omitempty"` // consensus
+}
+
+// Sweep is a parameter space, every combination is run Repeat times
+type Sweep struct {
+	Experiment string
+	Nodes      []uint
+	EdgeRatios []float64 // edges per node; clamped to a connected, simple graph
+	C          []int
+	S, M, P    []int
+	AMax       []int
+	Repeat     int
+	Seed       int64 // every run uses its own graph seed, counting up from Seed
+}
+
+// Result of a single run
+type Result struct {
+	Params
+	Completed bool    `json:"completed"`
+	Duration  float64 `json:"duration_seconds"` // trigger until completion (or timeout)
+	// Messages of the experiment type (RUMOR, CONSENSUS) sent after the trigger
+	Messages uint64 `json:"messages"`
+	// Messages of all types sent after the trigger, without CONTROL messages of the benchmark itself
+	MessagesTotal uint64 `json:"messages_total"`
+	Trusted       int    `json:"trusted"`   // rumor: nodes trusting the rumor
+	Leader        uint   `json:"leader"`    // consensus: coordinator that collected the result
+	Agreement     bool   `json:"agreement"` // consensus: all nodes agreed on t_k
+	TK            int    `json:"t_k"`       // consensus: collected t_k
+	Error         string `json:"error,omitempty"`
+}
+
+// Options of the clusters started for the runs
+type Options struct {
+	Dir       string // every run stores its files in a subdirectory; a temporary directory if empty
+	InProcess bool
+	NodeBin   string    // built once from cmd/node if empty
+	Logs      io.Writer // aggregated node logs; discarded if nil
+	Warmup    time.Duration
+	Timeout   time.Duration // a run not completing within the timeout is reported as not completed
+	Settle    time.Duration // a rumor run is complete once no rumor message was sent for this long
+	Interval  time.Duration // polling interval
+}
+
+// Runs expands the sweep into the parameters of the single runs
+func (s *Sweep) Runs() []*Params {
+	one := func(v []int) []int {
+		if len(v) == 0 {
+			return []int{0}
+		}
+		return v
+	}
+	cs, ss, ms, ps, amaxs := one(s.C), one(s.S), one(s.M), one(s.P), one(s.AMax)
+	if s.Experiment == Rumor {
+		ss, ms, ps, amaxs = []int{0}, []int{0}, []int{0}, []int{0}
+	} else {
+		cs = []int{0}
+	}
+	repeat := s.Repeat
+	if repeat < 1 {
+		repeat = 1
+	}
+
+	runs := []*Params{}
+	for _, n := range s.Nodes {
+		for _, ratio := range s.EdgeRatios {
+			for _, c := range cs {
+				for _, sv := range ss {
+					for _, m := range ms {
+						for _, p := range ps {
+							for _, amax := range amaxs {
+								for r := 0; r < repeat; r++ {
+									runs = append(runs, &Params{
+										Experiment: s.Experiment,
+										Run:        r,
+										Seed:       s.Seed + int64(len(runs)),
+										Nodes:      n,
+										Edges:      edges(n, ratio),
+										C:          c, S: sv, M: m, P: p, AMax: amax,
+									})
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return runs
+}
+
+// edges returns round(n*ratio) within [n-1, n(n-1)/2]
+func edges(n uint, ratio float64) uint {
+	m := uint(math.Round(float64(n) * ratio))
+	if m < n-1 {
+		m = n - 1
+	}
+	if max := n * (n - 1) / 2; m > max {
+		m = max
+	}
+	return m
+}
+
+// Runner executes runs one after another
+type Runner struct {
+	opts Options
+}
+
+// NewRunner prepares the directory and builds the node binary if required
+func NewRunner(opts Options) (*Runner, error) {
+	if opts.Logs == nil {
+		opts.Logs = ioutil.Discard
+	}
+	if opts.Dir == "" {
+		dir, err := ioutil.TempDir("", "vaa-benchmark-")
+		if err != nil {
+			return nil, err
+		}
+		opts.Dir = dir
+	}
+	if !opts.InProcess && opts.NodeBin == "" {
+		opts.NodeBin = filepath.Join(opts.Dir, "node")
+		log.Info().Msgf("Building node binary %s", opts.NodeBin)
+		if err := cluster.Build(opts.NodeBin); err != nil {
+			return nil, err
+		}
+	}
+	return &Runner{opts: opts}, nil
+}
+
+// Run executes a single run; errors of the run are reported in the result
+func (r *Runner) Run(ctx context.Context, p *Params) *Result {
+	res := &Result{Params: *p}
+	if err := r.run(ctx, p, res); err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func (r *Runner) run(ctx context.Context, p *Params, res *Result) error {
+	if p.Experiment != Rumor && p.Experiment != Consensus {
+		return fmt.Errorf("unknown experiment %s", p.Experiment)
+	}
+	opts := cluster.Options{
+		Topology:  "random",
+		Params:    neigh.TopologyParams{N: p.Nodes, M: p.Edges, Connectivity: 1},
+		Seed:      p.Seed,
+		Dir:       filepath.Join(r.opts.Dir, fmt.Sprintf("%s-%d-%d-%d", p.Experiment, p.Nodes, p.Edges, p.Seed)),
+		InProcess: r.opts.InProcess,
+		NodeBin:   r.opts.NodeBin,
+		Logs:      r.opts.Logs,
+	}
+	if p.Experiment == Consensus {
+		opts.NodeArgs = []string{
+			fmt.Sprintf("--consensus-s=%d", p.S), fmt.Sprintf("--consensus-m=%d", p.M),
+			fmt.Sprintf("--consensus-p=%d", p.P), fmt.Sprintf("--consensus-amax=%d", p.AMax),
+		}
+		// Same as cluster.DefaultExtensions with the consensus parameters of the run
+		opts.Register = func(h node.Handler) {
+			h.Register(node.NewControlExtension())
+			h.Register(node.NewDiscoveryExtension())
+			h.Register(node.NewMembershipExtension(""))
+			h.Register(node.NewRumorExtension())
+			h.Register(node.NewDistributedBankingExtension())
+			h.Register(node.NewConsensusExtension(p.S, p.M, p.P, p.AMax))
+		}
+	}
+	c, err := cluster.New(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		return err
+	}
+	defer c.Stop()
+	if err := c.Ready(ctx, 30*time.Second); err != nil {
+		return err
+	}
+	if err := c.SendAll("CONTROL", "STARTUP"); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(r.opts.Warmup):
+	}
+
+	before, err := r.scrape(c)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	var done time.Time
+	if p.Experiment == Rumor {
+		// Same as the Kubernetes benchmark: the first node distributes the rumor
+		if err := c.Send(c.UIDs()[0], "CONTROL", fmt.Sprintf("DISTRIBUTE RUMOR %d;%s", p.C, rumorText)); err != nil {
+			return err
+		}
+		done, err = r.awaitRumor(ctx, c, before)
+	} else {
+		if err := c.Send(c.UIDs()[0], "CONSENSUS", "coordinator"); err != nil {
+			return err
+		}
+		done, err = r.awaitConsensus(ctx, c, res)
+	}
+	if err != nil {
+		return err
+	}
+	res.Completed = !done.IsZero()
+	if done.IsZero() {
+		done = time.Now()
+	}
+	res.Duration = done.Sub(start).Seconds()
+
+	after, err := r.scrape(c)
+	if err != nil {
+		return err
+	}
+	diff := after.sub(before)
+	res.Messages = uint64(diff.out[map[string]string{Rumor: "RUMOR", Consensus: "CONSENSUS"}[p.Experiment]])
+	for t, v := range diff.out {
+		if t != "CONTROL" {
+			res.MessagesTotal += uint64(v)
+		}
+	}
+	res.Trusted = int(diff.trusted)
+	return nil
+}
+
+// awaitRumor waits until no rumor message was sent for the settle time; zero if the timeout was reached
+func (r *Runner) awaitRumor(ctx context.Context, c *cluster.Cluster, before *counters) (time.Time, error) {
+	deadline := time.Now().Add(r.opts.Timeout)
+	last, lastChange := 0.0, time.Now()
+	for time.Now().Before(deadline) {
+		cur, err := r.scrape(c)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if sent := cur.out["RUMOR"] - before.out["RUMOR"]; sent != last {
+			last, lastChange = sent, time.Now()
+		} else if last > 0 && time.Since(lastChange) >= r.opts.Settle {
+			return lastChange, nil
+		}
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case <-time.After(r.opts.Interval):
+		}
+	}
+	return time.Time{}, nil
+}
+
+// awaitConsensus waits until a coordinator collected the result; zero if the timeout was reached
+func (r *Runner) awaitConsensus(ctx context.Context, c *cluster.Cluster, res *Result) (time.Time, error) {
+	deadline := time.Now().Add(r.opts.Timeout)
+	for time.Now().Before(deadline) {
+		snap, err := c.Snapshot(ctx, r.opts.Timeout)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, uid := range c.UIDs() {
+			if s, ok := snap.States[uid]; ok && s.Bool("CONSENSUS", "collect_done") {
+				res.Leader = uid
+				res.Agreement = s.Bool("CONSENSUS", "agreement")
+				res.TK = s.Int("CONSENSUS", "collected_t_k")
+				return snap.Time, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case <-time.After(r.opts.Interval):
+		}
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,64 @@
+package benchmark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweep_Runs(t *testing.T) {
+	s := &Sweep{Experiment: Rumor, Nodes: []uint{6, 10}, EdgeRatios: []float64{1.5}, C: []int{2, 3}, S: []int{1, 2}, Repeat: 2, Seed: 10}
+	runs := s.Runs()
+	assert.Len(t, runs, 8, "consensus parameters are ignored for the rumor experiment")
+	assert.Equal(t, &Params{Experiment: Rumor, Run: 1, Seed: 13, Nodes: 6, Edges: 9, C: 3}, runs[3])
+
+	s = &Sweep{Experiment: Consensus, Nodes: []uint{4}, EdgeRatios: []float64{0.5, 10}, C: []int{2, 3}, S: []int{3}, M: []int{2, 5}, P: []int{2}, AMax: []int{3}}
+	runs = s.Runs()
+	assert.Len(t, runs, 4)
+	assert.Equal(t, uint(3), runs[0].Edges, "at least a spanning tree")
+	assert.Equal(t, uint(6), runs[2].Edges, "at most a complete graph")
+	assert.Equal(t, 0, runs[0].C)
+	assert.Equal(t, 5, runs[1].M)
+}
+
+func TestWriteCSV(t *testing.T) {
+	results := []*Result{{Params: Params{Experiment: Consensus, Seed: 1, Nodes: 7, Edges: 11, S: 4, M: 2, P: 3, AMax: 20}, Completed: true, Duration: 3.1, Messages: 628, MessagesTotal: 640, Leader: 1, Agreement: true, TK: 2}}
+	b := &bytes.Buffer{}
+	assert.Nil(t, WriteCSV(b, results))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Equal(t, strings.Join(csvHeader, ","), lines[0])
+	assert.Equal(t, "consensus,0,1,7,11,0,4,2,3,20,true,3.100,628,640,0,1,true,2,", lines[1])
+
+	// Same keys in JSON
+	b.Reset()
+	assert.Nil(t, WriteJSON(b, results))
+	decoded := []map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(b.Bytes(), &decoded))
+	for _, k := range csvHeader {
+		if k != "c" && k != "error" { // omitted if empty
+			assert.Contains(t, decoded[0], k)
+		}
+	}
+}
+
+func TestRunner_inProcess(t *testing.T) {
+	r, err := NewRunner(Options{Dir: t.TempDir(), InProcess: true, Warmup: 200 * time.Millisecond, Timeout: 10 * time.Second, Settle: 300 * time.Millisecond, Interval: 50 * time.Millisecond})
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ { // counters of the shared registry are not accumulated over runs
+		res := r.Run(context.Background(), &Params{Experiment: Rumor, Seed: 1, Nodes: 4, Edges: 6, C: 1})
+		assert.Empty(t, res.Error)
+		assert.True(t, res.Completed)
+		// The originator sends to 3 neighbours; they forward to all but the neighbour they heard the rumor from first,
+		// the originator only trusts if one of them heard it from another neighbour first
+		assert.GreaterOrEqual(t, res.Trusted, 3)
+		assert.LessOrEqual(t, res.Trusted, 4)
+		assert.GreaterOrEqual(t, res.Messages, uint64(3+3*2))
+		assert.LessOrEqual(t, res.Messages, uint64(3+3*3))
+	}
+}
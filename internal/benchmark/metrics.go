@@ -0,0 +1,81 @@
+package benchmark
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/xvzf/vaa/internal/cluster"
+)
+
+// counters are the metrics of all nodes a run is evaluated with
+type counters struct {
+	out     map[string]float64 // sent messages by type (vaa_messages_total)
+	trusted float64            // nodes trusting a rumor (vaa_rumor_trusted_total)
+}
+
+var scrapeClient = &http.Client{Timeout: 2 * time.Second}
+
+// scrape sums the metrics of all nodes; in-process nodes share the registry of this process
+func (r *Runner) scrape(c *cluster.Cluster) (*counters, error) {
+	cs := &counters{out: map[string]float64{}}
+	if r.opts.InProcess {
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+		cs.add(mfs)
+		return cs, nil
+	}
+
+	for _, uid := range c.UIDs() {
+		resp, err := scrapeClient.Get(fmt.Sprintf("http://%s/metrics", c.Metrics[uid]))
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := (&expfmt.TextParser{}).TextToMetricFamilies(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics of node %d: %v", uid, err)
+		}
+		mfs := []*dto.MetricFamily{}
+		for _, mf := range parsed {
+			mfs = append(mfs, mf)
+		}
+		cs.add(mfs)
+	}
+	return cs, nil
+}
+
+func (cs *counters) add(mfs []*dto.MetricFamily) {
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "vaa_messages_total":
+			for _, m := range mf.Metric {
+				labels := map[string]string{}
+				for _, l := range m.Label {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["direction"] == "out" {
+					cs.out[labels["type"]] += m.GetCounter().GetValue()
+				}
+			}
+		case "vaa_rumor_trusted_total":
+			for _, m := range mf.Metric {
+				cs.trusted += m.GetCounter().GetValue()
+			}
+		}
+	}
+}
+
+// sub returns the increase since b
+func (cs *counters) sub(b *counters) *counters {
+	d := &counters{out: map[string]float64{}, trusted: cs.trusted - b.trusted}
+	for t, v := range cs.out {
+		d.out[t] = v - b.out[t]
+	}
+	return d
+}
@@ -0,0 +1,42 @@
+package benchmark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// csvHeader are the columns of WriteCSV, same names as the JSON keys
+var csvHeader = []string{
+	"experiment", "run", "seed", "nodes", "edges", "c", "s", "m", "p", "amax",
+	"completed", "duration_seconds", "messages", "messages_total", "trusted", "leader", "agreement", "t_k", "error",
+}
+
+// WriteCSV writes one row per run
+func WriteCSV(w io.Writer, results []*Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range results {
+		row := []string{
+			r.Experiment, fmt.Sprint(r.Run), fmt.Sprint(r.Seed), fmt.Sprint(r.Nodes), fmt.Sprint(r.Edges),
+			fmt.Sprint(r.C), fmt.Sprint(r.S), fmt.Sprint(r.M), fmt.Sprint(r.P), fmt.Sprint(r.AMax),
+			fmt.Sprint(r.Completed), fmt.Sprintf("%.3f", r.Duration), fmt.Sprint(r.Messages), fmt.Sprint(r.MessagesTotal),
+			fmt.Sprint(r.Trusted), fmt.Sprint(r.Leader), fmt.Sprint(r.Agreement), fmt.Sprint(r.TK), r.Error,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes all results as JSON array
+func WriteJSON(w io.Writer, results []*Result) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(results)
+}
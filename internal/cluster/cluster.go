@@ -0,0 +1,334 @@
+// Package cluster launches a cluster of nodes on the local machine, either as node processes or in-process
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// Options configure a cluster; missing config and graph files are generated
+type Options struct {
+	Config   string               // config file; generated with free ports on 127.0.0.1 if empty
+	Graph    string               // graph file; generated from Topology if empty
+	Topology string               // topology of a generated graph; the nodes form a random overlay if empty and no Graph is set
+	Params   neigh.TopologyParams // parameters of the topology; N is the number of nodes of a generated config, M derived from N if 0
+	Seed     int64                // seed of a generated graph
+	Dir      string               // generated files (and the node binary) are stored here; a temporary directory if empty
+
+	InProcess bool               // run the nodes as goroutines of this process instead of spawning NodeBin
+	NodeBin   string             // node binary; built from cmd/node if empty
+	NodeArgs  []string           // additional arguments of every node process, e.g. `--consensus-m=4`
+	Register  func(node.Handler) // registers the extensions of in-process nodes; DefaultExtensions if nil
+	Logs      io.Writer          // aggregated node logs, every line prefixed with the node; os.Stderr if nil
+}
+
+// Cluster is a set of running nodes
+type Cluster struct {
+	opts   Options
+	Config *neigh.Config
+	Graph  *neigh.NeighMap // nil for a random overlay
+	// Metrics holds the metric endpoint of every node process; in-process nodes share the registry of this process
+	Metrics   map[uint]string
+	files     struct{ config, graph string }
+	overlay   *neigh.NeighMap // random overlay of in-process nodes without graph
+	collector *Collector      // receives the states of Snapshot
+	nodes     map[uint]instance
+	wg        sync.WaitGroup
+	done      chan struct{}
+	logMu     sync.Mutex
+}
+
+// instance is a running node
+type instance interface {
+	stop()
+}
+
+// DefaultExtensions registers the same extensions as cmd/node with its default parameters
+func DefaultExtensions(h node.Handler) {
+	h.Register(node.NewControlExtension())
+	h.Register(node.NewDiscoveryExtension())
+	h.Register(node.NewMembershipExtension(""))
+	h.Register(node.NewRumorExtension())
+	h.Register(node.NewDistributedBankingExtension())
+	h.Register(node.NewConsensusExtension(3, 5, 2, 3))
+}
+
+// edges is the default of TopologyParams.M for n nodes; 1.5 edges per node keep a random graph connected without
+// exceeding a complete graph
+func edges(topology string, n uint) uint {
+	if topology == "barabasi-albert" {
+		return 2
+	}
+	m := n * 3 / 2
+	if n > 0 && m < n-1 {
+		m = n - 1
+	}
+	if max := n * (n - 1) / 2; m > max {
+		m = max
+	}
+	return m
+}
+
+// New prepares a cluster; config and graph are loaded or generated, nothing is started yet
+func New(opts Options) (*Cluster, error) {
+	if opts.Logs == nil {
+		opts.Logs = os.Stderr
+	}
+	if opts.Register == nil {
+		opts.Register = DefaultExtensions
+	}
+	if opts.Dir == "" {
+		dir, err := ioutil.TempDir("", "vaa-cluster-")
+		if err != nil {
+			return nil, err
+		}
+		opts.Dir = dir
+	} else if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cluster{opts: opts, Metrics: map[uint]string{}, nodes: map[uint]instance{}, done: make(chan struct{})}
+
+	var err error
+	// Graph
+	switch {
+	case opts.Graph != "":
+		c.files.graph = opts.Graph
+		if c.Graph, err = neigh.LoadGraphFormat(opts.Graph, neigh.FormatFromPath(opts.Graph)); err != nil {
+			return nil, err
+		}
+	case opts.Topology != "":
+		if opts.Params.M == 0 {
+			opts.Params.M = edges(opts.Topology, opts.Params.N)
+		}
+		r := rand.New(rand.NewSource(opts.Seed))
+		if c.Graph, err = neigh.GenTopology(r, opts.Topology, opts.Params); err != nil {
+			return nil, err
+		}
+		c.files.graph = filepath.Join(opts.Dir, "graph.txt")
+		if err := neigh.WriteGraphFormat(c.files.graph, neigh.FormatDOT, c.Graph); err != nil {
+			return nil, err
+		}
+	}
+
+	// Config
+	if opts.Config != "" {
+		c.files.config = opts.Config
+		if c.Config, err = neigh.LoadConfig(opts.Config); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	uids := []uint{}
+	for uid := uint(1); uid <= opts.Params.N; uid++ {
+		uids = append(uids, uid)
+	}
+	if c.Graph != nil {
+		// UIDs of a loaded graph are not necessarily contiguous
+		uids = c.Graph.UIDs()
+	}
+	if len(uids) == 0 {
+		return nil, errors.New("number of nodes unknown, set a config, a graph or the number of nodes")
+	}
+	ports, err := FreePorts(len(uids))
+	if err != nil {
+		return nil, err
+	}
+	c.Config = &neigh.Config{Nodes: map[uint]string{}}
+	for i, uid := range uids {
+		c.Config.Nodes[uid] = fmt.Sprintf("127.0.0.1:%d", ports[i])
+	}
+	c.files.config = filepath.Join(opts.Dir, "config.txt")
+	return c, neigh.WriteConfig(c.files.config, c.Config)
+}
+
+// Files returns the config and graph file (empty for a random overlay) the nodes are started with
+func (c *Cluster) Files() (string, string) {
+	return c.files.config, c.files.graph
+}
+
+// UIDs returns the UIDs of all nodes in ascending order
+func (c *Cluster) UIDs() []uint {
+	uids := []uint{}
+	for uid := range c.Config.Nodes {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// Start launches all nodes; they are stopped when ctx is cancelled or by Stop
+func (c *Cluster) Start(ctx context.Context) error {
+	if !c.opts.InProcess && c.opts.NodeBin == "" {
+		c.opts.NodeBin = filepath.Join(c.opts.Dir, "node")
+		log.Info().Msgf("Building node binary %s", c.opts.NodeBin)
+		if err := Build(c.opts.NodeBin); err != nil {
+			return err
+		}
+	}
+	if !c.opts.InProcess {
+		if err := c.assignMetrics(); err != nil {
+			return err
+		}
+	}
+	restoreLogs := func() {}
+	if c.opts.InProcess {
+		restoreLogs = c.captureLogs()
+	}
+	for _, uid := range c.UIDs() {
+		var i instance
+		var err error
+		if c.opts.InProcess {
+			i, err = c.startInProcess(ctx, uid)
+		} else {
+			i, err = c.startProcess(uid)
+		}
+		if err != nil {
+			c.Stop()
+			return fmt.Errorf("failed to start node %d: %v", uid, err)
+		}
+		c.nodes[uid] = i
+	}
+	go func() {
+		c.wg.Wait()
+		restoreLogs()
+		close(c.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Stop()
+		case <-c.done:
+		}
+	}()
+	return nil
+}
+
+// Ready blocks until all nodes accept connections
+func (c *Cluster) Ready(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, uid := range c.UIDs() {
+		for {
+			conn, err := net.DialTimeout("tcp", c.Config.Nodes[uid], 100*time.Millisecond)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("node %d not ready after %s", uid, timeout)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.done:
+				return errors.New("all nodes exited")
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// Send delivers a message to a node; the client UID is 0
+func (c *Cluster) Send(uid uint, msgType, payload string) error {
+	addr, ok := c.Config.Nodes[uid]
+	if !ok {
+		return fmt.Errorf("unknown node %d", uid)
+	}
+	return com.Send(addr, com.Msg(0, msgType, payload))
+}
+
+// SendAll delivers a message to all nodes at roughly the same time
+func (c *Cluster) SendAll(msgType, payload string) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(c.Config.Nodes))
+	for _, uid := range c.UIDs() {
+		wg.Add(1)
+		go func(uid uint) {
+			defer wg.Done()
+			if err := c.Send(uid, msgType, payload); err != nil {
+				errs <- err
+			}
+		}(uid)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// Stop shuts all nodes down and waits for them to exit
+func (c *Cluster) Stop() {
+	var wg sync.WaitGroup
+	for _, i := range c.nodes {
+		wg.Add(1)
+		go func(i instance) {
+			defer wg.Done()
+			i.stop()
+		}(i)
+	}
+	wg.Wait()
+	c.wg.Wait()
+}
+
+// Done is closed once all nodes exited, e.g. after CONTROL SHUTDOWN
+func (c *Cluster) Done() <-chan struct{} {
+	return c.done
+}
+
+// assignMetrics picks a free port for the metric endpoint of every node process
+func (c *Cluster) assignMetrics() error {
+	used := map[string]bool{}
+	for _, addr := range c.Config.Nodes {
+		used[addr] = true
+	}
+	ports, err := FreePorts(2 * len(c.Config.Nodes))
+	if err != nil {
+		return err
+	}
+	for _, uid := range c.UIDs() {
+		for len(ports) > 0 {
+			addr := fmt.Sprintf("127.0.0.1:%d", ports[0])
+			ports = ports[1:]
+			if !used[addr] {
+				c.Metrics[uid] = addr
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// FreePorts asks the kernel for n unused TCP ports
+func FreePorts(n int) ([]int, error) {
+	ports := []int{}
+	listeners := []net.Listener{}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	// Keep all listeners open until the end, otherwise the same port might be returned twice
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func TestCluster(t *testing.T) {
+	c, err := New(Options{
+		Topology:  "ring",
+		Params:    neigh.TopologyParams{N: 4},
+		Dir:       t.TempDir(),
+		InProcess: true,
+		Logs:      ioutil.Discard,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{1, 2, 3, 4}, c.UIDs())
+	config, graph := c.Files()
+	loaded, err := neigh.LoadConfig(config)
+	assert.Nil(t, err)
+	assert.Equal(t, c.Config, loaded)
+	assert.NotEmpty(t, graph)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, c.Start(ctx))
+	assert.Nil(t, c.Ready(ctx, 5*time.Second))
+	assert.Nil(t, c.SendAll("CONTROL", "STARTUP"))
+
+	// CONTROL SHUTDOWN stops the nodes without the cluster
+	assert.Nil(t, c.SendAll("CONTROL", "SHUTDOWN"))
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("nodes did not exit after SHUTDOWN")
+	}
+}
+
+func TestFreePorts(t *testing.T) {
+	ports, err := FreePorts(20)
+	assert.Nil(t, err)
+	seen := map[int]bool{}
+	for _, p := range ports {
+		assert.False(t, seen[p])
+		seen[p] = true
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	c := &Cluster{opts: Options{}}
+	b := &bytes.Buffer{}
+	c.opts.Logs = b
+	c.Config = &neigh.Config{Nodes: map[uint]string{1: "", 12: ""}}
+	w := c.prefixed(1)
+	w.Write([]byte("first\nsec"))
+	w.Write([]byte("ond\nlast"))
+	assert.Equal(t, "node-01 | first\nnode-01 | second\n", b.String())
+	w.flush()
+	assert.Equal(t, "node-01 | first\nnode-01 | second\nnode-01 | last\n", b.String())
+}
+
+func TestEdges(t *testing.T) {
+	assert.Equal(t, uint(1500), edges("random", 1000))
+	assert.Equal(t, uint(3), edges("random", 3), "at most a complete graph")
+	assert.Equal(t, uint(2), edges("barabasi-albert", 1000))
+
+	c, err := New(Options{Topology: "random", Params: neigh.TopologyParams{N: 1000}, Dir: t.TempDir()})
+	assert.Nil(t, err)
+	assert.Len(t, c.Config.Nodes, 1000)
+}
+
+func TestNodeLogs(t *testing.T) {
+	c := &Cluster{opts: Options{}}
+	b := &bytes.Buffer{}
+	c.opts.Logs = b
+	c.Config = &neigh.Config{Nodes: map[uint]string{1: "", 12: ""}}
+	other := &bytes.Buffer{}
+	l := &nodeLogs{c: c, other: other, nodes: map[uint]io.Writer{}}
+	l.Write([]byte(`{"level":"info","uid":12,"message":"hello"}` + "\n"))
+	l.Write([]byte(`{"level":"info","message":"no node"}` + "\n"))
+	l.Write([]byte(`{"level":"info","uid":3,"message":"unknown node"}` + "\n"))
+	assert.Contains(t, b.String(), "node-12 | ")
+	assert.Contains(t, b.String(), "hello")
+	assert.NotContains(t, b.String(), "no node")
+	assert.Contains(t, other.String(), "no node")
+	assert.Contains(t, other.String(), "unknown node")
+}
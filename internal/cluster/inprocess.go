@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// Random overlay of in-process nodes without graph, same as the defaults of cmd/node
+const (
+	overlaySeed   = 1
+	overlayDegree = 3
+)
+
+// goroutines is a node running in this process
+type goroutines struct {
+	cancel context.CancelFunc
+	exited chan struct{}
+}
+
+func (c *Cluster) startInProcess(ctx context.Context, uid uint) (instance, error) {
+	nm := c.Graph
+	if nm == nil {
+		// Same as neigh.NeighsFromConfig, but the overlay is only generated once
+		if c.overlay == nil {
+			var err error
+			if c.overlay, err = neigh.Overlay(c.Config, overlaySeed, overlayDegree); err != nil {
+				return nil, err
+			}
+		}
+		nm = c.overlay
+	}
+	neighs := neigh.NeighsFor(uid, c.Config, nm)
+	addr := strings.Split(c.Config.Nodes[uid], ":")
+	if len(addr) != 2 {
+		return nil, fmt.Errorf("address of node %d is invalid, has to follow <host>:<port>", uid)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	recvChan := make(chan *com.Message, 1)
+	d := com.NewDispatcher(c.Config.Nodes[uid], recvChan)
+	n := node.New(uid, cancel, neighs)
+	c.opts.Register(n)
+
+	g := &goroutines{cancel: cancel, exited: make(chan struct{})}
+	done := make(chan struct{}, 2)
+	go func() {
+		if err := d.Run(ctx); err != nil {
+			log.Err(err).Uint("uid", uid).Msg("Dispatcher failed")
+			cancel()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		if err := n.Run(ctx, recvChan); err != nil {
+			log.Err(err).Uint("uid", uid).Msg("Node failed")
+		}
+		cancel() // e.g. CONTROL SHUTDOWN
+		done <- struct{}{}
+	}()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		<-done
+		<-done
+		log.Info().Uint("uid", uid).Msg("Node exited")
+		close(g.exited)
+	}()
+	return g, nil
+}
+
+func (g *goroutines) stop() {
+	g.cancel()
+	<-g.exited
+}
+
+// nodeLogs prefixes the log lines of in-process nodes like the output of node processes; the node is taken from the `uid`
+// field, lines without it are written to stderr
+type nodeLogs struct {
+	c     *Cluster
+	other io.Writer
+	nodes map[uint]io.Writer
+	sync.Mutex
+}
+
+// logs routes the global logger to the cluster capturing the logs of its in-process nodes; the global logger is only
+// replaced once, before the first in-process node starts, as node goroutines keep logging through it
+var logs = &logRouter{other: zerolog.ConsoleWriter{Out: os.Stderr}}
+var installLogs sync.Once
+
+// logRouter writes to the nodeLogs of the current in-process cluster or stderr if there is none
+type logRouter struct {
+	other   io.Writer
+	current *nodeLogs
+	sync.Mutex
+}
+
+func (r *logRouter) Write(p []byte) (int, error) {
+	r.Lock()
+	l := r.current
+	r.Unlock()
+	if l == nil {
+		return r.other.Write(p)
+	}
+	return l.Write(p)
+}
+
+// captureLogs routes the global logger through the prefixes of the nodes until restore is called
+func (c *Cluster) captureLogs() (restore func()) {
+	installLogs.Do(func() { log.Logger = log.Output(logs) })
+	l := &nodeLogs{c: c, other: logs.other, nodes: map[uint]io.Writer{}}
+	logs.Lock()
+	logs.current = l
+	logs.Unlock()
+	return func() {
+		logs.Lock()
+		defer logs.Unlock()
+		if logs.current == l {
+			logs.current = nil
+		}
+	}
+}
+
+func (l *nodeLogs) Write(p []byte) (int, error) {
+	e := struct {
+		UID *uint `json:"uid"`
+	}{}
+	if err := json.Unmarshal(p, &e); err != nil || e.UID == nil {
+		return l.other.Write(p)
+	}
+	if _, ok := l.c.Config.Nodes[*e.UID]; !ok {
+		return l.other.Write(p)
+	}
+	l.Lock()
+	w, ok := l.nodes[*e.UID]
+	if !ok {
+		w = zerolog.ConsoleWriter{Out: l.c.prefixed(*e.UID), NoColor: true}
+		l.nodes[*e.UID] = w
+	}
+	l.Unlock()
+	return w.Write(p)
+}
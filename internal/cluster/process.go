@@ -0,0 +1,119 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stopTimeout is the time a node process gets to shut down after SIGINT before it is killed
+const stopTimeout = 5 * time.Second
+
+// process is a node running as child process
+type process struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// Build compiles cmd/node; requires the Go toolchain and the module in the working directory
+func Build(out string) error {
+	cmd := exec.Command("go", "build", "-o", out, "github.com/xvzf/vaa/cmd/node")
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
+
+func (c *Cluster) startProcess(uid uint) (instance, error) {
+	args := []string{fmt.Sprintf("--uid=%d", uid), "--config=" + c.files.config, "--metric=" + c.Metrics[uid]}
+	if c.files.graph != "" {
+		args = append(args, "--graph="+c.files.graph)
+	}
+	args = append(args, c.opts.NodeArgs...)
+
+	w := c.prefixed(uid)
+	cmd := exec.Command(c.opts.NodeBin, args...)
+	cmd.Stdout, cmd.Stderr = w, w
+	ownProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &process{cmd: cmd, exited: make(chan struct{})}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := cmd.Wait()
+		w.flush()
+		if err != nil {
+			log.Warn().Uint("uid", uid).Msgf("Node exited: %v", err)
+		} else {
+			log.Info().Uint("uid", uid).Msg("Node exited")
+		}
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+func (p *process) stop() {
+	select {
+	case <-p.exited:
+		return
+	default:
+	}
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.exited:
+	case <-time.After(stopTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// prefixWriter writes complete lines to the aggregated log, prefixed with the node
+type prefixWriter struct {
+	c      *Cluster
+	prefix string
+	buf    []byte
+	sync.Mutex
+}
+
+func (c *Cluster) prefixed(uid uint) *prefixWriter {
+	width := len(fmt.Sprint(c.UIDs()[len(c.Config.Nodes)-1]))
+	return &prefixWriter{c: c, prefix: fmt.Sprintf("node-%0*d | ", width, uid)}
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		w.write(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush writes an incomplete last line
+func (w *prefixWriter) flush() {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.buf) > 0 {
+		w.write(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) write(line []byte) {
+	w.c.logMu.Lock()
+	defer w.c.logMu.Unlock()
+	io.WriteString(w.c.opts.Logs, w.prefix)
+	w.c.opts.Logs.Write(line)
+}
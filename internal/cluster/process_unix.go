@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package cluster
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// ownProcessGroup starts the node in its own process group; a Ctrl-C in the terminal only reaches the cluster, which shuts
+// the nodes down in order
+func ownProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
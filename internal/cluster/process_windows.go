@@ -0,0 +1,6 @@
+package cluster
+
+import "os/exec"
+
+// ownProcessGroup is not supported on Windows, a Ctrl-C reaches the nodes as well
+func ownProcessGroup(cmd *exec.Cmd) {}
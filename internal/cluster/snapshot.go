@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// Snapshot is a consistent global state collected with CONTROL SNAPSHOT
+type Snapshot struct {
+	ID      string
+	Time    time.Time
+	States  map[uint]*node.NodeState
+	Missing []uint // nodes that did not report in time
+}
+
+// Collector receives the states the nodes report for a snapshot
+type Collector struct {
+	listen   string
+	recvChan chan *com.Message
+}
+
+// NewCollector starts listening for reports on listen until ctx is cancelled
+func NewCollector(ctx context.Context, listen string) (*Collector, error) {
+	co := &Collector{listen: listen, recvChan: make(chan *com.Message, 64)}
+	d := com.NewDispatcher(listen, co.recvChan)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.Run(ctx)
+	}()
+	// Give the dispatcher time to listen before the first reports arrive
+	select {
+	case err := <-errs:
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	case <-time.After(100 * time.Millisecond):
+	}
+	return co, nil
+}
+
+// Collect triggers a snapshot on connect and waits up to timeout for the states of all nodes
+func (co *Collector) Collect(ctx context.Context, nodes map[uint]string, connect string, timeout time.Duration) *Snapshot {
+	s := &Snapshot{ID: uuid.NewString(), Time: time.Now().UTC(), States: map[uint]*node.NodeState{}}
+
+	log.Info().Msgf("Requesting snapshot %s from %s", s.ID, connect)
+	if err := com.Send(connect, com.Msg(0, "CONTROL", fmt.Sprintf("SNAPSHOT %s %s", s.ID, co.listen))); err != nil {
+		log.Err(err).Msg("Failed to initiate snapshot")
+	}
+
+	deadline := time.After(timeout)
+	for len(s.States) < len(nodes) {
+		select {
+		case msg := <-co.recvChan:
+			ps := strings.SplitN(*msg.Payload, " ", 3)
+			if len(ps) != 3 || ps[0] != "STATE" || ps[1] != s.ID {
+				continue // e.g. late report of a previous snapshot
+			}
+			state := &node.NodeState{}
+			if err := json.Unmarshal([]byte(ps[2]), state); err != nil {
+				log.Err(err).Uint("src_uid", *msg.SourceUID).Msg("Invalid state")
+				continue
+			}
+			s.States[state.UID] = state
+		case <-deadline:
+			log.Warn().Msgf("Snapshot %s timed out, %d/%d nodes reported", s.ID, len(s.States), len(nodes))
+			for uid := range nodes {
+				if _, ok := s.States[uid]; !ok {
+					s.Missing = append(s.Missing, uid)
+				}
+			}
+			sort.Slice(s.Missing, func(i, j int) bool { return s.Missing[i] < s.Missing[j] })
+			return s
+		case <-ctx.Done():
+			return s
+		}
+	}
+	return s
+}
+
+// Snapshot collects the global state of the cluster, initiated by the node with the lowest UID; the first call
+// starts a collector on a free port listening until ctx is cancelled
+func (c *Cluster) Snapshot(ctx context.Context, timeout time.Duration) (*Snapshot, error) {
+	if c.collector == nil {
+		ports, err := FreePorts(1)
+		if err != nil {
+			return nil, err
+		}
+		if c.collector, err = NewCollector(ctx, fmt.Sprintf("127.0.0.1:%d", ports[0])); err != nil {
+			return nil, err
+		}
+	}
+	return c.collector.Collect(ctx, c.Config.Nodes, c.Config.Nodes[c.UIDs()[0]], timeout), nil
+}
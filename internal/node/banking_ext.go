@@ -9,12 +9,11 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/xvzf/vaa/pkg/com"
 )
@@ -31,7 +30,7 @@ type snapshot struct {
 func NewSnapshot(h *handler, balance int, randP int) *snapshot {
 	s := &snapshot{h.uid, map[uint][]*com.Message{}, balance, randP, map[uint]bool{}}
 
-	for uid := range h.neighs.Nodes {
+	for uid := range h.neighs.Incoming() {
 		s.msgInActive[uid] = true
 		s.MsgIn[uid] = []*com.Message{}
 	}
@@ -75,11 +74,15 @@ type banking struct {
 	lm                *lamportMutexQueue
 	lockRequestLC     int
 	lockRequestActive bool
-	lockAckCounter    int
+	lockAcks          map[uint]bool // nodes that acknowledged the own request
+
+	// Metrics
+	lockRequested          time.Time
+	criticalSectionEntered time.Time
 
 	// Flooding
 	knownMutex sync.Mutex
-	known      map[string]struct{} // keep track of known mesages
+	known      map[string]time.Time // keep track of known mesages, by the time they were first seen
 
 	// Tranaction balance
 	balance                 int
@@ -91,17 +94,21 @@ type banking struct {
 	snapshotMutex     sync.Mutex
 	snapshots         map[string]*snapshot
 	receivedSnapshots map[string][]*snapshot
+
+	// Messages distributed while the spanning tree is rebuilt
+	deferred []*com.Message
+
+	// Observer (only used on the leader)
+	observer        bool // observer loop scheduled
+	marker          string
+	oldBalance      int
+	snapshotStarted time.Time
 }
 
 func NewDistributedBankingExtension() (Extension, string) {
-	rand.Seed(time.Now().UnixNano())
-	wantLeader := rand.Intn(2) == 1 // 50% chance of being true
-	balance := rand.Intn(100000)
-	log.Info().Msgf("Wants to be leader: %t", wantLeader)
-	log.Info().Msgf("Starting balance: %d", balance)
 	return &banking{
 		// Leader Election / communicate to leader
-		leader: NewLeader("BANKING", wantLeader),
+		leader: NewLeader("BANKING", false), // wantLeader is drawn in the preflight
 
 		// Lamport Clock
 		lc: &lamportClock{},
@@ -109,28 +116,194 @@ func NewDistributedBankingExtension() (Extension, string) {
 		// Lamport mutual exclusion
 		lm:                NewLamportMutexQueue(),
 		lockRequestLC:     -1,
-		lockAckCounter:    0,
+		lockAcks:          map[uint]bool{},
 		lockRequestActive: false,
 
 		// Flooding
-		known: map[string]struct{}{},
+		known: map[string]time.Time{},
 
 		// Transaction balance
-		balance: balance,
+		balance: 0, // drawn in the preflight
 		randP:   0, // updated on every request
 
 		// Snapshot
 		snapshots:         map[string]*snapshot{},
 		receivedSnapshots: map[string][]*snapshot{},
+		oldBalance:        -1,
 	}, "BANKING"
 }
 
 func (b *banking) Preflight(ctx context.Context, h *handler) error {
-	go b.leaderLoop(ctx, h)
-	go b.transactionLoop(ctx, h)
+	b.leader.wantLeader = h.intn(2) == 1 // 50% chance of being true
+	b.balance = h.intn(100000)
+	log.Info().Msgf("Wants to be leader: %t", b.leader.wantLeader)
+	log.Info().Msgf("Starting balance: %d", b.balance)
+	bankingBalance.Set(float64(b.balance))
+
+	b.restore(h)
+
+	b.observer = true
+	h.after(50*time.Millisecond, "banking.observer", func() { b.awaitObserver(h) })
+	h.after(50*time.Millisecond, "banking.transaction", func() { b.awaitTransactions(h) })
+	h.after(knownRetention, "banking.known", func() { b.pruneKnown(h) })
 	return nil
 }
 
+// bankingDurable is the durable state of the banking experiment; known messages are persisted individually
+type bankingDurable struct {
+	Balance      int         `json:"balance"`
+	LamportClock int         `json:"lamport_clock"`
+	LockQueue    map[int]int `json:"lock_queue"` // timestamp -> node
+
+	// Own lock request, resumed after a restart
+	LockRequestLC     int    `json:"lock_request_lc"`
+	LockAcks          []uint `json:"lock_acks"`
+	LockRequestActive bool   `json:"lock_request_active"`
+}
+
+// persist writes the balance, the lamport clock and the mutex queue
+func (b *banking) persist(h *handler) {
+	s := &bankingDurable{
+		Balance:           b.balance,
+		LockQueue:         map[int]int{},
+		LockRequestLC:     b.lockRequestLC,
+		LockRequestActive: b.lockRequestActive,
+	}
+	for nuid := range b.lockAcks {
+		s.LockAcks = append(s.LockAcks, nuid)
+	}
+	sort.Slice(s.LockAcks, func(i, j int) bool { return s.LockAcks[i] < s.LockAcks[j] })
+	b.lc.Lock()
+	s.LamportClock = b.lc.LC
+	b.lc.Unlock()
+	for ts, nuid := range b.lm.tsNodeMap {
+		s.LockQueue[ts] = nuid
+	}
+	h.persist("BANKING/state", s)
+}
+
+// restore loads the state of a previous run, the random draws of the preflight are overwritten
+func (b *banking) restore(h *handler) {
+	b.leader.restore(h)
+
+	s := &bankingDurable{}
+	if h.restore("BANKING/state", s) {
+		b.balance = s.Balance
+		b.lc.LC = s.LamportClock
+		for ts, nuid := range s.LockQueue {
+			b.lm.Add(ts, nuid)
+		}
+		b.lockRequestLC = s.LockRequestLC
+		for _, nuid := range s.LockAcks {
+			b.lockAcks[nuid] = true
+		}
+		b.lockRequestActive = s.LockRequestActive
+		bankingBalance.Set(float64(b.balance))
+		log.Info().Msgf("Restored balance: %d", b.balance)
+	}
+	for _, key := range h.restoreKeys("BANKING/known/") {
+		seen := time.Time{}
+		h.restore(key, &seen)
+		b.known[strings.TrimPrefix(key, "BANKING/known/")] = seen
+	}
+}
+
+// markKnown marks a flooded message as handled, the caller holds knownMutex
+func (b *banking) markKnown(h *handler, rUID string) {
+	now := h.now()
+	b.known[rUID] = now
+	h.persist("BANKING/known/"+rUID, now)
+}
+
+// knownRetention is how long flooded messages are remembered; duplicates arrive within the flooding of a message
+const knownRetention = time.Minute
+
+// pruneKnown forgets the flooded messages seen before the retention, which would otherwise grow the state forever
+func (b *banking) pruneKnown(h *handler) {
+	b.knownMutex.Lock()
+	defer b.knownMutex.Unlock()
+	for rUID, seen := range b.known {
+		if h.now().Sub(seen) > knownRetention {
+			delete(b.known, rUID)
+			h.forget("BANKING/known/" + rUID)
+		}
+	}
+	h.after(knownRetention, "banking.known", func() { b.pruneKnown(h) })
+}
+
+// NeighbourChanged drops the lock requests of a failed neighbour, otherwise the mutex would wait for its release forever;
+// the election is restarted if the failure broke the spanning tree
+func (b *banking) NeighbourChanged(h *handler, uid uint, status NodeStatus) {
+	if b.leader.neighbourChanged(h, uid, status) {
+		b.resumeObserver(h)
+	}
+	if status != StatusDead {
+		return
+	}
+	// Snapshots waiting for the marker of the failed node complete without it
+	b.snapshotMutex.Lock()
+	markers := []string{}
+	for marker, s := range b.snapshots {
+		if s.msgInActive[uid] {
+			markers = append(markers, marker)
+		}
+	}
+	sort.Strings(markers)
+	for _, marker := range markers {
+		if err := b.checkSnapshot(h, marker); err != nil {
+			log.Err(err).Msg("Failed to complete snapshot")
+		}
+	}
+	b.snapshotMutex.Unlock()
+	if b.lm.Remove(int(uid)) {
+		// The failed node held the lock, acknowledge the next request
+		if lockLC, lockNUID, ok := b.lm.Next(); ok && lockNUID != int(h.uid) {
+			b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d;%d", lockNUID, lockLC, h.uid)))
+		}
+	}
+	if nuid, ok := b.lm.tsNodeMap[b.lockRequestLC]; ok && nuid == int(h.uid) && !b.lockRequestActive {
+		// The failed node might have been the last one missing
+		b.checkLock(h)
+	}
+	b.persist(h)
+}
+
+// MembershipChanged re-elects the leader, the spanning tree is no longer valid; requests of a left node are dropped
+func (b *banking) MembershipChanged(h *handler, c *MembershipChange) {
+	b.leader.restart(h)
+	if !c.Join && b.lm.Remove(int(c.UID)) {
+		if lockLC, lockNUID, ok := b.lm.Next(); ok && lockNUID != int(h.uid) {
+			b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d;%d", lockNUID, lockLC, h.uid)))
+		}
+	}
+	if nuid, ok := b.lm.tsNodeMap[b.lockRequestLC]; ok && nuid == int(h.uid) {
+		if c.Join {
+			// The joined node does not know the pending (or granted) request yet; the others just forward it
+			b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockRequest;<placeholder>;%d;%d", h.uid, b.lockRequestLC)))
+		} else if !b.lockRequestActive {
+			// The left node might have been the last one missing
+			b.checkLock(h)
+		}
+	}
+	b.resumeObserver(h)
+	b.persist(h)
+}
+
+// TopologyChanged re-elects the leader, the spanning tree is no longer valid
+func (b *banking) TopologyChanged(h *handler, added, removed []uint) {
+	b.leader.restart(h)
+	b.resumeObserver(h)
+	b.persist(h)
+}
+
+// resumeObserver waits for the new election if the node stopped observing
+func (b *banking) resumeObserver(h *handler) {
+	if !b.observer {
+		b.observer = true
+		h.after(50*time.Millisecond, "banking.observer", func() { b.awaitObserver(h) })
+	}
+}
+
 // floodWithLamport is a simple network flooding, increasing the lamport clock for every transmitted message
 func (b *banking) floodWithLamportClock(h *handler, msg *com.Message) int {
 	counter := 0
@@ -147,11 +320,12 @@ func (b *banking) floodWithLamportClock(h *handler, msg *com.Message) int {
 		b.knownMutex.Unlock()
 		return counter
 	} else {
-		b.known[rUID] = struct{}{}
+		b.markKnown(h, rUID)
 	}
 	b.knownMutex.Unlock()
 
-	for nuid, connect := range h.neighs.Nodes {
+	for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+		connect := h.neighs.Nodes[nuid]
 		if nuid == *msg.SourceUID {
 			continue
 		}
@@ -164,7 +338,7 @@ func (b *banking) floodWithLamportClock(h *handler, msg *com.Message) int {
 		msg.Payload = com.StrPointer(strings.Join(ss, ";"))
 
 		// Send message
-		if err := com.Send(connect, com.MsgPropagate(h.uid, msg)); err != nil {
+		if err := h.send(connect, com.MsgPropagate(h.uid, msg)); err != nil {
 			log.Err(err).Msg("Failed to propagate")
 		} else {
 			counter = counter + 1
@@ -177,8 +351,13 @@ func (b *banking) floodWithLamportClock(h *handler, msg *com.Message) int {
 func (b *banking) Handle(h *handler, msg *com.Message) error {
 	// Try to handle leader elect message, those do not have timestamps attached to them
 	if ok, err := b.leader.TryHandleLeaderMessage(h, msg); ok {
+		if strings.HasPrefix(*msg.Payload, "restart") {
+			// Re-election after a failure, the new leader observes
+			b.resumeObserver(h)
+		}
 		return err
 	}
+	defer b.persist(h)
 
 	// FIXME Handle snapshot messages
 	switch payload := *msg.Payload; {
@@ -232,190 +411,235 @@ func (b *banking) Handle(h *handler, msg *com.Message) error {
 	return nil
 }
 
-// Perform regular distributed transactions
-func (b *banking) transactionLoop(ctx context.Context, h *handler) error {
-
-	// Block until leader collection is OK
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping transaction loop (banking)")
-			return nil
-		case <-time.After(50 * time.Millisecond):
-		}
-		if b.leader.ElectionComplete() {
-			break
-		}
+// awaitTransactions blocks the transactions until the leader election is complete
+func (b *banking) awaitTransactions(h *handler) {
+	if !b.leader.ElectionComplete() {
+		h.after(50*time.Millisecond, "banking.transaction", func() { b.awaitTransactions(h) })
+		return
 	}
 
 	log.Warn().Msg("starting transaction loop (banking)")
+	if nuid, ok := b.lm.tsNodeMap[b.lockRequestLC]; ok && nuid == int(h.uid) {
+		// Lock requested before a restart; a transaction in the critical section is started again
+		log.Info().Msgf("Resuming lock request %d", b.lockRequestLC)
+		b.awaitLock(h)
+		return
+	}
+	b.scheduleTransaction(h)
+}
 
-	for {
-		// Sleep between 0 and 3 seconds
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping transaction loop (banking)")
-			return nil
-		case <-time.After(time.Duration(rand.Intn(3000)) * time.Millisecond):
-		}
+// scheduleTransaction performs regular distributed transactions; sleep between 0 and 3 seconds
+func (b *banking) scheduleTransaction(h *handler) {
+	h.after(time.Duration(h.intn(3000))*time.Millisecond, "banking.transaction", func() { b.requestLock(h) })
+}
 
-		// Aquire mutex lock
-		reqLC := b.lc.Tick()
-		b.lockAckCounter = 0
-		b.lm.Add(reqLC, int(h.uid))
-		b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockRequest;<placeholder>;%d;%d", h.uid, reqLC)))
-
-		// Block until lock acquired
-		for {
-			// Context aware sleep
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("Stopping transaction loop (banking)")
-				return nil
-			case <-time.After(50 * time.Millisecond):
-			}
-			if b.lockRequestActive {
-				break
-			}
-		}
-		log.Warn().Msg("ENTERING CRITICAL SECTION")
-
-		// Initiate the transaction
-		b.transactAckReceived = false
-		b.transactBalanceReceived = false
-		b.randP = rand.Intn(100)
-
-		// Random neighbour
-		randN := rand.Intn(len(h.neighs.AllNodes)) + 1
-		for {
-			if randN != int(h.uid) {
-				break
-			}
-			randN = rand.Intn(len(h.neighs.AllNodes)) + 1
-		}
+// requestLock requests the lamport mutex
+func (b *banking) requestLock(h *handler) {
+	if !b.leader.ElectionComplete() {
+		// Re-election after a membership change, the request is distributed along the new spanning tree
+		h.after(50*time.Millisecond, "banking.transaction", func() { b.requestLock(h) })
+		return
+	}
+	b.lockRequested = h.now()
+	b.lockRequestLC = b.lc.Tick()
+	b.lockAcks = map[uint]bool{}
+	b.lm.Add(b.lockRequestLC, int(h.uid))
+	b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockRequest;<placeholder>;%d;%d", h.uid, b.lockRequestLC)))
 
-		// Send start message
-		reqStart := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactStart;<placeholder>;%s;%d;%d;%d", uuid.NewString()[:8], randN, b.balance, b.randP))
-		reqBalance := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactGetBalance;<placeholder>;%s;%d", uuid.NewString()[:8], randN))
-
-		log.Info().Msgf("Starting transaction with node %d; own balance: %d; random p: %d", randN, b.balance, b.randP)
-		// FIXME; swapped order of those messages on purpose - those are in the opposite order for the scenario described in the exercise sheet
-		b.floodWithLamportClock(h, reqBalance)
-		b.floodWithLamportClock(h, reqStart)
-
-		// Wait for the conditions to be OK
-		for {
-			// Context aware sleep
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("Stopping transaction loop (banking)")
-				return nil
-			case <-time.After(1 * time.Second):
-			}
-			// We need to both perform the balance update on our and as well as want the other node to update its balance
-			if b.transactAckReceived && b.transactBalanceReceived {
-				break
-			}
+	b.persist(h)
+
+	h.after(50*time.Millisecond, "banking.transaction", func() { b.awaitLock(h) })
+}
+
+// checkLock enters the lock once all other nodes acknowledged the request; failed nodes are not waited for
+func (b *banking) checkLock(h *handler) {
+	missing := []uint{}
+	for _, nuid := range sortedUIDs(h.neighs.AllNodes) {
+		if nuid != h.uid && !b.lockAcks[nuid] && h.status(nuid) != StatusDead {
+			missing = append(missing, nuid)
 		}
+	}
+	if len(missing) == 0 {
+		b.lockRequestActive = true
+		log.Info().Msg("Lamport Mutex lock active on this node")
+	} else {
+		log.Info().Msgf("Received ack from %d nodes, waiting for %v", len(b.lockAcks), missing)
+	}
+}
 
-		log.Warn().Msg("EXIT CRITICAL SECTION")
-		// Release mutex lock
-		b.lm.Pop()
-		b.lockRequestActive = false
-		b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockRelease;<placeholder>;%d;%d", h.uid, reqLC)))
-		// Check if there's another node requesting a lock
-		if lockLC, lockNUID, ok := b.lm.Next(); ok {
-			// Send ACK to the next node
-			b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d", lockNUID, lockLC)))
+// awaitLock blocks until the lock is acquired, then initiates the transaction
+func (b *banking) awaitLock(h *handler) {
+	if !b.lockRequestActive {
+		h.after(50*time.Millisecond, "banking.transaction", func() { b.awaitLock(h) })
+		return
+	}
+	log.Warn().Msg("ENTERING CRITICAL SECTION")
+	b.criticalSectionEntered = h.now()
+	bankingLockWait.Observe(b.criticalSectionEntered.Sub(b.lockRequested).Seconds())
+	h.emit(EventLockAcquired, map[string]interface{}{"lamport_clock": b.lockRequestLC})
+
+	// Initiate the transaction
+	b.transactAckReceived = false
+	b.transactBalanceReceived = false
+	b.randP = h.intn(100)
+
+	// Random node; UIDs are not contiguous once nodes joined or left
+	others := []uint{}
+	for _, nuid := range sortedUIDs(h.neighs.AllNodes) {
+		if nuid != h.uid {
+			others = append(others, nuid)
 		}
+	}
+	if len(others) == 0 {
+		// Last node of the cluster, there is no partner for a transaction
+		log.Warn().Msg("No other node left, skipping the transaction")
+		b.transactAckReceived = true
+		b.transactBalanceReceived = true
+		b.awaitTransaction(h)
+		return
+	}
+	randN := others[h.intn(len(others))]
+
+	// Send start message
+	reqStart := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactStart;<placeholder>;%s;%d;%d;%d", h.randID(), randN, b.balance, b.randP))
+	reqBalance := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactGetBalance;<placeholder>;%s;%d", h.randID(), randN))
+
+	log.Info().Msgf("Starting transaction with node %d; own balance: %d; random p: %d", randN, b.balance, b.randP)
+	// FIXME; swapped order of those messages on purpose - those are in the opposite order for the scenario described in the exercise sheet
+	b.floodWithLamportClock(h, reqBalance)
+	b.floodWithLamportClock(h, reqStart)
+	b.persist(h)
 
+	h.after(1*time.Second, "banking.transaction", func() { b.awaitTransaction(h) })
+}
+
+// awaitTransaction waits for the transaction to complete and releases the lock afterwards
+func (b *banking) awaitTransaction(h *handler) {
+	// We need to both perform the balance update on our and as well as want the other node to update its balance
+	// The release is distributed along the spanning tree, wait for a re-election as well
+	if !(b.transactAckReceived && b.transactBalanceReceived) || !b.leader.ElectionComplete() {
+		h.after(1*time.Second, "banking.transaction", func() { b.awaitTransaction(h) })
+		return
+	}
+
+	log.Warn().Msg("EXIT CRITICAL SECTION")
+	bankingCriticalSection.Observe(h.now().Sub(b.criticalSectionEntered).Seconds())
+	h.emit(EventLockReleased, map[string]interface{}{"lamport_clock": b.lockRequestLC})
+	// Release mutex lock
+	b.lm.Pop()
+	b.lockRequestActive = false
+	b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockRelease;<placeholder>;%d;%d", h.uid, b.lockRequestLC)))
+	// Check if there's another node requesting a lock
+	if lockLC, lockNUID, ok := b.lm.Next(); ok {
+		// Send ACK to the next node
+		b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d;%d", lockNUID, lockLC, h.uid)))
 	}
+	b.persist(h)
+
+	b.scheduleTransaction(h)
 }
 
-// Leader election
-func (b *banking) leaderLoop(ctx context.Context, h *handler) error {
+// Inspect exposes the balance and whether this node is in the critical section
+func (b *banking) Inspect() map[string]interface{} {
+	s := b.leader.inspect()
+	s["balance"] = b.balance
+	s["critical_section"] = b.lockRequestActive
+	b.lc.Lock()
+	s["lamport_clock"] = b.lc.LC
+	b.lc.Unlock()
+	return s
+}
 
-	// Block until this node is leader
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping leader (banking)")
-			return nil
-		case <-time.After(50 * time.Millisecond):
-		}
-		if b.leader.IsLeader() {
-			break
-		} else if b.leader.ElectionComplete() {
-			log.Warn().Msg("This node lost the election (banking)")
-			return nil
-		}
-	}
+// awaitObserver blocks until the election is complete; the leader observes the network balance
+func (b *banking) awaitObserver(h *handler) {
+	if b.leader.IsLeader() {
+		log.Warn().Msg("starting observer (banking)")
+		h.after(5*time.Second, "banking.observer", func() { b.observe(h) })
+		return
+	} else if b.leader.ElectionComplete() {
+		log.Warn().Msg("This node lost the election (banking)")
+		b.observer = false
+		return
+	}
+	h.after(50*time.Millisecond, "banking.observer", func() { b.awaitObserver(h) })
+}
 
-	log.Warn().Msg("starting observer (banking)")
+// observe regularly takes consistent snapshots and computes the network balance
+func (b *banking) observe(h *handler) {
+	if !b.leader.IsLeader() {
+		// Re-election after a membership change
+		h.after(50*time.Millisecond, "banking.observer", func() { b.awaitObserver(h) })
+		return
+	}
+	defer h.after(5*time.Second, "banking.observer", func() { b.observe(h) })
 
-	oldBalance := -1
-	marker := ""
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping leader (banking)")
-			return nil
-		case <-time.After(5 * time.Second):
+	// Wait for results or start new state request; dead nodes never answer, same as in checkLock
+	live := 0
+	for uid := range h.neighs.AllNodes {
+		if h.status(uid) != StatusDead {
+			live++
 		}
-
-		// Wait for results or start new state request
-		if marker == "" || len(b.receivedSnapshots[marker]) == len(h.neighs.AllNodes) {
-			if marker != "" {
-				// Compute Network balance without events involved
-				balance := 0
-				affectingMsg := 0
-				for _, s := range b.receivedSnapshots[marker] {
-					balance = balance + s.Balance
-					for _, v := range s.MsgIn {
-						for _, m := range v {
-							// Check if payload starts with
-							switch payload := *m.Payload; {
-							case strings.HasPrefix(payload, "transactStart"): // Check if payload is allowed
-								affectingMsg = affectingMsg + 1
-							case strings.HasPrefix(payload, "transactBalance"): // Check if payload is allowed
-								affectingMsg = affectingMsg + 1
-							}
-						}
-					}
-				}
-				if balance != oldBalance && affectingMsg == 0 {
-					log.Warn().Msgf("Balance changed, old: %d, now: %d", oldBalance, balance)
-					oldBalance = balance
-				} else {
-					if affectingMsg != 0 {
-						log.Warn().Msgf("There are %d messages in the snapshot that might affect the state, skipping", affectingMsg)
+	}
+	if b.marker != "" && len(b.receivedSnapshots[b.marker]) < live {
+		return
+	}
+
+	if b.marker != "" {
+		bankingSnapshotDuration.Observe(h.now().Sub(b.snapshotStarted).Seconds())
+		// Compute Network balance without events involved
+		balance := 0
+		affectingMsg := 0
+		for _, s := range b.receivedSnapshots[b.marker] {
+			balance = balance + s.Balance
+			for _, v := range s.MsgIn {
+				for _, m := range v {
+					// Check if payload starts with
+					switch payload := *m.Payload; {
+					case strings.HasPrefix(payload, "transactStart"): // Check if payload is allowed
+						affectingMsg = affectingMsg + 1
+					case strings.HasPrefix(payload, "transactBalance"): // Check if payload is allowed
+						affectingMsg = affectingMsg + 1
 					}
-					log.Info().Msgf("Balance did not change (%d)", balance)
 				}
 			}
-
-			// Got result; next iteration
-			marker = uuid.NewString()[:8]
-			log.Info().Msg("Starting consistent snapshot")
-			b.snapshotMutex.Lock()
-			b.snapshots[marker] = NewSnapshot(h, b.balance, b.randP)
-			b.receivedSnapshots[marker] = []*snapshot{}
-			b.snapshotMutex.Unlock()
-			m := com.Msg(h.uid, "BANKING", fmt.Sprintf("marker;%s", marker))
-			for _, connect := range h.neighs.Nodes {
-				if err := com.Send(connect, m); err != nil {
-					log.Err(err).Msg("failed to send marker init")
-				}
+		}
+		if balance != b.oldBalance && affectingMsg == 0 {
+			log.Warn().Msgf("Balance changed, old: %d, now: %d", b.oldBalance, balance)
+			b.oldBalance = balance
+		} else {
+			if affectingMsg != 0 {
+				log.Warn().Msgf("There are %d messages in the snapshot that might affect the state, skipping", affectingMsg)
 			}
+			log.Info().Msgf("Balance did not change (%d)", balance)
 		}
+		h.emit(EventSnapshotBalance, map[string]interface{}{"balance": balance, "affecting_messages": affectingMsg})
+	}
 
+	// Got result; next iteration
+	b.marker = h.randID()
+	b.snapshotStarted = h.now()
+	log.Info().Msg("Starting consistent snapshot")
+	b.snapshotMutex.Lock()
+	b.snapshots[b.marker] = NewSnapshot(h, b.balance, b.randP)
+	b.receivedSnapshots[b.marker] = []*snapshot{}
+	b.snapshotMutex.Unlock()
+	m := com.Msg(h.uid, "BANKING", fmt.Sprintf("marker;%s", b.marker))
+	for _, uid := range sortedUIDs(h.neighs.Nodes) {
+		if err := h.send(h.neighs.Nodes[uid], m); err != nil {
+			log.Err(err).Msg("failed to send marker init")
+		}
 	}
 }
 
 // DistributeSpanningTree propagates messages along the spanning tree, more efficient compared to simple flooding
 func (b *banking) distributeWithLamportClock(h *handler, msg *com.Message) int {
 	if !b.leader.ElectionComplete() {
-		log.Error().Msg("distribute requires a spanning tree; leader election not complete")
+		// Re-election after a membership change; distributed once the new spanning tree is known
+		log.Info().Msgf("Deferring %s until the election is complete", *msg.Payload)
+		b.deferred = append(b.deferred, msg)
+		if len(b.deferred) == 1 {
+			h.after(50*time.Millisecond, "banking.deferred", func() { b.flushDeferred(h) })
+		}
 		return 0
 	}
 
@@ -441,7 +665,7 @@ func (b *banking) distributeWithLamportClock(h *handler, msg *com.Message) int {
 		msg.Payload = com.StrPointer(strings.Join(ss, ";"))
 
 		// Send message
-		err := com.Send(connect, com.MsgPropagate(h.uid, msg))
+		err := h.send(connect, com.MsgPropagate(h.uid, msg))
 		if err != nil {
 			log.Err(err).Msg("Failed to proagate")
 		}
@@ -451,6 +675,19 @@ func (b *banking) distributeWithLamportClock(h *handler, msg *com.Message) int {
 	return total
 }
 
+// flushDeferred distributes the deferred messages along the new spanning tree
+func (b *banking) flushDeferred(h *handler) {
+	if !b.leader.ElectionComplete() {
+		h.after(50*time.Millisecond, "banking.deferred", func() { b.flushDeferred(h) })
+		return
+	}
+	deferred := b.deferred
+	b.deferred = nil
+	for _, msg := range deferred {
+		b.distributeWithLamportClock(h, msg)
+	}
+}
+
 // ==== Lamport Mutual Exclusion
 
 // handle_lockRequest handles the lock requests
@@ -467,9 +704,10 @@ func (b *banking) handle_lockRequest(h *handler, msg *com.Message) error {
 
 	if ok, err := b.lm.Add(lockLC, lockNUID); ok {
 		// directly distribute ACK
-		b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d", lockNUID, lockLC)))
+		b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d;%d", lockNUID, lockLC, h.uid)))
 	} else if err != nil {
-		return err
+		// Request announced again for a joined node, already acknowledged
+		log.Debug().Err(err).Msg("Known lock request")
 	}
 
 	// Distribute the message across the spanning tree
@@ -498,7 +736,7 @@ func (b *banking) handle_lockRelease(h *handler, msg *com.Message) error {
 	// Check if we should send the next ACK for the next waiting lock entry
 	if lockLC, lockNUID, ok := b.lm.Next(); ok {
 		// directly distribute ACK
-		b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d", lockNUID, lockLC)))
+		b.distributeWithLamportClock(h, com.Msg(h.uid, "BANKING", fmt.Sprintf("lockAck;<placeholder>;%d;%d;%d", lockNUID, lockLC, h.uid)))
 	}
 
 	// Distribute the message across the spanning tree
@@ -520,19 +758,18 @@ func (b *banking) handle_lockAck(h *handler, msg *com.Message) error {
 	if err != nil {
 		return err
 	}
+	ackUID, err := nthInt(*msg.Payload, 4)
+	if err != nil {
+		return err
+	}
 
 	// Only affects if this node requested the lock
 	if lockNUID == int(h.uid) {
 		// Update local state -> we have the lock
-		if reqLC > lockLC {
-			b.lockAckCounter = b.lockAckCounter + 1
-		}
-		if n := len(h.neighs.AllNodes) - 1; b.lockAckCounter == n {
-			b.lockRequestActive = true
-			log.Info().Msg("Lamport Mutex lock active on this node")
-		} else {
-			log.Info().Msgf("Received ack from %d/%d nodes", b.lockAckCounter, n)
+		if reqLC > lockLC && lockLC == b.lockRequestLC {
+			b.lockAcks[uint(ackUID)] = true
 		}
+		b.checkLock(h)
 	} else {
 		// Distribute the message across the spanning tree
 		b.distributeWithLamportClock(h, msg)
@@ -578,10 +815,11 @@ func (b *banking) handle_transactStart(h *handler, msg *com.Message) error {
 			b.balance = b.balance - (b.balance/100)*p
 		}
 		log.Info().Msgf("Updated balance from %d to %d", oldBalance, b.balance)
+		bankingBalance.Set(float64(b.balance))
 
-		resp := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactAck;<placeholder>;%s", uuid.NewString()[:8]))
+		resp := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactAck;<placeholder>;%s", h.randID()))
 		b.knownMutex.Lock()
-		b.known[rUID] = struct{}{}
+		b.markKnown(h, rUID)
 		b.knownMutex.Unlock()
 		b.floodWithLamportClock(h, resp)
 	} else {
@@ -608,7 +846,7 @@ func (b *banking) handle_transactAck(h *handler, msg *com.Message) error {
 
 	if b.lockRequestActive {
 		b.knownMutex.Lock()
-		b.known[rUID] = struct{}{}
+		b.markKnown(h, rUID)
 		b.transactAckReceived = true
 		b.knownMutex.Unlock()
 	} else {
@@ -637,8 +875,8 @@ func (b *banking) handle_transactGetBalance(h *handler, msg *com.Message) error
 	// Check if this node was asked; if so, return
 	if targetID == int(h.uid) {
 		b.knownMutex.Lock()
-		resp := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactBalance;<placeholder>;%s;%d", uuid.NewString()[:8], b.balance))
-		b.known[rUID] = struct{}{}
+		resp := com.Msg(h.uid, "BANKING", fmt.Sprintf("transactBalance;<placeholder>;%s;%d", h.randID(), b.balance))
+		b.markKnown(h, rUID)
 		b.knownMutex.Unlock()
 		b.floodWithLamportClock(h, resp)
 	} else {
@@ -677,11 +915,12 @@ func (b *banking) handle_transactBalance(h *handler, msg *com.Message) error {
 			b.balance = b.balance - (b.balance/100)*b.randP
 		}
 		log.Info().Msgf("Updated balance from %d to %d", oldBalance, b.balance)
+		bankingBalance.Set(float64(b.balance))
 		b.transactBalanceReceived = true // Update so the transactLoop can continue
 
 		b.knownMutex.Lock()
 		// Make sure we ignore future messages here
-		b.known[rUID] = struct{}{}
+		b.markKnown(h, rUID)
 		b.knownMutex.Unlock()
 	} else {
 		// Flood until we reach the destination
@@ -709,25 +948,31 @@ func (b *banking) handle_marker(h *handler, msg *com.Message) error {
 		// Mark receiving edge as new
 		b.snapshots[marker].msgInActive[*msg.SourceUID] = false
 		// Send to all outgoing edges
-		for _, connect := range h.neighs.Nodes {
+		for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+			connect := h.neighs.Nodes[nuid]
 			m := com.MsgPropagate(h.uid, msg)
-			if err := com.Send(connect, m); err != nil {
+			if err := h.send(connect, m); err != nil {
 				log.Err(err).Msg("Failed to send marker")
 			}
 		}
 	}
 
-	// check if all receiving channels are closed
+	return b.checkSnapshot(h, marker)
+}
+
+// checkSnapshot forwards (or as coordinator stores) the local snapshot once all receiving channels are closed; channels of
+// dead neighbours never receive a marker
+func (b *banking) checkSnapshot(h *handler, marker string) error {
 	complete := true
-	for _, active := range b.snapshots[marker].msgInActive {
-		complete = complete && !active
+	for uid, active := range b.snapshots[marker].msgInActive {
+		complete = complete && (!active || h.status(uid) == StatusDead)
 	}
 	if complete {
 		if h.uid != b.leader.leaderUID {
 			// Send message to coordinator
 			log.Info().Msg("Snapshot complete, forwarding to coordinator")
 			m := com.Msg(h.uid, "BANKING", fmt.Sprintf("state;%s;%s", marker, b.snapshots[marker].Compress()))
-			return com.Send(h.neighs.Nodes[b.leader.srcUID], m)
+			return h.send(h.neighs.Nodes[b.leader.srcUID], m)
 		} else {
 			// Push to array
 			log.Info().Msg("Snapshot complete (coordinator), storing")
@@ -769,7 +1014,7 @@ func (b *banking) handle_state(h *handler, msg *com.Message) error {
 		//Forward to parent
 		log.Debug().Msg("Forwarding state")
 		m := com.MsgPropagate(h.uid, msg)
-		return com.Send(h.neighs.Nodes[b.leader.srcUID], m)
+		return h.send(h.neighs.Nodes[b.leader.srcUID], m)
 	}
 
 	return nil
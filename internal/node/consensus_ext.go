@@ -5,12 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"math/rand"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/xvzf/vaa/pkg/com"
 )
@@ -81,8 +79,17 @@ type consensus struct {
 	pNeighs  int // number of random neighs to agree on a time
 	aMax     int // voting rounds accepted
 	aCurrent int // voting rounds accepted
+	mValues  int // number of discrete timestamps
 	tK       int // discrete time of this node
 
+	// Coordinator (only used on the leader)
+	awaiting    bool   // awaitElection is scheduled
+	term        int    // incremented on every restart of the election; timers of an older term are stale
+	prevStateID string // double counting state requests
+	currStateID string
+	iterations  int
+	collectID   string
+
 	// State
 	state        *consensusState            // This node state
 	accState     map[string]*consensusState // Accumulated state for state requests
@@ -94,18 +101,16 @@ type consensus struct {
 }
 
 func NewConsensusExtension(s, m, p, aMax int) (Extension, string) {
-	rand.Seed(time.Now().UnixNano())
-	wantLeader := rand.Intn(2) == 1 // 50% chance of being true
-	log.Info().Msgf("Wants to be leader: %t", wantLeader)
 	return &consensus{
-		leader: NewLeader("CONSENSUS", wantLeader),
+		leader: NewLeader("CONSENSUS", false), // wantLeader is drawn in the preflight
 
 		// Echo communication
 		echo: map[string]int{},
 
 		// Discrete timestamp
 		sVote:    s,
-		tK:       rand.Intn(m) + 1,
+		mValues:  m,
+		tK:       0, // drawn in the preflight
 		aMax:     aMax,
 		aCurrent: 0,
 		pNeighs:  p,
@@ -125,17 +130,99 @@ func NewConsensusExtension(s, m, p, aMax int) (Extension, string) {
 }
 
 func (c *consensus) Preflight(ctx context.Context, h *handler) error {
-	go c.leaderLoop(ctx, h)
+	c.leader.wantLeader = h.intn(2) == 1 // 50% chance of being true
+	c.tK = h.intn(c.mValues) + 1
+	log.Info().Msgf("Wants to be leader: %t", c.leader.wantLeader)
+	consensusTK.Set(float64(c.tK))
+
+	c.restore(h)
+
+	c.awaiting = true
+	h.after(50*time.Millisecond, "consensus.election", func() { c.awaitElection(h) })
 	return nil
 }
 
+// consensusDurable is the durable state of a node taking part in the vote; the coordinator state is not persisted
+type consensusDurable struct {
+	TK            int  `json:"t_k"`
+	ACurrent      int  `json:"a_current"`
+	Active        bool `json:"active"`
+	MsgInCounter  int  `json:"msg_in"`
+	MsgOutCounter int  `json:"msg_out"`
+}
+
+// persist writes t_k and the message counters used for double counting
+func (c *consensus) persist(h *handler) {
+	c.state.Lock()
+	s := &consensusDurable{
+		TK:            c.tK,
+		ACurrent:      c.aCurrent,
+		Active:        c.state.active,
+		MsgInCounter:  c.state.msgInCounter,
+		MsgOutCounter: c.state.msgOutCounter,
+	}
+	c.state.Unlock()
+	h.persist("CONSENSUS/state", s)
+}
+
+// restore loads the state of a previous run, the random draws of the preflight are overwritten
+func (c *consensus) restore(h *handler) {
+	c.leader.restore(h)
+
+	s := &consensusDurable{}
+	if !h.restore("CONSENSUS/state", s) {
+		return
+	}
+	c.tK = s.TK
+	c.aCurrent = s.ACurrent
+	c.state.active = s.Active
+	c.state.msgInCounter = s.MsgInCounter
+	c.state.msgOutCounter = s.MsgOutCounter
+	consensusTK.Set(float64(c.tK))
+	log.Info().Msgf("Restored t_k: %d", c.tK)
+}
+
+// NeighbourChanged restarts the election if a failure broke the spanning tree
+func (c *consensus) NeighbourChanged(h *handler, uid uint, status NodeStatus) {
+	if c.leader.neighbourChanged(h, uid, status) {
+		c.restarted(h)
+	}
+}
+
+// MembershipChanged re-elects the leader, the spanning tree is no longer valid
+func (c *consensus) MembershipChanged(h *handler, m *MembershipChange) {
+	c.leader.restart(h)
+	c.restarted(h)
+}
+
+// TopologyChanged re-elects the leader, the spanning tree is no longer valid
+func (c *consensus) TopologyChanged(h *handler, added, removed []uint) {
+	c.leader.restart(h)
+	c.restarted(h)
+}
+
+// restarted stops the timers of a previous coordinator and waits for the new election; its leader starts the vote
+func (c *consensus) restarted(h *handler) {
+	c.term += 1
+	if !c.awaiting {
+		c.awaiting = true
+		h.after(50*time.Millisecond, "consensus.election", func() { c.awaitElection(h) })
+	}
+}
+
 func (c *consensus) Handle(h *handler, msg *com.Message) error {
 	c.echoLock.Lock()
 	defer c.echoLock.Unlock()
 	// Try to handle leader elect message
+	epoch := c.leader.epoch
 	if ok, err := c.leader.TryHandleLeaderMessage(h, msg); ok {
+		if c.leader.epoch != epoch {
+			// Re-election flooded by another node
+			c.restarted(h)
+		}
 		return err
 	}
+	defer c.persist(h)
 	// If not handled, continue with the consensus messages
 	switch payload := *msg.Payload; {
 	// State Request needs to be checked before the response
@@ -160,13 +247,14 @@ func (c *consensus) Handle(h *handler, msg *com.Message) error {
 	return fmt.Errorf("payload `%s` not supported", *msg.Payload)
 }
 
-func randNeighsUnique(in map[uint]string, p int) []string {
+func randNeighsUnique(h *handler, in map[uint]string, p int) []string {
 	n := []string{}
 	r := []string{}
 	randNodes := map[string]struct{}{}
 
-	for _, v := range in {
-		n = append(n, v)
+	// Stable order, otherwise the random draws are not reproducible
+	for _, uid := range sortedUIDs(in) {
+		n = append(n, in[uid])
 	}
 
 	// safety check (doesn't cover all cases)
@@ -177,39 +265,35 @@ func randNeighsUnique(in map[uint]string, p int) []string {
 
 	// Unique random neighs
 	for len(randNodes) < p {
-		randNodes[n[rand.Intn(len(n))]] = struct{}{}
+		randNodes[n[h.intn(len(n))]] = struct{}{}
 	}
 
-	// Convert to array again
-	for v := range randNodes {
-		r = append(n, v)
+	// Convert to array again (stable order)
+	for _, v := range n {
+		if _, ok := randNodes[v]; ok {
+			r = append(r, v)
+		}
 	}
 
 	return r
 }
 
-// leader is the control method
-func (c *consensus) leaderLoop(ctx context.Context, h *handler) error {
-	// Create IDs
-	var prevStateID string = ""
-	var currStateID string = ""
-
-	// Block until this node is leader
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping leader (consensus)")
-			return nil
-		case <-time.After(50 * time.Millisecond):
-		}
-		if c.leader.IsLeader() {
-			break
-		} else if c.leader.ElectionComplete() {
-			log.Warn().Msgf("This node lost the election (consensus), leader: %d", c.leader.leaderUID)
-			return nil
-		}
-	}
+// awaitElection blocks the consensus until the election is complete; the leader starts the voting
+func (c *consensus) awaitElection(h *handler) {
+	if c.leader.IsLeader() {
+		c.awaiting = false
+		c.startVote(h)
+		return
+	} else if c.leader.ElectionComplete() {
+		log.Warn().Msgf("This node lost the election (consensus), leader: %d", c.leader.leaderUID)
+		c.awaiting = false
+		return
+	}
+	h.after(50*time.Millisecond, "consensus.election", func() { c.awaitElection(h) })
+}
 
+// startVote is executed on the leader, it initiates the voting process
+func (c *consensus) startVote(h *handler) {
 	log.Warn().Msg("this node is now leader (consensus)")
 
 	// Select up to S random philosophs (max number of neigh) to initiate the voting process
@@ -218,97 +302,123 @@ func (c *consensus) leaderLoop(ctx context.Context, h *handler) error {
 	}
 
 	m := com.Msg(h.uid, "CONSENSUS", "voteBegin")
-	for _, connect := range randNeighsUnique(h.neighs.Nodes, c.sVote) {
+	for _, connect := range randNeighsUnique(h, h.neighs.Nodes, c.sVote) {
 		log.Info().Msgf("Send voteBegin to %s", connect)
 
-		if err := com.Send(connect, m); err != nil {
+		if err := h.send(connect, m); err != nil {
 			log.Err(err).Msg("Failed to send voteBegin message")
 		} else {
 			c.state.Sent()
 		}
 	}
 
+	c.persist(h)
+
 	// Perform Double Counting until the two reported, consecutive states match
-	for {
-
-		// Some sleeps between the interval
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping leader")
-			return nil
-		case <-time.After(1 * time.Second):
-		}
+	term := c.term
+	c.prevStateID, c.currStateID, c.iterations = "", "", 0
+	h.after(1*time.Second, "consensus.doubleCounting", func() { c.doubleCounting(h, term) })
+}
+
+// doubleCounting regularly collects the state until two consecutive states match
+func (c *consensus) doubleCounting(h *handler, term int) {
+	if term != c.term {
+		log.Info().Msg("Election restarted, stopping double counting")
+		return
+	}
+	// Check state; updated by receiving node process
+	c.echoLock.Lock()
+	_, okPrev := c.accStateDone[c.prevStateID]
+	_, okCurr := c.accStateDone[c.currStateID]
+	statePrev := c.accState[c.prevStateID]
+	stateCurr := c.accState[c.currStateID]
+	c.echoLock.Unlock()
+
+	if c.currStateID != "" && !okCurr {
+		// Wait for state to be reported
+		log.Info().Msgf("Waiting for state to come in; id %s", c.currStateID)
+	} else if okPrev && okCurr && statePrev.msgInCounter == statePrev.msgOutCounter && stateCurr.msgInCounter == stateCurr.msgOutCounter {
+		// Compare current and last state in case they both exist
+		log.Info().Msgf("State Converged after %d iterations", c.iterations)
+		consensusDoubleCountingIterations.Set(float64(c.iterations))
+		c.startCollect(h, term)
+		return
+	} else {
+		// First iteration or the state received; rotate
+		c.prevStateID = c.currStateID
+		c.currStateID = h.randID()
+		c.iterations = c.iterations + 1
+		log.Info().Msgf("double counting mismatch; starting state collection with id %s", c.currStateID)
 
-		// Check state; updated by receiving node process
 		c.echoLock.Lock()
-		_, okPrev := c.accStateDone[prevStateID]
-		_, okCurr := c.accStateDone[currStateID]
-		statePrev := c.accState[prevStateID]
-		stateCurr := c.accState[currStateID]
+		c.echo[c.currStateID] = 0
+		c.accState[c.currStateID] = &consensusState{active: false, msgInCounter: 0, msgOutCounter: 0}
 		c.echoLock.Unlock()
-
-		if currStateID != "" && !okCurr {
-			// Wait for state to be reported
-			log.Info().Msgf("Waiting for state to come in; id %s", currStateID)
-			continue
-		} else {
-			// First iteration or the state received
-
-			// Compare current and last state in case they both exist
-			if okPrev && okCurr && statePrev.msgInCounter == statePrev.msgOutCounter && stateCurr.msgInCounter == stateCurr.msgOutCounter {
-				log.Info().Msg("State Converged")
-				break
-			} else {
-				// rotate
-				prevStateID = currStateID
-				currStateID = uuid.NewString()[0:8]
-				log.Info().Msgf("double counting mismatch; starting state collection with id %s", currStateID)
-
-				c.echoLock.Lock()
-				c.echo[currStateID] = 0
-				c.accState[currStateID] = &consensusState{active: false, msgInCounter: 0, msgOutCounter: 0}
-				c.echoLock.Unlock()
-				m := com.Msg(h.uid, "CONSENSUS", "stateRequest;"+currStateID)
-				_ = c.leader.PropagateChilds(h, m)
-			}
-		}
+		m := com.Msg(h.uid, "CONSENSUS", "stateRequest;"+c.currStateID)
+		_ = c.leader.PropagateChilds(h, m)
 	}
 
-	// Collect results
-	collectID := uuid.NewString()[0:8]
-	mCollect := com.Msg(h.uid, "CONSENSUS", "collectRequest;"+collectID)
-	c.echo[collectID] = 0
-	c.accResult[collectID] = &resultState{agreement: true, timestamp: -1}
+	// Some sleeps between the interval
+	h.after(1*time.Second, "consensus.doubleCounting", func() { c.doubleCounting(h, term) })
+}
+
+// startCollect collects the results after the voting terminated
+func (c *consensus) startCollect(h *handler, term int) {
+	c.collectID = h.randID()
+	mCollect := com.Msg(h.uid, "CONSENSUS", "collectRequest;"+c.collectID)
+	c.echoLock.Lock()
+	c.echo[c.collectID] = 0
+	c.accResult[c.collectID] = &resultState{agreement: true, timestamp: -1}
+	c.echoLock.Unlock()
 	_ = c.leader.PropagateChilds(h, mCollect)
-	for {
-
-		// Some sleeps between the interval
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Stopping leader")
-			return nil
-		case <-time.After(1 * time.Second):
-		}
 
-		c.echoLock.Lock()
-		_, ok := c.accResultDone[collectID]
-		if ok {
-			if res, ok := c.accResult[collectID]; ok {
-				log.Info().Msgf("Agreement: %t, (timestamp: %d)", res.agreement, res.timestamp)
-				c.echoLock.Unlock()
-			} else {
-				err := fmt.Errorf("result not available, internal error %s", collectID)
-				log.Err(err).Msg("invalid state")
-				c.echoLock.Unlock()
-				return err
-			}
-			// We're done here, get out
-			break
-		}
+	h.after(1*time.Second, "consensus.collect", func() { c.awaitCollect(h, term) })
+}
+
+// Inspect exposes the discrete time of this node and, on the leader, the collected result
+func (c *consensus) Inspect() map[string]interface{} {
+	c.echoLock.Lock()
+	defer c.echoLock.Unlock()
+	s := c.leader.inspect()
+	s["t_k"] = c.tK
+	s["voting_rounds"] = c.aCurrent
+	if res, ok := c.accResult[c.collectID]; ok && c.accResultDone[c.collectID] {
+		s["collect_done"] = true
+		s["agreement"] = res.agreement
+		s["collected_t_k"] = res.timestamp
+	}
+	return s
+}
+
+// awaitCollect waits for the collected result
+func (c *consensus) awaitCollect(h *handler, term int) {
+	c.echoLock.Lock()
+	defer c.echoLock.Unlock()
+
+	if term != c.term {
+		log.Info().Msg("Election restarted, stopping collect")
+		return
+	}
+	if _, ok := c.accResultDone[c.collectID]; !ok {
 		log.Info().Msg("Consensus leader waiting for collect result")
+		h.after(1*time.Second, "consensus.collect", func() { c.awaitCollect(h, term) })
+		return
+	}
+
+	res, ok := c.accResult[c.collectID]
+	if !ok {
+		err := fmt.Errorf("result not available, internal error %s", c.collectID)
+		log.Err(err).Msg("invalid state")
+		return
 	}
+	log.Info().Msgf("Agreement: %t, (timestamp: %d)", res.agreement, res.timestamp)
+	if res.agreement {
+		consensusAgreement.Set(1)
+	} else {
+		consensusAgreement.Set(0)
+	}
+	h.emit(EventConsensusResult, map[string]interface{}{"agreement": res.agreement, "t_k": res.timestamp})
 	log.Warn().Msg("Consensus Leader exited")
-	return nil
 }
 
 func (c *consensus) sendProposals(h *handler) {
@@ -321,10 +431,10 @@ func (c *consensus) sendProposals(h *handler) {
 	m := com.Msg(h.uid, "CONSENSUS", fmt.Sprintf("proposal;%d", c.tK))
 
 	// Send requests
-	for _, connect := range randNeighsUnique(h.neighs.Nodes, c.pNeighs) {
+	for _, connect := range randNeighsUnique(h, h.neighs.Nodes, c.pNeighs) {
 		// Sleep random time to avoid connection timeouts
 		// time.Sleep(time.Duration(rand.Intn(200)) * time.Millisecond)
-		if err := com.Send(connect, m); err != nil {
+		if err := h.send(connect, m); err != nil {
 			log.Err(err).Msgf("Sent proposal to %s", connect)
 		} else {
 			c.state.Sent()
@@ -347,9 +457,11 @@ func (c *consensus) handle_proposal(h *handler, msg *com.Message) error {
 
 	if c.aCurrent >= c.aMax {
 		log.Info().Msg("this node is not accepting further proposals")
+		consensusProposalsTotal.WithLabelValues("rejected").Inc()
 		return nil
 	}
 	c.aCurrent = c.aCurrent + 1
+	consensusProposalsTotal.WithLabelValues("accepted").Inc()
 
 	proposedTime, err := nthInt(*msg.Payload, 1)
 	if err != nil {
@@ -360,11 +472,12 @@ func (c *consensus) handle_proposal(h *handler, msg *com.Message) error {
 	newT := int(math.Ceil((float64(proposedTime) + float64(c.tK)) / 2))
 	log.Info().Msgf("New t_k = %d; (old = %d)", newT, c.tK)
 	c.tK = newT
+	consensusTK.Set(float64(c.tK))
 
 	// Send response
 	log.Info().Msgf("Sending proposalResponse to uid %d", *msg.SourceUID)
 	m := com.Msg(h.uid, "CONSENSUS", fmt.Sprintf("proposalResponse;%d", c.tK))
-	if err := com.Send(h.neighs.Nodes[*msg.SourceUID], m); err != nil {
+	if err := h.send(h.neighs.Nodes[*msg.SourceUID], m); err != nil {
 		log.Err(err).Msg("Failed to send proposalResponse message")
 	} else {
 		c.state.Sent()
@@ -387,6 +500,7 @@ func (c *consensus) handle_proposalResponse(h *handler, msg *com.Message) error
 
 	log.Info().Msgf("Accepted agreed t_k = %d; (old = %d)", agreedTime, c.tK)
 	c.tK = agreedTime
+	consensusTK.Set(float64(c.tK))
 
 	return nil
 }
@@ -415,7 +529,7 @@ func (c *consensus) resultReturn(h *handler, rUID string) {
 		} else {
 			sMsg := com.Msg(h.uid, "CONSENSUS", fmt.Sprintf("collect;%s;%t;%d", rUID, resultState.agreement, resultState.timestamp))
 			log.Info().Msgf("Propagate (accumulated) result to %d", c.leader.srcUID)
-			com.Send(h.neighs.Nodes[c.leader.srcUID], sMsg)
+			h.send(h.neighs.Nodes[c.leader.srcUID], sMsg)
 		}
 
 		/*
@@ -513,7 +627,7 @@ func (c *consensus) stateReturn(h *handler, sUID string) {
 		} else {
 			sMsg := com.Msg(h.uid, "CONSENSUS", fmt.Sprintf("stateResponse;%s;%t;%d;%d", sUID, accState.active, accState.msgInCounter, accState.msgOutCounter))
 			log.Info().Msgf("Propagate (accumulated) state to %d", c.leader.srcUID)
-			com.Send(h.neighs.Nodes[c.leader.srcUID], sMsg)
+			h.send(h.neighs.Nodes[c.leader.srcUID], sMsg)
 		}
 
 		/*
@@ -40,6 +40,15 @@ func (c *control) Handle(h *handler, msg *com.Message) error {
 		log.Debug().Uint("uid", h.uid).Str("req_id", *msg.UUID).Msgf("Disstribute messages")
 		c.handleControl_distribute(h, msg)
 		return nil
+	// Re-read config + graph and apply the changed neighbours
+	case payload == "RELOAD":
+		return h.reload()
+	// Consistent snapshot for invariant checks
+	case strings.HasPrefix(payload, "SNAPSHOT"), strings.HasPrefix(payload, "MARKER"):
+		return c.handleControl_marker(h, msg)
+	// Event stream for clients
+	case strings.HasPrefix(payload, "WATCH"), strings.HasPrefix(payload, "UNWATCH"):
+		return c.handleControl_watch(h, msg)
 	}
 
 	return nil
@@ -54,9 +63,10 @@ func (c *control) handleControl_startup(h *handler, msg *com.Message) error {
 	c.started = true
 
 	// Send HELLO to all neighbors
-	for nuid, netaddr := range h.neighs.Nodes {
+	for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+		netaddr := h.neighs.Nodes[nuid]
 		log.Debug().Uint("uid", h.uid).Msgf("Sending HELLO to %d", nuid)
-		if err := com.Send(netaddr, helloMsg); err != nil {
+		if err := h.send(netaddr, helloMsg); err != nil {
 			log.Err(err).Uint("uid", h.uid).Msgf("Failed sending HELLO to %d", nuid)
 		}
 	}
@@ -75,11 +85,35 @@ func (c *control) handleControl_distribute(h *handler, msg *com.Message) error {
 
 	toSend := com.Msg(h.uid, t, p)
 
-	for nuid, netaddr := range h.neighs.Nodes {
-		if err := com.Send(netaddr, toSend); err != nil {
+	for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+		netaddr := h.neighs.Nodes[nuid]
+		if err := h.send(netaddr, toSend); err != nil {
 			log.Err(err).Uint("uid", h.uid).Msgf("Failed sending %s to %d", t, nuid)
 		}
 	}
 
 	return nil
 }
+
+// handleControl_marker takes part in a consistent snapshot; `SNAPSHOT <id> <collector>` is sent by the collector, `MARKER <id> <collector>` by neighbours
+func (c *control) handleControl_marker(h *handler, msg *com.Message) error {
+	ps := strings.Split(*msg.Payload, " ")
+	if len(ps) != 3 {
+		return errors.New("payload invalid")
+	}
+	src := *msg.SourceUID
+	if ps[0] == "SNAPSHOT" {
+		src = 0 // initiated by the collector
+	}
+	return h.marker(ps[1], ps[2], src)
+}
+
+// handleControl_watch registers (`WATCH <addr>`) or removes (`UNWATCH <addr>`) an address receiving the events of this node
+func (c *control) handleControl_watch(h *handler, msg *com.Message) error {
+	ps := strings.Split(*msg.Payload, " ")
+	if len(ps) != 2 {
+		return errors.New("payload invalid")
+	}
+	h.watch(ps[1], ps[0] == "UNWATCH")
+	return nil
+}
@@ -0,0 +1,184 @@
+package node
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// env abstracts all side effects of a node (transport, timers, randomness); this allows recording and replaying executions
+type env interface {
+	send(target string, msg *com.Message) error
+	after(d time.Duration, name string, f func()) // f is executed on the node loop
+	intn(n int) int
+	now() time.Time
+}
+
+// timer is a scheduled callback, executed on the node loop
+type timer struct {
+	name string
+	f    func()
+}
+
+// netEnv is the default environment; TCP transport and wall-clock timers
+type netEnv struct {
+	h      *handler
+	rand   *rand.Rand
+	delays map[string]chan *delayed // queued messages (delayed links, asynchronous sends), by target
+}
+
+// delayed is a message waiting for the emulated link delay
+type delayed struct {
+	at  time.Time
+	msg *com.Message
+}
+
+func newNetEnv(h *handler) *netEnv {
+	return &netEnv{
+		h:      h,
+		rand:   rand.New(rand.NewSource(time.Now().UTC().UnixNano())),
+		delays: map[string]chan *delayed{},
+	}
+}
+
+func (e *netEnv) send(target string, msg *com.Message) error {
+	for nuid, addr := range e.h.neighs.Nodes {
+		if d := e.h.neighs.Link(nuid).Delay(len(*msg.Payload)); addr == target && d > 0 {
+			return e.delay(target, d, msg)
+		}
+	}
+	return com.Send(target, msg)
+}
+
+// delay sends a message after d
+func (e *netEnv) delay(target string, d time.Duration, msg *com.Message) error {
+	cp := *msg // extensions reuse messages for multiple targets
+	e.queue(target) <- &delayed{at: time.Now().Add(d), msg: &cp}
+	return nil
+}
+
+// sendAsync queues a message without blocking the node loop, e.g. on an unreachable target; dropped if the queue is full
+func (e *netEnv) sendAsync(target string, msg *com.Message) {
+	d := time.Duration(0)
+	for nuid, addr := range e.h.neighs.Nodes {
+		if addr == target {
+			d = e.h.neighs.Link(nuid).Delay(len(*msg.Payload))
+		}
+	}
+	cp := *msg
+	select {
+	case e.queue(target) <- &delayed{at: time.Now().Add(d), msg: &cp}:
+	default:
+		log.Warn().Uint("uid", e.h.uid).Msgf("Queue to %s full, dropping %s message", target, *msg.Type)
+	}
+}
+
+// queue returns the outgoing queue of a target; one queue per link keeps the channel FIFO
+func (e *netEnv) queue(target string) chan *delayed {
+	q, ok := e.delays[target]
+	if !ok {
+		q = make(chan *delayed, 1024)
+		e.delays[target] = q
+		go func() {
+			for {
+				select {
+				case m := <-q:
+					time.Sleep(time.Until(m.at))
+					if err := com.Send(target, m.msg); err != nil {
+						log.Debug().Err(err).Uint("uid", e.h.uid).Msgf("Failed sending queued %s message to %s", *m.msg.Type, target)
+					}
+				case <-e.h.done:
+					return
+				}
+			}
+		}()
+	}
+	return q
+}
+
+func (e *netEnv) after(d time.Duration, name string, f func()) {
+	time.AfterFunc(d, func() {
+		select {
+		case e.h.timers <- &timer{name: name, f: f}:
+		case <-e.h.done:
+			// node stopped, drop the timer
+		}
+	})
+}
+
+func (e *netEnv) intn(n int) int {
+	return e.rand.Intn(n)
+}
+
+func (e *netEnv) now() time.Time {
+	return time.Now()
+}
+
+// outgoing is a message held back until the state of a durable node is synced
+type outgoing struct {
+	target string
+	msg    *com.Message
+	event  bool // delivered to a watcher, see emit
+}
+
+// send transmits a message through the environment of the node; durable nodes send after syncing the state (see hold).
+// A held message is only sent once the input is handled, send returns nil then and a failure is only logged by commit;
+// callers counting successful sends (e.g. expected replies) treat it like a message lost in transit
+func (h *handler) send(target string, msg *com.Message) error {
+	messagesTotal.WithLabelValues(*msg.Type, "out").Inc()
+	if h.holding {
+		h.held = append(h.held, &outgoing{target: target, msg: msg})
+		return nil
+	}
+	return h.env.send(target, msg)
+}
+
+// asyncEnv is implemented by environments whose sends may block the node loop
+type asyncEnv interface {
+	sendAsync(target string, msg *com.Message)
+}
+
+// sendAsync transmits a message that must not block the node loop (heartbeats, events); errors are only logged
+func (h *handler) sendAsync(target string, msg *com.Message) {
+	messagesTotal.WithLabelValues(*msg.Type, "out").Inc()
+	if e, ok := h.env.(asyncEnv); ok {
+		e.sendAsync(target, msg)
+		return
+	}
+	if err := h.env.send(target, msg); err != nil {
+		log.Debug().Err(err).Uint("uid", h.uid).Msgf("Failed sending %s message to %s", *msg.Type, target)
+	}
+}
+
+// after schedules f on the node loop; the name identifies the timer in recorded traces
+func (h *handler) after(d time.Duration, name string, f func()) {
+	h.env.after(d, name, f)
+}
+
+// intn returns a random number in [0,n); draws are recorded
+func (h *handler) intn(n int) int {
+	v := h.env.intn(n)
+	if h.rec != nil {
+		h.rec.rand(n, v)
+	}
+	return v
+}
+
+// now returns the current time of the environment; readings are recorded, without the monotonic clock reading that is
+// not part of the trace, so the replay computes the same durations
+func (h *handler) now() time.Time {
+	t := h.env.now()
+	if h.rec != nil {
+		t = t.Round(0)
+		h.rec.now(t)
+	}
+	return t
+}
+
+// randID generates a short random identifier (e.g. for markers or state requests)
+func (h *handler) randID() string {
+	return fmt.Sprintf("%08x", h.intn(1<<31-1))
+}
@@ -0,0 +1,369 @@
+package node
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// election is an extension only running the leader election; used to explore its interleavings
+type election struct {
+	leader *Leader
+}
+
+func newElection(msgType string, wantLeader bool) *election {
+	return &election{leader: NewLeader(msgType, wantLeader)}
+}
+
+func (e *election) Preflight(ctx context.Context, h *handler) error {
+	// not required
+	return nil
+}
+
+func (e *election) Handle(h *handler, msg *com.Message) error {
+	if ok, err := e.leader.TryHandleLeaderMessage(h, msg); ok {
+		return err
+	}
+	return fmt.Errorf("payload `%s` not supported", *msg.Payload)
+}
+
+func (e *election) Inspect() map[string]interface{} {
+	return e.leader.inspect()
+}
+
+// clone copies the election state
+func (l *Leader) clone() *Leader {
+	return &Leader{
+		messageType:       l.messageType,
+		wantLeader:        l.wantLeader,
+		isLeader:          l.isLeader,
+		m:                 l.m,
+		leaderUID:         l.leaderUID,
+		depth:             l.depth,
+		electionStart:     l.electionStart,
+		childUIDs:         append([]uint{}, l.childUIDs...),
+		receivedParentMsg: l.receivedParentMsg,
+		receivedExplore:   l.receivedExplore,
+		sentExplore:       l.sentExplore,
+		receivedEcho:      l.receivedEcho,
+		srcUID:            l.srcUID,
+		echoed:            l.echoed,
+	}
+}
+
+// fingerprint adds the election state to a hash; the order of the childs is irrelevant
+func (l *Leader) fingerprint(h hash.Hash64) {
+	childs := append([]uint{}, l.childUIDs...)
+	sort.Slice(childs, func(i, j int) bool { return childs[i] < childs[j] })
+	leader, echoed := 0, 0
+	if l.isLeader {
+		leader = 1
+	}
+	if l.echoed {
+		echoed = 1
+	}
+	writeInts(h, leader, echoed, l.m, int(l.leaderUID), l.depth, l.receivedParentMsg, l.receivedExplore, l.sentExplore, l.receivedEcho, int(l.srcUID), len(childs))
+	for _, c := range childs {
+		writeInts(h, int(c))
+	}
+}
+
+func writeInts(h hash.Hash64, vs ...int) {
+	var b [8]byte
+	for _, v := range vs {
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		h.Write(b[:])
+	}
+}
+
+// exploreMsg is a message in flight
+type exploreMsg struct {
+	from uint
+	to   uint
+	msg  *com.Message
+}
+
+func (m *exploreMsg) String() string {
+	return fmt.Sprintf("%d -> %d: %s %s", m.from, m.to, *m.msg.Type, *m.msg.Payload)
+}
+
+// exploreWorld is the global state: all nodes plus the messages in flight
+type exploreWorld struct {
+	msgType  string
+	neighs   map[uint]*neigh.Neighs
+	addrs    map[string]uint
+	elect    map[uint]*election
+	inflight []*exploreMsg
+	errs     []string
+}
+
+// exploreEnv captures sends of a node into the world
+type exploreEnv struct {
+	w   *exploreWorld
+	uid uint
+}
+
+func (e *exploreEnv) send(target string, msg *com.Message) error {
+	uid, ok := e.w.addrs[target]
+	if !ok {
+		return fmt.Errorf("unknown target %s", target)
+	}
+	id := fmt.Sprintf("%d-%d", e.uid, len(e.w.inflight))
+	cp := &com.Message{
+		UUID:      &id,
+		Timestamp: msg.Timestamp,
+		SourceUID: com.UintPointer(*msg.SourceUID),
+		Type:      com.StrPointer(*msg.Type),
+		Payload:   com.StrPointer(*msg.Payload),
+	}
+	e.w.inflight = append(e.w.inflight, &exploreMsg{from: e.uid, to: uid, msg: cp})
+	return nil
+}
+
+func (e *exploreEnv) after(d time.Duration, name string, f func()) {
+	// Timers are not part of the exploration
+}
+
+func (e *exploreEnv) intn(n int) int {
+	return 0
+}
+
+func (e *exploreEnv) now() time.Time {
+	return time.Time{}
+}
+
+// clone copies the world; the election states are shared until a node receives a message (copy on write)
+func (w *exploreWorld) clone() *exploreWorld {
+	c := &exploreWorld{
+		msgType:  w.msgType,
+		neighs:   w.neighs,
+		addrs:    w.addrs,
+		elect:    make(map[uint]*election, len(w.elect)),
+		inflight: append([]*exploreMsg{}, w.inflight...),
+		errs:     w.errs,
+	}
+	for uid, e := range w.elect {
+		c.elect[uid] = e
+	}
+	return c
+}
+
+// deliverable returns the indices of the messages that can be delivered next
+func (w *exploreWorld) deliverable(fifo bool) []int {
+	idx := []int{}
+	seen := map[[2]uint]bool{}
+	for i, m := range w.inflight {
+		link := [2]uint{m.from, m.to}
+		if fifo && seen[link] {
+			continue // only the head of each channel
+		}
+		seen[link] = true
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// deliver removes message i from the channels and hands it to the receiving node
+func (w *exploreWorld) deliver(i int) *exploreMsg {
+	m := w.inflight[i]
+	w.inflight = append(w.inflight[:i:i], w.inflight[i+1:]...)
+
+	e := &election{leader: w.elect[m.to].leader.clone()}
+	w.elect[m.to] = e
+	h := &handler{uid: m.to, neighs: w.neighs[m.to], ext: map[string]Extension{w.msgType: e}}
+	h.exit = func() {}
+	h.env = &exploreEnv{w: w, uid: m.to}
+	if err := h.handle(m.msg); err != nil {
+		w.errs = append(w.errs[:len(w.errs):len(w.errs)], fmt.Sprintf("node %d failed handling `%s`: %s", m.to, *m.msg.Payload, err))
+	}
+	return m
+}
+
+// fingerprint identifies the global state; hash collisions are possible but negligible for the explored sizes
+func (w *exploreWorld) fingerprint(fifo bool) uint64 {
+	h := fnv.New64a()
+	for _, uid := range w.uids() {
+		w.elect[uid].leader.fingerprint(h)
+	}
+	msgs := append([]*exploreMsg{}, w.inflight...)
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if msgs[i].from != msgs[j].from || msgs[i].to != msgs[j].to {
+			return msgs[i].from < msgs[j].from || (msgs[i].from == msgs[j].from && msgs[i].to < msgs[j].to)
+		}
+		// The order within a channel only matters for FIFO channels, otherwise they are bags
+		return !fifo && *msgs[i].msg.Payload < *msgs[j].msg.Payload
+	})
+	for _, m := range msgs {
+		writeInts(h, int(m.from), int(m.to))
+		h.Write([]byte(*m.msg.Payload))
+		h.Write([]byte{0})
+	}
+	for _, e := range w.errs {
+		h.Write([]byte(e))
+	}
+	return h.Sum64()
+}
+
+// states inspects all nodes
+func (w *exploreWorld) states() map[uint]*NodeState {
+	states := map[uint]*NodeState{}
+	for uid, e := range w.elect {
+		states[uid] = &NodeState{UID: uid, Extensions: map[string]map[string]interface{}{w.msgType: e.Inspect()}}
+	}
+	return states
+}
+
+func (w *exploreWorld) uids() []uint {
+	uids := []uint{}
+	for uid := range w.elect {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// exploreInvariant is checked on every explored state (or terminal states only); in addition to the global invariants of the election
+type exploreInvariant struct {
+	name     string
+	terminal bool
+	check    func(w *exploreWorld, initiators []uint) error
+}
+
+var exploreInvariants = []exploreInvariant{
+	{"no handler errors", false, func(w *exploreWorld, _ []uint) error {
+		if len(w.errs) > 0 {
+			return errors.New(w.errs[0])
+		}
+		return nil
+	}},
+	{"leader is an initiator", true, func(w *exploreWorld, initiators []uint) error {
+		// The largest initiator does not necessarily win; its coordinator message may arrive after its echo was sent
+		for _, uid := range w.uids() {
+			l := w.elect[uid].leader
+			valid := l.leaderUID == 0 // covered by the global invariants
+			for _, i := range initiators {
+				valid = valid || l.leaderUID == i
+			}
+			if !valid {
+				return fmt.Errorf("node %d elected %d, which is no initiator", uid, l.leaderUID)
+			}
+		}
+		return nil
+	}},
+}
+
+// ExploreResult summarises an exhaustive exploration
+type ExploreResult struct {
+	States    int          `json:"states"`
+	Terminal  int          `json:"terminal"`
+	Truncated bool         `json:"truncated"`
+	Invariant string       `json:"invariant,omitempty"` // violated invariant
+	Violation string       `json:"violation,omitempty"`
+	Nodes     []*NodeState `json:"nodes,omitempty"` // states of the nodes participating in the violation
+	Trace     []string     `json:"trace,omitempty"` // minimal delivery sequence leading to the violation
+}
+
+// exploreNode is a node of the breadth first search; the world is dropped once expanded
+type exploreNode struct {
+	w      *exploreWorld
+	parent *exploreNode
+	step   string
+}
+
+func (n *exploreNode) trace() []string {
+	t := []string{}
+	for cur := n; cur.parent != nil; cur = cur.parent {
+		t = append([]string{cur.step}, t...)
+	}
+	return t
+}
+
+// ExploreElection explores every message delivery order of the leader election on a graph; the initiators receive `coordinator`.
+// The search is breadth first, the first violation therefore comes with a minimal trace. fifo restricts the delivery to channel heads.
+func ExploreElection(nm *neigh.NeighMap, initiators []uint, fifo bool, maxStates int) *ExploreResult {
+	const msgType = "ELECTION"
+
+	// Nodes only identify by their connect string
+	c := &neigh.Config{Nodes: map[uint]string{}}
+	for a, v := range nm.Neighs {
+		c.Nodes[a] = fmt.Sprintf("explore:%d", a)
+		for _, b := range v {
+			c.Nodes[b] = fmt.Sprintf("explore:%d", b)
+		}
+	}
+	w := &exploreWorld{msgType: msgType, neighs: map[uint]*neigh.Neighs{}, addrs: map[string]uint{}, elect: map[uint]*election{}}
+	want := map[uint]bool{}
+	for _, uid := range initiators {
+		want[uid] = true
+	}
+	for uid, addr := range c.Nodes {
+		w.addrs[addr] = uid
+		w.neighs[uid] = neigh.NeighsFor(uid, c, nm)
+		w.elect[uid] = newElection(msgType, want[uid])
+	}
+	for _, uid := range initiators {
+		w.inflight = append(w.inflight, &exploreMsg{from: 0, to: uid, msg: com.Msg(0, msgType, "coordinator")})
+		w.inflight[len(w.inflight)-1].msg.UUID = com.StrPointer(fmt.Sprintf("coordinator-%d", uid))
+	}
+
+	global := []*Invariant{SingleLeader(msgType), LeaderElected(msgType)}
+	res := &ExploreResult{}
+	visited := map[uint64]bool{w.fingerprint(fifo): true}
+	queue := []*exploreNode{{w: w}}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		res.States = res.States + 1
+
+		next := n.w.deliverable(fifo)
+		for _, inv := range exploreInvariants {
+			if inv.terminal && len(next) > 0 {
+				continue
+			}
+			if err := inv.check(n.w, initiators); err != nil {
+				res.Invariant = inv.name
+				res.Violation = err.Error()
+				res.Trace = n.trace()
+				return res
+			}
+		}
+		if v := CheckInvariants(n.w.states(), global, len(next) == 0); len(v) > 0 {
+			res.Invariant = v[0].Invariant
+			res.Violation = v[0].Error
+			res.Nodes = v[0].States
+			res.Trace = n.trace()
+			return res
+		}
+		if len(next) == 0 {
+			res.Terminal = res.Terminal + 1
+			continue
+		}
+		if maxStates > 0 && res.States >= maxStates {
+			res.Truncated = true
+			return res
+		}
+
+		for _, i := range next {
+			c := n.w.clone()
+			m := c.deliver(i)
+			fp := c.fingerprint(fifo)
+			if visited[fp] {
+				continue
+			}
+			visited[fp] = true
+			queue = append(queue, &exploreNode{w: c, parent: n, step: m.String()})
+		}
+		n.w = nil
+	}
+
+	return res
+}
@@ -0,0 +1,43 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func TestExploreElection(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	defer zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	triangle := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2, 3}, 2: {3}}}
+	ring := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}, 3: {4}, 4: {1}}}
+
+	tests := []struct {
+		name       string
+		nm         *neigh.NeighMap
+		initiators []uint
+		fifo       bool
+		wantErr    bool
+	}{
+		{"Triangle, all initiators", triangle, []uint{1, 2, 3}, true, false},
+		{"Triangle, single initiator", triangle, []uint{1}, true, false},
+		{"Ring, two initiators", ring, []uint{2, 4}, true, false},
+		{"Triangle, non FIFO channels", triangle, []uint{1, 2, 3}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ExploreElection(tt.nm, tt.initiators, tt.fifo, 0)
+			assert.False(t, res.Truncated, "exploration is exhaustive")
+			if tt.wantErr {
+				assert.NotEmpty(t, res.Violation, "violation is found")
+				assert.NotEmpty(t, res.Trace, "counterexample is reported")
+			} else {
+				assert.Empty(t, res.Violation, res.Trace)
+				assert.Greater(t, res.Terminal, 0, "election terminates")
+			}
+		})
+	}
+}
@@ -9,6 +9,6 @@ import (
 type Extension interface {
 	// Hanlde handles messages of a specific type
 	Handle(h *handler, msg *com.Message) error
-	// Preflight initialised additional goroutines/communication paths
+	// Preflight initialised additional communication paths; periodic work is scheduled with h.after so it runs on the node loop
 	Preflight(ctx context.Context, h *handler) error
 }
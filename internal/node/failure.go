@@ -0,0 +1,169 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// NodeStatus is the state of a neighbour as seen by the failure detector
+type NodeStatus string
+
+const (
+	StatusAlive     NodeStatus = "alive"
+	StatusSuspected NodeStatus = "suspected"
+	StatusDead      NodeStatus = "dead"
+)
+
+// statusValue is the metric representation of a status
+var statusValue = map[NodeStatus]float64{StatusAlive: 0, StatusSuspected: 1, StatusDead: 2}
+
+// FailureListener is implemented by extensions reacting to status changes of neighbours
+type FailureListener interface {
+	// NeighbourChanged is called on the node loop whenever the status of a neighbour changes
+	NeighbourChanged(h *handler, uid uint, status NodeStatus)
+}
+
+// failureDetector exchanges heartbeats with all neighbours; a neighbour is suspected after suspectAfter and dead after deadAfter without a heartbeat.
+// In a directed graph heartbeats are sent on both directions of a channel, but only in-neighbours are judged: the node
+// receives their messages, an out-neighbour is judged by the nodes it sends to
+type failureDetector struct {
+	interval     time.Duration
+	suspectAfter time.Duration
+	deadAfter    time.Duration
+
+	lastSeen map[uint]time.Time
+	status   map[uint]NodeStatus
+}
+
+func (h *handler) DetectFailures(interval, suspectAfter, deadAfter time.Duration) {
+	log.Info().Uint("uid", h.uid).Msgf("Failure detector: heartbeat every %s, suspect after %s, dead after %s", interval, suspectAfter, deadAfter)
+	h.fd = &failureDetector{
+		interval:     interval,
+		suspectAfter: suspectAfter,
+		deadAfter:    deadAfter,
+		lastSeen:     map[uint]time.Time{},
+		status:       map[uint]NodeStatus{},
+	}
+}
+
+// start assumes all in-neighbours alive and schedules the heartbeats; called after the preflights
+func (fd *failureDetector) start(h *handler) {
+	now := h.now()
+	for nuid := range h.neighs.Incoming() {
+		fd.lastSeen[nuid] = now
+		fd.status[nuid] = StatusAlive
+	}
+	fd.tick(h)
+}
+
+// tick sends heartbeats and updates the status of silent neighbours
+func (fd *failureDetector) tick(h *handler) {
+	peers := fd.peers(h)
+	for _, nuid := range sortedUIDs(peers) {
+		// Dead neighbours receive heartbeats as well, otherwise two nodes considering each other dead never recover;
+		// sent asynchronously, a dead neighbour would block the node loop until the connect timeout otherwise
+		h.sendAsync(peers[nuid], com.Msg(h.uid, "HEARTBEAT", "ping"))
+	}
+
+	now := h.now()
+	for _, nuid := range fd.uids() {
+		switch silent := now.Sub(fd.lastSeen[nuid]); {
+		case silent >= fd.deadAfter:
+			fd.update(h, nuid, StatusDead)
+		case silent >= fd.suspectAfter:
+			fd.update(h, nuid, StatusSuspected)
+		}
+	}
+
+	h.after(fd.interval, "failure.heartbeat", func() { fd.tick(h) })
+}
+
+// heartbeat marks the sender alive; nodes that are not a neighbour (yet) are tracked as well, out-neighbours are not
+func (fd *failureDetector) heartbeat(h *handler, uid uint) {
+	if _, in := h.neighs.Incoming()[uid]; !in {
+		if _, out := h.neighs.Nodes[uid]; out {
+			return
+		}
+	}
+	if _, ok := fd.status[uid]; !ok {
+		fd.status[uid] = StatusAlive
+	}
+	fd.lastSeen[uid] = h.now()
+	fd.update(h, uid, StatusAlive)
+}
+
+// update changes the status of a neighbour and notifies the extensions
+func (fd *failureDetector) update(h *handler, uid uint, status NodeStatus) {
+	old := fd.status[uid]
+	if old == status {
+		return
+	}
+	fd.status[uid] = status
+	log.Warn().Uint("uid", h.uid).Uint("neigh_uid", uid).Str("status", string(status)).Msgf("Neighbour %d is %s (was %s)", uid, status, old)
+	failureNeighbourStatus.WithLabelValues(fmt.Sprint(uid)).Set(statusValue[status])
+
+	// A dead neighbour is no longer registered; it is again once it sends heartbeats
+	if _, ok := h.neighs.Registered[uid]; ok && (status == StatusDead || old == StatusDead) {
+		h.neighs.Registered[uid] = status != StatusDead
+	}
+
+	types := []string{}
+	for msgType := range h.ext {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	for _, msgType := range types {
+		if l, ok := h.ext[msgType].(FailureListener); ok {
+			l.NeighbourChanged(h, uid, status)
+		}
+	}
+}
+
+// peers returns the out- and in-neighbours, all of them receive heartbeats
+func (fd *failureDetector) peers(h *handler) map[uint]string {
+	peers := map[uint]string{}
+	for uid, addr := range h.neighs.Incoming() {
+		peers[uid] = addr
+	}
+	for uid, addr := range h.neighs.Nodes {
+		peers[uid] = addr
+	}
+	return peers
+}
+
+// watch starts tracking a new neighbour, assuming it alive
+func (fd *failureDetector) watch(h *handler, uid uint) {
+	fd.lastSeen[uid] = h.now()
+	fd.status[uid] = StatusAlive
+}
+
+// forget stops tracking a node, e.g. after it left the cluster
+func (fd *failureDetector) forget(uid uint) {
+	delete(fd.lastSeen, uid)
+	delete(fd.status, uid)
+}
+
+// uids returns the tracked nodes in ascending order
+func (fd *failureDetector) uids() []uint {
+	uids := []uint{}
+	for uid := range fd.status {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// status returns the status of a neighbour as seen by the failure detector; alive if the detector is disabled
+func (h *handler) status(uid uint) NodeStatus {
+	if h.fd == nil {
+		return StatusAlive
+	}
+	if s, ok := h.fd.status[uid]; ok {
+		return s
+	}
+	return StatusAlive
+}
@@ -0,0 +1,187 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// failureRecorder records the status changes it is notified about
+type failureRecorder struct {
+	changes []NodeStatus
+}
+
+func (r *failureRecorder) Handle(h *handler, msg *com.Message) error       { return nil }
+func (r *failureRecorder) Preflight(ctx context.Context, h *handler) error { return nil }
+func (r *failureRecorder) NeighbourChanged(h *handler, uid uint, status NodeStatus) {
+	r.changes = append(r.changes, status)
+}
+
+func TestFailureDetector(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	r := &failureRecorder{}
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+		h.DetectFailures(100*time.Millisecond, 300*time.Millisecond, time.Second)
+	})
+	s.nodes[1].Register(r, "TEST")
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+
+	s.Run(context.Background(), 2*time.Second)
+	assert.Equal(t, StatusAlive, s.nodes[1].status(2), "heartbeats keep the neighbour alive")
+	assert.True(t, s.nodes[1].neighs.Registered[2])
+	assert.Empty(t, r.changes)
+
+	// Crash node 2
+	s.Inject(0, 2, com.Msg(0, "CONTROL", "SHUTDOWN"))
+	s.Run(context.Background(), 2500*time.Millisecond)
+	assert.Equal(t, StatusSuspected, s.nodes[1].status(2))
+	s.Run(context.Background(), 4*time.Second)
+	assert.Equal(t, StatusDead, s.nodes[1].status(2))
+	assert.False(t, s.nodes[1].neighs.Registered[2], "dead neighbours are no longer registered")
+	assert.Equal(t, []NodeStatus{StatusSuspected, StatusDead}, r.changes)
+}
+
+func TestFailureDetector_directed(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}, 3: {1}}, Directed: true}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	r := &failureRecorder{}
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.DetectFailures(100*time.Millisecond, 300*time.Millisecond, time.Second)
+	})
+	s.nodes[1].Register(r, "TEST")
+	assert.Nil(t, s.Start(context.Background()))
+
+	s.Run(context.Background(), 2*time.Second)
+	assert.Empty(t, r.changes, "in-neighbours are kept alive")
+	assert.Equal(t, []uint{3}, s.nodes[1].fd.uids(), "only the in-neighbour is judged")
+
+	// Crash node 3, only its out-neighbour 1 notices
+	s.Inject(0, 3, com.Msg(0, "CONTROL", "SHUTDOWN"))
+	s.Run(context.Background(), 4*time.Second)
+	assert.Equal(t, StatusDead, s.nodes[1].status(3))
+	assert.Equal(t, []NodeStatus{StatusSuspected, StatusDead}, r.changes)
+	assert.Equal(t, []uint{1}, s.nodes[2].fd.uids())
+	assert.Equal(t, StatusAlive, s.nodes[2].status(1))
+}
+
+// failingElection restarts the election on failures, like the extensions embedding it
+type failingElection struct {
+	*election
+}
+
+func (e *failingElection) NeighbourChanged(h *handler, uid uint, status NodeStatus) {
+	e.leader.neighbourChanged(h, uid, status)
+}
+
+func TestLeader_failure(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1", 4: "n4:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}, 3: {4}, 4: {1}}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+		h.Register(&failingElection{newElection("ELECTION", true)}, "ELECTION")
+		h.DetectFailures(100*time.Millisecond, 300*time.Millisecond, time.Second)
+	})
+	for _, uid := range []uint{1, 2, 3} {
+		s.nodes[uid].ext["ELECTION"].(*failingElection).leader.wantLeader = false // node 4 is the only volunteer
+	}
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+	s.InjectAll(100*time.Millisecond, com.Msg(0, "ELECTION", "coordinator"))
+	s.Run(context.Background(), time.Second)
+	for _, uid := range s.UIDs() {
+		assert.Equal(t, uint(4), s.nodes[uid].ext["ELECTION"].(*failingElection).leader.leaderUID)
+	}
+
+	// The leader fails, its neighbours flood the restart and the remaining nodes elect a new leader among themselves
+	s.Inject(0, 4, com.Msg(0, "CONTROL", "SHUTDOWN"))
+	s.Run(context.Background(), 4*time.Second)
+	for _, uid := range []uint{1, 2, 3} {
+		l := s.nodes[uid].ext["ELECTION"].(*failingElection).leader
+		assert.Equal(t, uint(3), l.leaderUID, "node %d elected the largest remaining node", uid)
+		assert.Equal(t, 1, l.epoch, "node %d restarted once", uid)
+	}
+}
+
+func TestLamportMutexQueue_Remove(t *testing.T) {
+	lm := NewLamportMutexQueue()
+	lm.Add(3, 2)
+	lm.Add(5, 1)
+	lm.Add(7, 2)
+	assert.True(t, lm.Remove(2), "request of the failed node was at the head")
+	ts, nuid, ok := lm.Next()
+	assert.True(t, ok)
+	assert.Equal(t, []int{5, 1}, []int{ts, nuid})
+	assert.False(t, lm.Remove(3))
+}
+
+func TestBanking_snapshotWithDeadNode(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1", 4: "n4:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2, 3, 4}, 2: {3, 4}, 3: {4}}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+		h.Register(NewDistributedBankingExtension())
+		h.DetectFailures(100*time.Millisecond, 300*time.Millisecond, time.Second)
+	})
+	for _, uid := range s.UIDs() {
+		s.nodes[uid].ext["BANKING"].(*banking).leader.wantLeader = uid == 4
+	}
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+	s.InjectAll(100*time.Millisecond, com.Msg(0, "BANKING", "coordinator"))
+	s.Run(context.Background(), 12*time.Second)
+	observer := s.nodes[4].ext["BANKING"].(*banking)
+	assert.True(t, observer.leader.IsLeader())
+	assert.NotEmpty(t, observer.marker, "observer takes snapshots")
+
+	// Node 1 fails, the observer keeps completing snapshots of the remaining nodes
+	s.Inject(0, 1, com.Msg(0, "CONTROL", "SHUTDOWN"))
+	s.Run(context.Background(), 22*time.Second)
+	assert.Equal(t, StatusDead, s.nodes[4].status(1))
+	marker := observer.marker
+	s.Run(context.Background(), 34*time.Second)
+	assert.NotEqual(t, marker, observer.marker, "snapshot completed without the dead node")
+}
+
+func TestConsensus_failure(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1", 4: "n4:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2, 3, 4}, 2: {3, 4}, 3: {4}}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+		h.Register(NewConsensusExtension(2, 5, 2, 3))
+		h.DetectFailures(100*time.Millisecond, 300*time.Millisecond, time.Second)
+	})
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+	s.InjectAll(100*time.Millisecond, com.Msg(0, "CONSENSUS", "coordinator"))
+	s.Run(context.Background(), 3*time.Second)
+	leader := s.nodes[4].ext["CONSENSUS"].(*consensus).leader.leaderUID
+	assert.NotZero(t, leader)
+
+	// The coordinator fails, the largest remaining node wins the re-election and starts the vote
+	s.Inject(0, leader, com.Msg(0, "CONTROL", "SHUTDOWN"))
+	s.Run(context.Background(), 10*time.Second)
+	next := uint(4)
+	if leader == 4 {
+		next = 3
+	}
+	coordinator := s.nodes[next].ext["CONSENSUS"].(*consensus)
+	assert.True(t, coordinator.leader.IsLeader())
+	assert.Greater(t, coordinator.iterations, 0, "new coordinator collects the state")
+}
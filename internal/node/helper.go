@@ -2,6 +2,7 @@ package node
 
 import (
 	"errors"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -36,3 +37,13 @@ func nthBool(s string, i int) (bool, error) {
 	}
 	return ss[i] == "true", nil
 }
+
+// sortedUIDs returns the UIDs of a node map in ascending order
+func sortedUIDs(nodes map[uint]string) []uint {
+	uids := []uint{}
+	for uid := range nodes {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
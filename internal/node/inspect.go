@@ -0,0 +1,143 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// Inspector is implemented by extensions exposing their state to global invariants
+type Inspector interface {
+	// Inspect returns the current state; it is called on the node loop
+	Inspect() map[string]interface{}
+}
+
+// NodeState is the inspected state of a node, keyed by the message type of the extension
+type NodeState struct {
+	UID        uint                              `json:"uid"`
+	Extensions map[string]map[string]interface{} `json:"extensions"`
+	InFlight   []*com.Message                    `json:"in_flight,omitempty"` // messages in the incoming channels, only set for snapshots
+}
+
+// inspect collects the state of all inspectable extensions
+func (h *handler) inspect() *NodeState {
+	s := &NodeState{UID: h.uid, Extensions: map[string]map[string]interface{}{}}
+	for msgType, e := range h.ext {
+		if i, ok := e.(Inspector); ok {
+			s.Extensions[msgType] = i.Inspect()
+		}
+	}
+	return s
+}
+
+func (s *NodeState) get(ext, key string) (interface{}, bool) {
+	e, ok := s.Extensions[ext]
+	if !ok {
+		return nil, false
+	}
+	v, ok := e[key]
+	return v, ok
+}
+
+// Has checks if the node runs an inspectable extension for the message type
+func (s *NodeState) Has(ext string) bool {
+	_, ok := s.Extensions[ext]
+	return ok
+}
+
+// Int returns an integer value, 0 if not present; handles values decoded from JSON
+func (s *NodeState) Int(ext, key string) int {
+	v, _ := s.get(ext, key)
+	switch n := v.(type) {
+	case int:
+		return n
+	case uint:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// Bool returns a boolean value, false if not present
+func (s *NodeState) Bool(ext, key string) bool {
+	v, _ := s.get(ext, key)
+	b, _ := v.(bool)
+	return b
+}
+
+// Strings returns a list of strings, nil if not present; handles values decoded from JSON
+func (s *NodeState) Strings(ext, key string) []string {
+	v, _ := s.get(ext, key)
+	switch l := v.(type) {
+	case []string:
+		return l
+	case []interface{}:
+		r := []string{}
+		for _, e := range l {
+			if str, ok := e.(string); ok {
+				r = append(r, str)
+			}
+		}
+		return r
+	}
+	return nil
+}
+
+// cut is the local part of a consistent snapshot (Chandy-Lamport) collected for an external collector
+type cut struct {
+	collector string
+	state     *NodeState
+	recording map[uint]bool // incoming channels still recorded
+}
+
+// recordCut stores messages arriving on channels that are still recorded
+func (h *handler) recordCut(msg *com.Message) {
+	for _, c := range h.cuts {
+		if c.recording[*msg.SourceUID] {
+			c.state.InFlight = append(c.state.InFlight, msg)
+		}
+	}
+}
+
+// marker handles a snapshot marker; src is 0 when the snapshot is initiated by the collector
+func (h *handler) marker(id, collector string, src uint) error {
+	if h.cuts == nil {
+		h.cuts = map[string]*cut{}
+	}
+
+	c, ok := h.cuts[id]
+	if !ok {
+		// First marker, record the state and all incoming channels
+		c = &cut{collector: collector, state: h.inspect(), recording: map[uint]bool{}}
+		for nuid := range h.neighs.Incoming() {
+			c.recording[nuid] = true
+		}
+		h.cuts[id] = c
+
+		m := com.Msg(h.uid, "CONTROL", fmt.Sprintf("MARKER %s %s", id, collector))
+		for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+			if err := h.send(h.neighs.Nodes[nuid], m); err != nil {
+				log.Err(err).Uint("uid", h.uid).Msgf("Failed sending marker to %d", nuid)
+			}
+		}
+	}
+	delete(c.recording, src)
+
+	if len(c.recording) > 0 {
+		return nil
+	}
+
+	// All channels recorded, report to the collector
+	delete(h.cuts, id)
+	sort.SliceStable(c.state.InFlight, func(i, j int) bool { return *c.state.InFlight[i].SourceUID < *c.state.InFlight[j].SourceUID })
+	b, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	log.Info().Uint("uid", h.uid).Msgf("Snapshot %s complete, reporting to %s", id, c.collector)
+	return h.send(c.collector, com.Msg(h.uid, "CONTROL", fmt.Sprintf("STATE %s %s", id, string(b))))
+}
@@ -0,0 +1,313 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Invariant is a predicate over the states of all nodes
+type Invariant struct {
+	Name string
+	// Eventually marks invariants that only have to hold at the end of a run, e.g. every node trusts the rumor
+	Eventually bool
+	// Check returns the nodes participating in a violation along with the error
+	Check func(states map[uint]*NodeState) ([]uint, error)
+}
+
+// Violation of an invariant including the states of the participating nodes
+type Violation struct {
+	Invariant string       `json:"invariant"`
+	Error     string       `json:"error"`
+	States    []*NodeState `json:"states"`
+}
+
+// CheckInvariants evaluates the invariants on a global state; eventually invariants are only evaluated if final is set
+func CheckInvariants(states map[uint]*NodeState, invs []*Invariant, final bool) []*Violation {
+	violations := []*Violation{}
+	for _, inv := range invs {
+		if inv.Eventually && !final {
+			continue
+		}
+		uids, err := inv.Check(states)
+		if err == nil {
+			continue
+		}
+		v := &Violation{Invariant: inv.Name, Error: err.Error(), States: []*NodeState{}}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+		for _, uid := range uids {
+			if s, ok := states[uid]; ok {
+				v.States = append(v.States, s)
+			}
+		}
+		violations = append(violations, v)
+	}
+	return violations
+}
+
+// ParseInvariants constructs invariants from a comma separated list, e.g. `leader:BANKING,mutex,money,rumor:SomeRumor`
+func ParseInvariants(spec string) ([]*Invariant, error) {
+	invs := []*Invariant{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		arg := ""
+		if i := strings.Index(name, ":"); i >= 0 {
+			name, arg = name[:i], name[i+1:]
+		}
+		switch {
+		case name == "":
+			continue
+		case name == "leader" && arg != "":
+			invs = append(invs, SingleLeader(arg))
+		case name == "elected" && arg != "":
+			invs = append(invs, LeaderElected(arg))
+		case name == "mutex":
+			invs = append(invs, MutualExclusion())
+		case name == "money":
+			invs = append(invs, MoneyConserved())
+		case name == "consensus":
+			invs = append(invs, ConsensusAgreement())
+		case name == "collected":
+			invs = append(invs, ConsensusCollected())
+		case name == "rumor" && arg != "":
+			invs = append(invs, RumorTrusted(arg))
+		default:
+			return nil, fmt.Errorf("unknown invariant `%s`", name)
+		}
+	}
+	return invs, nil
+}
+
+// stateUIDs returns the UIDs of all states in ascending order
+func stateUIDs(states map[uint]*NodeState) []uint {
+	uids := []uint{}
+	for uid := range states {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// SingleLeader checks that at most one node is leader and all nodes knowing a leader agree on it
+func SingleLeader(msgType string) *Invariant {
+	return &Invariant{
+		Name: "single leader " + msgType,
+		Check: func(states map[uint]*NodeState) ([]uint, error) {
+			leaders := []uint{}
+			known := map[int][]uint{}
+			for _, uid := range stateUIDs(states) {
+				s := states[uid]
+				if s.Bool(msgType, "is_leader") {
+					leaders = append(leaders, uid)
+				}
+				if l := s.Int(msgType, "leader_uid"); l != 0 {
+					known[l] = append(known[l], uid)
+				}
+			}
+			if len(leaders) > 1 {
+				return leaders, fmt.Errorf("multiple leaders %v", leaders)
+			}
+			if len(known) > 1 {
+				uids := []uint{}
+				for _, v := range known {
+					uids = append(uids, v...)
+				}
+				return uids, fmt.Errorf("nodes disagree on the leader (%d different leaders)", len(known))
+			}
+			return nil, nil
+		},
+	}
+}
+
+// LeaderElected checks that every node knows the leader and the parent pointers form a spanning tree rooted at the leader
+func LeaderElected(msgType string) *Invariant {
+	return &Invariant{
+		Name:       "leader elected " + msgType,
+		Eventually: true,
+		Check: func(states map[uint]*NodeState) ([]uint, error) {
+			for _, uid := range stateUIDs(states) {
+				if !states[uid].Has(msgType) {
+					continue
+				}
+				if states[uid].Int(msgType, "leader_uid") == 0 {
+					return []uint{uid}, fmt.Errorf("node %d does not know the leader", uid)
+				}
+
+				// Follow the parents up to the leader
+				visited := map[uint]bool{}
+				cur := uid
+				for !states[cur].Bool(msgType, "is_leader") {
+					if visited[cur] {
+						return []uint{uid, cur}, fmt.Errorf("cycle in the spanning tree starting at node %d", uid)
+					}
+					visited[cur] = true
+					parent := uint(states[cur].Int(msgType, "parent"))
+					p, ok := states[parent]
+					if !ok || parent == cur {
+						return []uint{cur}, fmt.Errorf("node %d has no parent", cur)
+					}
+					isChild := false
+					v, _ := p.get(msgType, "childs")
+					for _, c := range uintList(v) {
+						isChild = isChild || c == cur
+					}
+					if !isChild {
+						return []uint{cur, parent}, fmt.Errorf("node %d is not a child of its parent %d", cur, parent)
+					}
+					cur = parent
+				}
+			}
+			return nil, nil
+		},
+	}
+}
+
+// uintList converts a list of UIDs; handles values decoded from JSON
+func uintList(v interface{}) []uint {
+	switch l := v.(type) {
+	case []uint:
+		return l
+	case []interface{}:
+		r := []uint{}
+		for _, e := range l {
+			if f, ok := e.(float64); ok {
+				r = append(r, uint(f))
+			}
+		}
+		return r
+	}
+	return nil
+}
+
+// MutualExclusion checks that at most one node is in the critical section of the banking experiment
+func MutualExclusion() *Invariant {
+	return &Invariant{
+		Name: "mutual exclusion",
+		Check: func(states map[uint]*NodeState) ([]uint, error) {
+			inside := []uint{}
+			for _, uid := range stateUIDs(states) {
+				if states[uid].Bool("BANKING", "critical_section") {
+					inside = append(inside, uid)
+				}
+			}
+			if len(inside) > 1 {
+				return inside, fmt.Errorf("nodes %v are in the critical section", inside)
+			}
+			return nil, nil
+		},
+	}
+}
+
+// MoneyConserved checks that the total balance stays the same; states with a transaction in progress are skipped
+func MoneyConserved() *Invariant {
+	var initial map[uint]int // balances of the first stable state
+	return &Invariant{
+		Name: "money conserved",
+		Check: func(states map[uint]*NodeState) ([]uint, error) {
+			total := 0
+			balances := map[uint]int{}
+			for _, uid := range stateUIDs(states) {
+				s := states[uid]
+				if !s.Has("BANKING") {
+					continue
+				}
+				if s.Bool("BANKING", "critical_section") {
+					return nil, nil // transaction in progress
+				}
+				for _, m := range s.InFlight {
+					if *m.Type == "BANKING" && (strings.HasPrefix(*m.Payload, "transactStart") || strings.HasPrefix(*m.Payload, "transactBalance")) {
+						return nil, nil // messages in the channels affect the balances
+					}
+				}
+				balances[uid] = s.Int("BANKING", "balance")
+				total = total + balances[uid]
+			}
+
+			if initial == nil {
+				initial = balances
+				return nil, nil
+			}
+			before := 0
+			changed := []uint{}
+			for uid, b := range initial {
+				before = before + b
+				if balances[uid] != b {
+					changed = append(changed, uid)
+				}
+			}
+			if total != before {
+				return changed, fmt.Errorf("total balance changed from %d to %d", before, total)
+			}
+			return nil, nil
+		},
+	}
+}
+
+// ConsensusAgreement checks that all nodes agree on t_k once the leader collected the result
+func ConsensusAgreement() *Invariant {
+	return &Invariant{
+		Name: "consensus agreement",
+		Check: func(states map[uint]*NodeState) ([]uint, error) {
+			for _, leader := range stateUIDs(states) {
+				s := states[leader]
+				if !s.Bool("CONSENSUS", "collect_done") {
+					continue
+				}
+				tK := s.Int("CONSENSUS", "collected_t_k")
+				if !s.Bool("CONSENSUS", "agreement") {
+					return []uint{leader}, fmt.Errorf("collect on leader %d reported no agreement", leader)
+				}
+				differ := []uint{}
+				for _, uid := range stateUIDs(states) {
+					if states[uid].Has("CONSENSUS") && states[uid].Int("CONSENSUS", "t_k") != tK {
+						differ = append(differ, uid)
+					}
+				}
+				if len(differ) > 0 {
+					return append(differ, leader), fmt.Errorf("nodes %v disagree with the collected t_k=%d", differ, tK)
+				}
+			}
+			return nil, nil
+		},
+	}
+}
+
+// ConsensusCollected checks that a leader eventually collected the result of the vote
+func ConsensusCollected() *Invariant {
+	return &Invariant{
+		Name:       "consensus collected",
+		Eventually: true,
+		Check: func(states map[uint]*NodeState) ([]uint, error) {
+			for _, uid := range stateUIDs(states) {
+				if states[uid].Bool("CONSENSUS", "collect_done") {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("no leader collected the result")
+		},
+	}
+}
+
+// RumorTrusted checks that every node eventually trusts the rumor
+func RumorTrusted(rumor string) *Invariant {
+	return &Invariant{
+		Name:       "rumor trusted " + rumor,
+		Eventually: true,
+		Check: func(states map[uint]*NodeState) ([]uint, error) {
+			missing := []uint{}
+			for _, uid := range stateUIDs(states) {
+				trusted := false
+				for _, rm := range states[uid].Strings("RUMOR", "trusted") {
+					trusted = trusted || rm == rumor
+				}
+				if states[uid].Has("RUMOR") && !trusted {
+					missing = append(missing, uid)
+				}
+			}
+			if len(missing) > 0 {
+				return missing, fmt.Errorf("%d nodes do not trust the rumor", len(missing))
+			}
+			return nil, nil
+		},
+	}
+}
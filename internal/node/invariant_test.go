@@ -0,0 +1,145 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func bankingState(uid uint, leader uint, balance int, cs bool) *NodeState {
+	return &NodeState{UID: uid, Extensions: map[string]map[string]interface{}{
+		"BANKING": {"leader_uid": leader, "is_leader": uid == leader, "balance": balance, "critical_section": cs},
+	}}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []map[uint]*NodeState // evaluated in order, the last one has to match
+		inv    *Invariant
+		want   []uint // participating nodes, nil if no violation
+	}{
+		{
+			"Single leader",
+			[]map[uint]*NodeState{{1: bankingState(1, 2, 0, false), 2: bankingState(2, 2, 0, false)}},
+			SingleLeader("BANKING"),
+			nil,
+		},
+		{
+			"Disagreeing leaders",
+			[]map[uint]*NodeState{{1: bankingState(1, 1, 0, false), 2: bankingState(2, 2, 0, false), 3: bankingState(3, 0, 0, false)}},
+			SingleLeader("BANKING"),
+			[]uint{1, 2},
+		},
+		{
+			"Mutual exclusion",
+			[]map[uint]*NodeState{{1: bankingState(1, 1, 0, true), 2: bankingState(2, 1, 0, true)}},
+			MutualExclusion(),
+			[]uint{1, 2},
+		},
+		{
+			"Money conserved",
+			[]map[uint]*NodeState{
+				{1: bankingState(1, 1, 100, false), 2: bankingState(2, 1, 50, false)},
+				{1: bankingState(1, 1, 10, true), 2: bankingState(2, 1, 50, false)}, // transaction in progress
+				{1: bankingState(1, 1, 120, false), 2: bankingState(2, 1, 30, false)},
+			},
+			MoneyConserved(),
+			nil,
+		},
+		{
+			"Money created",
+			[]map[uint]*NodeState{
+				{1: bankingState(1, 1, 100, false), 2: bankingState(2, 1, 50, false)},
+				{1: bankingState(1, 1, 100, false), 2: bankingState(2, 1, 60, false)},
+			},
+			MoneyConserved(),
+			[]uint{2},
+		},
+		{
+			"Consensus collected",
+			[]map[uint]*NodeState{{
+				1: {UID: 1, Extensions: map[string]map[string]interface{}{"CONSENSUS": {"t_k": 7, "collect_done": true, "agreement": true, "collected_t_k": 7}}},
+				2: {UID: 2, Extensions: map[string]map[string]interface{}{"CONSENSUS": {"t_k": 7}}},
+			}},
+			ConsensusCollected(),
+			nil,
+		},
+		{
+			"Consensus not collected",
+			[]map[uint]*NodeState{{1: {UID: 1, Extensions: map[string]map[string]interface{}{"CONSENSUS": {"t_k": 7}}}}},
+			ConsensusCollected(),
+			[]uint{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v []*Violation
+			for _, states := range tt.states {
+				v = CheckInvariants(states, []*Invariant{tt.inv}, true)
+			}
+			if tt.want == nil {
+				assert.Empty(t, v)
+				return
+			}
+			if assert.Len(t, v, 1) {
+				uids := []uint{}
+				for _, s := range v[0].States {
+					uids = append(uids, s.UID)
+				}
+				assert.Equal(t, tt.want, uids, "participating nodes are reported")
+			}
+		})
+	}
+}
+
+func TestNodeState_json(t *testing.T) {
+	// Live clusters report their state as JSON
+	s := &NodeState{UID: 3, Extensions: map[string]map[string]interface{}{
+		"RUMOR":     {"trusted": []string{"a"}},
+		"CONSENSUS": {"t_k": 4, "childs": []uint{1, 2}},
+	}}
+	b, err := json.Marshal(s)
+	assert.Nil(t, err)
+	d := &NodeState{}
+	assert.Nil(t, json.Unmarshal(b, d))
+
+	assert.Equal(t, 4, d.Int("CONSENSUS", "t_k"))
+	assert.Equal(t, []string{"a"}, d.Strings("RUMOR", "trusted"))
+	v, _ := d.get("CONSENSUS", "childs")
+	assert.Equal(t, []uint{1, 2}, uintList(v))
+	assert.Equal(t, 0, d.Int("BANKING", "balance"))
+}
+
+func TestParseInvariants(t *testing.T) {
+	invs, err := ParseInvariants("leader:BANKING, mutex,money,rumor:Some Rumor")
+	assert.Nil(t, err)
+	assert.Len(t, invs, 4)
+	assert.Equal(t, "rumor trusted Some Rumor", invs[3].Name)
+
+	_, err = ParseInvariants("leader")
+	assert.NotNil(t, err, "leader requires a message type")
+}
+
+func TestSnapshot_directed(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}, 3: {1}}, Directed: true}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+	})
+	assert.Nil(t, s.Start(context.Background()))
+	assert.Equal(t, map[uint]string{2: "n2:1"}, s.nodes[1].neighs.Nodes)
+
+	// Markers only travel along the ring; every node waits for its single incoming channel
+	s.Inject(0, 1, com.Msg(0, "CONTROL", "SNAPSHOT s1 collector:1"))
+	s.Run(context.Background(), time.Second)
+	for _, uid := range s.UIDs() {
+		assert.Empty(t, s.nodes[uid].cuts, "node %d recorded all incoming channels", uid)
+	}
+}
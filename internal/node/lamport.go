@@ -101,3 +101,19 @@ func (lm *lamportMutexQueue) Next() (int, int, bool) {
 
 	return ts, nuid, true
 }
+
+// Remove drops all lock requests of a node, e.g. after it failed; returns true if one of them was at the head of the queue
+func (lm *lamportMutexQueue) Remove(nuid int) bool {
+	head := len(lm.queue) > 0 && lm.tsNodeMap[lm.queue[0]] == nuid
+	queue := []int{}
+	for _, ts := range lm.queue {
+		if lm.tsNodeMap[ts] == nuid {
+			delete(lm.tsNodeMap, ts)
+			log.Info().Msgf("LamportLock dropped node=%d ts=%d", nuid, ts)
+			continue
+		}
+		queue = append(queue, ts)
+	}
+	lm.queue = queue
+	return head
+}
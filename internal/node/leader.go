@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/xvzf/vaa/pkg/com"
@@ -19,6 +20,11 @@ type Leader struct {
 	isLeader    bool
 	m           int
 	leaderUID   uint // Will be > 0 when a leader has been selected; in this case all election messages are ignored
+	depth       int  // Depth of this node in the spanning tree, set once the leader is known
+	epoch       int  // Elections restarted after a failure, restarts of older epochs are known
+
+	// Metrics
+	electionStart time.Time // First participation in the election
 
 	// Those vars are linked to an active election and are resetted whenever m changes
 	childUIDs         []uint // Childs of this node (spanning tree)
@@ -27,6 +33,7 @@ type Leader struct {
 	sentExplore       int    // Used to track how many explore messages have been sent
 	receivedEcho      int    // Used to track how many echos have been received for
 	srcUID            uint   // Parent of this node (if it has one, if it is leader -> its own UID)
+	echoed            bool   // Echo for m sent; the election of m may complete from now on
 }
 
 func NewLeader(msgType string, wantLeader bool) *Leader {
@@ -36,6 +43,7 @@ func NewLeader(msgType string, wantLeader bool) *Leader {
 		isLeader:    false,
 		m:           0,
 		leaderUID:   0,
+		depth:       0,
 
 		childUIDs:         []uint{},
 		receivedParentMsg: 0,
@@ -49,19 +57,84 @@ func NewLeader(msgType string, wantLeader bool) *Leader {
 func (l *Leader) TryHandleLeaderMessage(h *handler, msg *com.Message) (bool, error) {
 	l.Lock()
 	defer l.Unlock()
+	var err error
 	switch payload := *msg.Payload; {
 	case strings.HasPrefix(payload, "explore"): // Check if payload is allowed
-		return true, l.handle_explore(h, msg)
+		err = l.handle_explore(h, msg)
 	case strings.HasPrefix(payload, "child"): // Check if payload is allowed
-		return true, l.handle_child(h, msg)
+		err = l.handle_child(h, msg)
 	case strings.HasPrefix(payload, "echo"): // Check if payload is allowed
-		return true, l.handle_echo(h, msg)
+		err = l.handle_echo(h, msg)
 	case strings.HasPrefix(payload, "coordinator"): // Check if payload is allowed
-		return true, l.handle_coordinator(h, msg)
+		err = l.handle_coordinator(h, msg)
 	case strings.HasPrefix(payload, "leader"): // Check if payload is allowed
-		return true, l.handle_leader(h, msg)
+		err = l.handle_leader(h, msg)
+	case strings.HasPrefix(payload, "restart"): // Check if payload is allowed
+		err = l.handle_restart(h, msg)
+	default:
+		return false, nil
+	}
+	l.persist(h)
+	return true, err
+}
+
+// leaderState is the durable part of the election state
+type leaderState struct {
+	WantLeader        bool   `json:"want_leader"`
+	IsLeader          bool   `json:"is_leader"`
+	M                 int    `json:"m"`
+	LeaderUID         uint   `json:"leader_uid"`
+	Depth             int    `json:"depth"`
+	Epoch             int    `json:"epoch"`
+	ChildUIDs         []uint `json:"childs"`
+	ReceivedParentMsg int    `json:"received_parent_msg"`
+	ReceivedExplore   int    `json:"received_explore"`
+	SentExplore       int    `json:"sent_explore"`
+	ReceivedEcho      int    `json:"received_echo"`
+	SrcUID            uint   `json:"parent"`
+	Echoed            bool   `json:"echoed"`
+}
+
+// persist writes the election state, the caller holds the lock
+func (l *Leader) persist(h *handler) {
+	h.persist(l.messageType+"/leader", &leaderState{
+		WantLeader:        l.wantLeader,
+		IsLeader:          l.isLeader,
+		M:                 l.m,
+		LeaderUID:         l.leaderUID,
+		Depth:             l.depth,
+		Epoch:             l.epoch,
+		ChildUIDs:         l.childUIDs,
+		ReceivedParentMsg: l.receivedParentMsg,
+		ReceivedExplore:   l.receivedExplore,
+		SentExplore:       l.sentExplore,
+		ReceivedEcho:      l.receivedEcho,
+		SrcUID:            l.srcUID,
+		Echoed:            l.echoed,
+	})
+}
+
+// restore loads the election state of a previous run; called in the preflight of the owning extension
+func (l *Leader) restore(h *handler) {
+	l.Lock()
+	defer l.Unlock()
+	s := &leaderState{}
+	if !h.restore(l.messageType+"/leader", s) {
+		return
 	}
-	return false, nil
+	l.wantLeader = s.WantLeader
+	l.isLeader = s.IsLeader
+	l.m = s.M
+	l.leaderUID = s.LeaderUID
+	l.depth = s.Depth
+	l.epoch = s.Epoch
+	l.childUIDs = append([]uint{}, s.ChildUIDs...)
+	l.receivedParentMsg = s.ReceivedParentMsg
+	l.receivedExplore = s.ReceivedExplore
+	l.sentExplore = s.SentExplore
+	l.receivedEcho = s.ReceivedEcho
+	l.srcUID = s.SrcUID
+	l.echoed = s.Echoed
 }
 
 func (l *Leader) ElectionComplete() bool {
@@ -76,19 +149,173 @@ func (l *Leader) IsLeader() bool {
 	return l.isLeader
 }
 
+// inspect returns the election state, shared by all extensions running an election
+func (l *Leader) inspect() map[string]interface{} {
+	l.Lock()
+	defer l.Unlock()
+	return map[string]interface{}{
+		"leader_uid": l.leaderUID,
+		"is_leader":  l.isLeader,
+		"parent":     l.srcUID,
+		"childs":     append([]uint{}, l.childUIDs...),
+		"depth":      l.depth,
+	}
+}
+
+// neighbourChanged restarts the election if a failed neighbour was part of the spanning tree of this node or the running
+// election waits for it; true if the election was restarted
+func (l *Leader) neighbourChanged(h *handler, uid uint, status NodeStatus) bool {
+	l.Lock()
+	defer l.Unlock()
+	if status != StatusDead {
+		return false
+	}
+	role := ""
+	switch {
+	case uid == l.leaderUID:
+		role = "leader"
+	case uid == l.srcUID && !l.isLeader:
+		role = "parent"
+	case l.leaderUID == 0 && l.m != 0:
+		role = "participant"
+	default:
+		for _, c := range l.childUIDs {
+			if c == uid {
+				role = "child"
+			}
+		}
+	}
+	if role == "" {
+		return false
+	}
+	log.Warn().Uint("uid", h.uid).Str("type", l.messageType).Msgf("Spanning tree broken, %s %d failed", role, uid)
+	l.epoch += 1
+	l.floodRestart(h, h.uid)
+	l.reset(h)
+	l.persist(h)
+	return true
+}
+
+// handle_restart restarts the election on the first restart message of a newer epoch
+func (l *Leader) handle_restart(h *handler, msg *com.Message) error {
+	epoch, err := nthInt(*msg.Payload, 1)
+	if err != nil {
+		return err
+	}
+	if epoch <= l.epoch {
+		log.Debug().Uint("uid", h.uid).Msgf("Restart of epoch %d already known", epoch)
+		return nil
+	}
+	l.epoch = epoch
+	l.floodRestart(h, *msg.SourceUID)
+	l.reset(h)
+	return nil
+}
+
+// stale reports election messages of an older epoch; they were sent before the sender restarted, which may happen after
+// this node restarted on its own. Messages without the epoch (`leader;<node-id>`) are accepted
+func (l *Leader) stale(h *handler, msg *com.Message, n int) bool {
+	epoch, err := nthInt(*msg.Payload, n)
+	if err != nil || epoch >= l.epoch {
+		return false
+	}
+	log.Debug().Uint("uid", h.uid).Str("req_id", *msg.UUID).Msgf("Ignoring election message of epoch %d, current %d", epoch, l.epoch)
+	return true
+}
+
+// floodRestart forwards the restart of the current epoch to all live neighbours but the sender; this happens before the
+// explores of the new election, so (FIFO channels) every node restarts before participating in it
+func (l *Leader) floodRestart(h *handler, src uint) {
+	for _, nuid := range l.neighs(h) {
+		if nuid == src {
+			continue
+		}
+		if err := h.send(h.neighs.Nodes[nuid], com.Msg(h.uid, l.messageType, fmt.Sprintf("restart;%d", l.epoch))); err != nil {
+			log.Err(err).Msg("Failed to forward restart")
+		}
+	}
+}
+
+// restart discards the election result after the topology or the membership changed and starts a new election; the
+// change is only seen by some nodes (or at different times), the restart of a new epoch is flooded so every node joins
+func (l *Leader) restart(h *handler) {
+	l.Lock()
+	defer l.Unlock()
+	log.Info().Uint("uid", h.uid).Str("type", l.messageType).Msg("Topology changed, restarting the election")
+	l.epoch += 1
+	l.floodRestart(h, h.uid)
+	l.reset(h)
+	l.persist(h)
+}
+
+// reset discards the election result and starts a new election among all remaining members, the nodes that wanted to be
+// leader might have left; the caller holds the lock
+func (l *Leader) reset(h *handler) {
+	if l.isLeader {
+		leaderIsLeader.WithLabelValues(l.messageType).Set(0)
+	}
+	l.isLeader = false
+	l.m = 0
+	l.leaderUID = 0
+	l.depth = 0
+	l.electionStart = time.Time{}
+	l.childUIDs = []uint{}
+	l.receivedParentMsg = 0
+	l.receivedExplore = 0
+	l.sentExplore = 0
+	l.receivedEcho = 0
+	l.srcUID = 0
+	l.echoed = false
+	if err := l.start(h); err != nil {
+		log.Err(err).Uint("uid", h.uid).Msg("Failed to restart the election")
+	}
+}
+
+// neighs returns the neighbours taking part in the election; failed neighbours would never answer
+func (l *Leader) neighs(h *handler) []uint {
+	uids := []uint{}
+	for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+		if h.status(nuid) != StatusDead {
+			uids = append(uids, nuid)
+		}
+	}
+	return uids
+}
+
+// electionStarted marks the first participation of this node in the election
+func (l *Leader) electionStarted(h *handler) {
+	if l.electionStart.IsZero() {
+		l.electionStart = h.now()
+	}
+}
+
+// electionCompleted records the election metrics and reports the event once the leader is known
+func (l *Leader) electionCompleted(h *handler) {
+	if !l.electionStart.IsZero() {
+		leaderElectionDuration.WithLabelValues(l.messageType).Set(h.now().Sub(l.electionStart).Seconds())
+	}
+	leaderTreeDepth.WithLabelValues(l.messageType).Set(float64(l.depth))
+	if l.isLeader {
+		leaderIsLeader.WithLabelValues(l.messageType).Set(1)
+	}
+	h.emit(EventLeaderElected, map[string]interface{}{"type": l.messageType, "leader": l.leaderUID, "depth": l.depth})
+}
+
 // Propagates to all but sender
 func (l *Leader) propagate(h *handler, msg *com.Message) int {
 	total := 0
-	for nuid, connect := range h.neighs.Nodes {
+	for _, nuid := range l.neighs(h) {
+		connect := h.neighs.Nodes[nuid]
 		if nuid == *msg.SourceUID {
 			continue // skip sending to receiver
 		}
-		err := com.Send(connect, com.MsgPropagate(h.uid, msg))
+		err := h.send(connect, com.MsgPropagate(h.uid, msg))
 		if err != nil {
 			log.Err(err).Msg("Failed to proagate")
 		}
 		total += 1
 	}
+	leaderExploreTotal.WithLabelValues(l.messageType, "outgoing").Add(float64(total))
 
 	return total
 }
@@ -97,7 +324,7 @@ func (l *Leader) propagate(h *handler, msg *com.Message) int {
 func (l *Leader) PropagateChilds(h *handler, msg *com.Message) int {
 	total := 0
 	for _, cuid := range l.childUIDs {
-		err := com.Send(h.neighs.Nodes[cuid], com.MsgPropagate(h.uid, msg))
+		err := h.send(h.neighs.Nodes[cuid], com.MsgPropagate(h.uid, msg))
 		if err != nil {
 			log.Err(err).Msg("Failed to proagate")
 		}
@@ -117,23 +344,27 @@ func (l *Leader) checkSendEcho(h *handler) error {
 
 	// - Received echos from all childs -> send echo or trigger leader
 	// - No childs and received echo from all neighs -> send echo trigger leader
-	if (len(l.childUIDs) == l.receivedEcho) || (len(l.childUIDs) == 0 && l.receivedExplore == len(h.neighs.Nodes)) {
+	if (len(l.childUIDs) == l.receivedEcho) || (len(l.childUIDs) == 0 && l.receivedExplore == len(l.neighs(h))) {
 		if l.m == int(h.uid) { // Check if this node was the initiator
 			l.leaderUID = h.uid
+			l.depth = 0
 			// Send election results
-			log.Info().Msgf("Sending election result spanning tree (child nodes: %s)", l.childUIDs)
-			l.PropagateChilds(h, com.Msg(h.uid, l.messageType, fmt.Sprintf("leader;%d", h.uid)))
+			log.Info().Msgf("Sending election result spanning tree (child nodes: %v)", l.childUIDs)
+			l.PropagateChilds(h, com.Msg(h.uid, l.messageType, fmt.Sprintf("leader;%d;%d;%d", h.uid, l.depth+1, l.epoch)))
 			// This node is now the leader! :)
 			log.Info().Msgf("This node is now leader (%s)", l.messageType)
 			l.isLeader = true
+			l.electionCompleted(h)
 			return nil
 		} else { // This node is not the leader, send echo alongside the spanning tree
 			log.Info().Msgf("Send echo for %d to %d", l.m, l.srcUID)
-			msg := com.Msg(h.uid, l.messageType, fmt.Sprintf("echo;%d", l.m))
-			return com.Send(h.neighs.Nodes[l.srcUID], msg)
+			msg := com.Msg(h.uid, l.messageType, fmt.Sprintf("echo;%d;%d", l.m, l.epoch))
+			leaderEchoTotal.WithLabelValues(l.messageType, "outgoing").Inc()
+			l.echoed = true
+			return h.send(h.neighs.Nodes[l.srcUID], msg)
 		}
 	} else {
-		log.Info().Msgf("Echo condition not met rec_exp=%d rec_echo=%d rec_prt=%d neigh=%d childUIDs=%d", l.receivedExplore, l.receivedEcho, l.receivedParentMsg, len(h.neighs.Nodes), len(l.childUIDs))
+		log.Info().Msgf("Echo condition not met rec_exp=%d rec_echo=%d rec_prt=%d neigh=%d childUIDs=%d", l.receivedExplore, l.receivedEcho, l.receivedParentMsg, len(l.neighs(h)), len(l.childUIDs))
 	}
 
 	return nil
@@ -145,44 +376,75 @@ func (l *Leader) handle_coordinator(h *handler, msg *com.Message) error {
 		log.Info().Uint("uid", h.uid).Msg("Not starting coordinator election")
 		return nil
 	}
+	if l.leaderUID != 0 || int(h.uid) <= l.m || l.echoed {
+		// Already decided, participating in the election of a larger initiator (which extincts ours anyway) or its election
+		// may already have completed with our echo; a smaller election in progress is extincted by ours
+		log.Info().Uint("uid", h.uid).Msgf("Not starting coordinator election, already participating in election of %d", l.m)
+		return nil
+	}
+	return l.start(h)
+}
+
+// start initiates the election of this node, extincting elections of smaller initiators
+func (l *Leader) start(h *handler) error {
 	log.Info().Uint("uid", h.uid).Msg("Start coordinator election")
+	l.electionStarted(h)
 	// Set m to own
 	l.m = int(h.uid)
 	l.childUIDs = []uint{}
+	l.receivedParentMsg = 0
 	l.receivedEcho = 0
 	l.receivedExplore = 0
 	l.srcUID = h.uid // own UID
 	l.sentExplore = 0
+	l.echoed = false
 	// Send explore to all neighbouirs
-	for _, connect := range h.neighs.Nodes {
-		err := com.Send(connect, com.Msg(h.uid, l.messageType, fmt.Sprintf("explore;%d", h.uid)))
+	for _, nuid := range l.neighs(h) {
+		connect := h.neighs.Nodes[nuid]
+		err := h.send(connect, com.Msg(h.uid, l.messageType, fmt.Sprintf("explore;%d;%d", h.uid, l.epoch)))
 		if err != nil {
 			log.Err(err).Msg("Failed to send explore")
 		}
 		l.sentExplore += 1
 	}
-	return nil
+	leaderExploreTotal.WithLabelValues(l.messageType, "outgoing").Add(float64(l.sentExplore))
+	// A node without (live) neighbours is leader right away
+	return l.checkSendEcho(h)
 }
 
 // Handle_leader sets the leader status of the network
 func (l *Leader) handle_leader(h *handler, msg *com.Message) error {
+	if l.stale(h, msg, 3) {
+		return nil
+	}
 	luid, err := nthInt(*msg.Payload, 1)
 	if err != nil {
 		return err
 	}
 
+	// The depth is optional to stay compatible with `leader;<node-id>`
+	depth, err := nthInt(*msg.Payload, 2)
+	if err != nil {
+		depth = 0
+	}
+
 	log.Info().Uint("uid", h.uid).Msgf("Setting leaderUID to %d", luid)
 
 	// Set m to own
 	l.leaderUID = uint(luid)
+	l.depth = depth
+	l.electionCompleted(h)
 
-	log.Info().Msgf("Propagating leader message to %s", l.childUIDs)
-	l.PropagateChilds(h, msg)
+	log.Info().Msgf("Propagating leader message to %v", l.childUIDs)
+	l.PropagateChilds(h, com.Msg(h.uid, l.messageType, fmt.Sprintf("leader;%d;%d;%d", luid, depth+1, l.epoch)))
 	return nil
 }
 
 // Handle_explore handles incoming explore messages
 func (l *Leader) handle_explore(h *handler, msg *com.Message) error {
+	if l.stale(h, msg, 2) {
+		return nil
+	}
 	if l.leaderUID != 0 {
 		log.Warn().Str("req_id", *msg.UUID).Msgf("%d is already the leader, ignoring", l.leaderUID)
 		return nil
@@ -192,6 +454,8 @@ func (l *Leader) handle_explore(h *handler, msg *com.Message) error {
 	if err != nil {
 		return err
 	}
+	leaderExploreTotal.WithLabelValues(l.messageType, "incoming").Inc()
+	l.electionStarted(h)
 
 	if euid > l.m { // Larger m received
 		log.Info().Msgf("Explore %d > current %d, evicting", euid, l.m)
@@ -199,18 +463,20 @@ func (l *Leader) handle_explore(h *handler, msg *com.Message) error {
 		l.m = euid
 		l.receivedParentMsg = 0
 		l.receivedExplore = 1
+		l.receivedEcho = 0
 		l.sentExplore = 0
 		l.childUIDs = []uint{}
 		l.srcUID = *msg.SourceUID
+		l.echoed = false
 
 		// Send child message to parent
-		com.Send(h.neighs.Nodes[l.srcUID], com.Msg(h.uid, l.messageType, fmt.Sprintf("child;%d;1", l.m)))
+		h.send(h.neighs.Nodes[l.srcUID], com.Msg(h.uid, l.messageType, fmt.Sprintf("child;%d;1;%d", l.m, l.epoch)))
 
 		// Propagate to neighs
 		l.sentExplore = l.propagate(h, msg)
 
 	} else if euid == l.m { // Already known; not child
-		com.Send(h.neighs.Nodes[*msg.SourceUID], com.Msg(h.uid, l.messageType, fmt.Sprintf("child;%d;0", l.m)))
+		h.send(h.neighs.Nodes[*msg.SourceUID], com.Msg(h.uid, l.messageType, fmt.Sprintf("child;%d;0;%d", l.m, l.epoch)))
 		l.receivedExplore += 1
 	} else { // Lower m received; evicted
 		log.Info().Msgf("Evicted EXPLORE %d in favour of %d", euid, l.m)
@@ -223,6 +489,9 @@ func (l *Leader) handle_explore(h *handler, msg *com.Message) error {
 
 // Handle_explore handles incoming child messages
 func (l *Leader) handle_child(h *handler, msg *com.Message) error {
+	if l.stale(h, msg, 3) {
+		return nil
+	}
 	if l.leaderUID != 0 {
 		log.Warn().Str("req_id", *msg.UUID).Msgf("%d is already the leader, ignoring", l.leaderUID)
 		return nil
@@ -250,7 +519,7 @@ func (l *Leader) handle_child(h *handler, msg *com.Message) error {
 		// Check if edge node; trigger echo
 		l.checkSendEcho(h)
 	} else {
-		log.Info().Msgf("Ignore child for %d, voting for", euid, l.m)
+		log.Info().Msgf("Ignore child for %d, voting for %d", euid, l.m)
 	}
 
 	return nil
@@ -258,6 +527,9 @@ func (l *Leader) handle_child(h *handler, msg *com.Message) error {
 
 // Handle_explore handles incoming echo messages
 func (l *Leader) handle_echo(h *handler, msg *com.Message) error {
+	if l.stale(h, msg, 2) {
+		return nil
+	}
 	if l.leaderUID != 0 {
 		log.Warn().Str("req_id", *msg.UUID).Msgf("%d is already the leader, ignoring", l.leaderUID)
 		return nil
@@ -273,6 +545,7 @@ func (l *Leader) handle_echo(h *handler, msg *com.Message) error {
 	} else if euid == l.m {
 		// Increase received echo counter; check if we should propagate
 		l.receivedEcho += 1
+		leaderEchoTotal.WithLabelValues(l.messageType, "incoming").Inc()
 		// log.Warn().Msg("We should not be here, child messages should always come in before echo; processing nevertheless")
 		l.checkSendEcho(h) // FIXME should not happen
 	} else {
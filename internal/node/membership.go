@@ -0,0 +1,282 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// MembershipChange describes a node joining or leaving the cluster
+type MembershipChange struct {
+	Join      bool // false if the node left
+	UID       uint
+	Addr      string
+	Neighbour bool // the node is (or was) a neighbour of this node
+}
+
+// MembershipListener is implemented by extensions adapting to nodes joining or leaving
+type MembershipListener interface {
+	// MembershipChanged is called on the node loop after the neighbours and the view of all nodes were updated
+	MembershipChanged(h *handler, c *MembershipChange)
+}
+
+// membership keeps the neighbours and the view of all nodes up to date while nodes join and leave
+type membership struct {
+	contact string          // node to join through, empty for nodes of the initial configuration
+	known   map[string]bool // flooded membership messages
+}
+
+// NewMembershipExtension returns the membership handler; the node joins the cluster through contact if set
+func NewMembershipExtension(contact string) (Extension, string) {
+	return &membership{contact: contact, known: map[string]bool{}}, "MEMBERSHIP"
+}
+
+func (m *membership) Preflight(ctx context.Context, h *handler) error {
+	// The view changes at runtime; do not modify a view shared with other nodes (simulation)
+	all := map[uint]string{}
+	for uid, addr := range h.neighs.AllNodes {
+		all[uid] = addr
+	}
+	h.neighs.AllNodes = all
+
+	if m.contact == "" {
+		return nil
+	}
+	addr, ok := h.neighs.AllNodes[h.uid]
+	if !ok {
+		return errors.New("address of the joining node unknown")
+	}
+	log.Info().Uint("uid", h.uid).Msgf("Joining the cluster through %s", m.contact)
+	return h.send(m.contact, com.Msg(h.uid, "MEMBERSHIP", fmt.Sprintf("join;%d;%s;%s", h.uid, addr, uidList(sortedUIDs(h.neighs.Nodes)))))
+}
+
+func (m *membership) Handle(h *handler, msg *com.Message) error {
+	switch payload := *msg.Payload; {
+	case payload == "leave": // sent by the client, this node leaves
+		return m.leave(h)
+	case strings.HasPrefix(payload, "joined"):
+		return m.handle_joined(h, msg)
+	case strings.HasPrefix(payload, "join"):
+		return m.handle_join(h, msg)
+	case strings.HasPrefix(payload, "welcome"):
+		return m.handle_welcome(h, msg)
+	case strings.HasPrefix(payload, "left"):
+		return m.handle_left(h, msg)
+	}
+	return fmt.Errorf("payload `%s` not supported", *msg.Payload)
+}
+
+// handle_join is executed on the contact node; it sends the view to the joining node and announces it to the cluster
+func (m *membership) handle_join(h *handler, msg *com.Message) error {
+	ps := strings.Split(*msg.Payload, ";")
+	if len(ps) != 4 {
+		return errors.New("invalid message payload")
+	}
+	uid, err := strconv.Atoi(ps[1])
+	if err != nil {
+		return err
+	}
+	neighs := append(parseUIDList(ps[3]), h.uid) // the contact is always a neighbour
+
+	// The view first, the joining node needs the addresses of its neighbours
+	view := []string{}
+	for _, nuid := range sortedUIDs(h.neighs.AllNodes) {
+		view = append(view, fmt.Sprintf("%d=%s", nuid, h.neighs.AllNodes[nuid]))
+	}
+	if err := h.send(ps[2], com.Msg(h.uid, "MEMBERSHIP", "welcome;"+strings.Join(view, ","))); err != nil {
+		return err
+	}
+
+	joined := com.Msg(h.uid, "MEMBERSHIP", fmt.Sprintf("joined;%s;%d;%s;%s", h.randID(), uid, ps[2], uidList(neighs)))
+	return m.handle_joined(h, joined)
+}
+
+// handle_welcome initialises the view of a joining node
+func (m *membership) handle_welcome(h *handler, msg *com.Message) error {
+	view, err := nthString(*msg.Payload, 1)
+	if err != nil {
+		return err
+	}
+	for _, e := range strings.Split(view, ",") {
+		kv := strings.SplitN(e, "=", 2)
+		uid, err := strconv.Atoi(kv[0])
+		if err != nil || len(kv) != 2 {
+			return errors.New("invalid view")
+		}
+		h.neighs.AllNodes[uint(uid)] = kv[1]
+	}
+	log.Info().Uint("uid", h.uid).Msgf("Joined the cluster, %d nodes", len(h.neighs.AllNodes))
+	return nil
+}
+
+// handle_joined adds a node to the view; neighbours of the new node add it to their neighbours
+func (m *membership) handle_joined(h *handler, msg *com.Message) error {
+	ps := strings.Split(*msg.Payload, ";")
+	if len(ps) != 5 {
+		return errors.New("invalid message payload")
+	}
+	if !m.flooded(h, msg, ps[1]) {
+		return nil
+	}
+	uid, err := strconv.Atoi(ps[2])
+	if err != nil {
+		return err
+	}
+	c := &MembershipChange{Join: true, UID: uint(uid), Addr: ps[3]}
+	neighs := parseUIDList(ps[4])
+
+	h.neighs.AllNodes[c.UID] = c.Addr
+	if c.UID == h.uid {
+		// The joining node itself; connect to the requested neighbours
+		for _, nuid := range neighs {
+			m.connect(h, nuid, h.neighs.AllNodes[nuid])
+		}
+	} else {
+		for _, nuid := range neighs {
+			if nuid == h.uid {
+				c.Neighbour = true
+				m.connect(h, c.UID, c.Addr)
+			}
+		}
+	}
+	log.Info().Uint("uid", h.uid).Msgf("Node %d joined (neighbour: %t)", c.UID, c.Neighbour)
+
+	m.forward(h, msg)
+	h.membershipChanged(c)
+	return nil
+}
+
+// leave announces that this node leaves the cluster and stops it
+func (m *membership) leave(h *handler) error {
+	neighs := sortedUIDs(h.neighs.Nodes)
+	left := com.Msg(h.uid, "MEMBERSHIP", fmt.Sprintf("left;%s;%d;%s", h.randID(), h.uid, uidList(neighs)))
+	log.Info().Uint("uid", h.uid).Msg("Leaving the cluster")
+	for _, nuid := range neighs {
+		if err := h.send(h.neighs.Nodes[nuid], left); err != nil {
+			log.Err(err).Uint("uid", h.uid).Msgf("Failed announcing leave to %d", nuid)
+		}
+	}
+	h.exit()
+	return nil
+}
+
+// handle_left removes a node from the view; the neighbours of the left node connect to the lowest of them to keep the graph connected
+func (m *membership) handle_left(h *handler, msg *com.Message) error {
+	ps := strings.Split(*msg.Payload, ";")
+	if len(ps) != 4 {
+		return errors.New("invalid message payload")
+	}
+	if !m.flooded(h, msg, ps[1]) {
+		return nil
+	}
+	uid, err := strconv.Atoi(ps[2])
+	if err != nil {
+		return err
+	}
+	c := &MembershipChange{Join: false, UID: uint(uid), Addr: h.neighs.AllNodes[uint(uid)]}
+	if _, ok := h.neighs.Nodes[c.UID]; ok {
+		c.Neighbour = true
+	}
+
+	delete(h.neighs.AllNodes, c.UID)
+	delete(h.neighs.Nodes, c.UID)
+	delete(h.neighs.Registered, c.UID)
+	if h.fd != nil {
+		h.fd.forget(c.UID)
+	}
+
+	// Bridge the gap: the heir takes over the neighbours of the left node
+	orphans := []uint{}
+	for _, nuid := range parseUIDList(ps[3]) {
+		if _, ok := h.neighs.AllNodes[nuid]; ok {
+			orphans = append(orphans, nuid)
+		}
+	}
+	if c.Neighbour && len(orphans) > 1 {
+		heir := orphans[0]
+		for _, nuid := range orphans {
+			if nuid != h.uid && (h.uid == heir || nuid == heir) {
+				m.connect(h, nuid, h.neighs.AllNodes[nuid])
+			}
+		}
+	}
+	log.Info().Uint("uid", h.uid).Msgf("Node %d left (neighbour: %t)", c.UID, c.Neighbour)
+
+	m.forward(h, msg)
+	h.membershipChanged(c)
+	return nil
+}
+
+// flooded marks a flooded message as known; false if it was handled before
+func (m *membership) flooded(h *handler, msg *com.Message, id string) bool {
+	if m.known[id] {
+		log.Debug().Uint("uid", h.uid).Str("req_id", *msg.UUID).Msg("Already known")
+		return false
+	}
+	m.known[id] = true
+	return true
+}
+
+// connect adds a neighbour
+func (m *membership) connect(h *handler, uid uint, addr string) {
+	if _, ok := h.neighs.Nodes[uid]; ok || uid == h.uid || addr == "" {
+		return
+	}
+	log.Info().Uint("uid", h.uid).Msgf("Adding neighbour %d (%s)", uid, addr)
+	h.neighs.Nodes[uid] = addr
+	h.neighs.Registered[uid] = false
+}
+
+// forward floods a membership message to all neighbours but the sender; this happens before the extensions are notified, so
+// (FIFO channels) every node learns about the change before receiving messages that extensions send in reaction to it
+func (m *membership) forward(h *handler, msg *com.Message) {
+	for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+		if nuid == *msg.SourceUID {
+			continue
+		}
+		if err := h.send(h.neighs.Nodes[nuid], com.MsgPropagate(h.uid, msg)); err != nil {
+			log.Err(err).Uint("uid", h.uid).Msgf("Failed forwarding membership change to %d", nuid)
+		}
+	}
+}
+
+// membershipChanged passes a change of the view to all extensions implementing MembershipListener
+func (h *handler) membershipChanged(c *MembershipChange) {
+	types := []string{}
+	for msgType := range h.ext {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	for _, msgType := range types {
+		if l, ok := h.ext[msgType].(MembershipListener); ok {
+			l.MembershipChanged(h, c)
+		}
+	}
+}
+
+// uidList encodes UIDs as comma separated list
+func uidList(uids []uint) string {
+	s := []string{}
+	for _, uid := range uids {
+		s = append(s, fmt.Sprint(uid))
+	}
+	return strings.Join(s, ",")
+}
+
+// parseUIDList decodes a comma separated list of UIDs; invalid entries are skipped
+func parseUIDList(s string) []uint {
+	uids := []uint{}
+	for _, e := range strings.Split(s, ",") {
+		if uid, err := strconv.Atoi(e); err == nil {
+			uids = append(uids, uint(uid))
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func TestMembership(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewMembershipExtension(""))
+	})
+	// Node 4 joins through node 3
+	n4 := s.AddNode(4, &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: map[uint]string{4: "n4:1"}, Registered: map[uint]bool{}})
+	n4.Register(NewControlExtension())
+	n4.Register(NewMembershipExtension("n3:1"))
+	assert.Nil(t, s.Start(context.Background()))
+
+	s.Run(context.Background(), time.Second)
+	for _, uid := range []uint{1, 2, 3, 4} {
+		assert.Len(t, s.nodes[uid].neighs.AllNodes, 4, "node %d knows all nodes", uid)
+	}
+	assert.Equal(t, []uint{3}, sortedUIDs(s.nodes[4].neighs.Nodes))
+	assert.Equal(t, []uint{2, 4}, sortedUIDs(s.nodes[3].neighs.Nodes))
+
+	// Node 2 leaves; its neighbours 1 and 3 bridge the gap
+	s.Inject(0, 2, com.Msg(0, "MEMBERSHIP", "leave"))
+	s.Run(context.Background(), 2*time.Second)
+	assert.True(t, s.stopped[2])
+	for _, uid := range []uint{1, 3, 4} {
+		assert.NotContains(t, s.nodes[uid].neighs.AllNodes, uint(2), "node %d removed the left node", uid)
+	}
+	assert.Equal(t, []uint{3}, sortedUIDs(s.nodes[1].neighs.Nodes))
+	assert.Equal(t, []uint{1, 4}, sortedUIDs(s.nodes[3].neighs.Nodes))
+}
+
+func TestParseUIDList(t *testing.T) {
+	assert.Equal(t, []uint{1, 3, 7}, parseUIDList("7,1,x,3"))
+	assert.Empty(t, parseUIDList(""))
+	assert.Equal(t, "1,3,7", uidList(parseUIDList("3,7,1")))
+}
+
+func TestMembership_consensus(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewMembershipExtension(""))
+		h.Register(NewConsensusExtension(2, 5, 2, 3))
+	})
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(100*time.Millisecond, com.Msg(0, "CONSENSUS", "coordinator"))
+	s.Run(context.Background(), time.Second)
+
+	// Node 4 joins through node 3, every node joins the restarted election
+	n4 := s.AddNode(4, &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: map[uint]string{4: "n4:1"}, Registered: map[uint]bool{}})
+	n4.Register(NewControlExtension())
+	n4.Register(NewMembershipExtension("n3:1"))
+	n4.Register(NewConsensusExtension(2, 5, 2, 3))
+	assert.Nil(t, n4.(*handler).preflight(context.Background()))
+	s.Run(context.Background(), 3*time.Second)
+	for _, uid := range []uint{1, 2, 3} {
+		l := s.nodes[uid].ext["CONSENSUS"].(*consensus).leader
+		assert.Greater(t, l.epoch, 0, "node %d restarted the election", uid)
+		assert.Equal(t, uint(4), l.leaderUID, "node %d knows the new leader", uid)
+	}
+}
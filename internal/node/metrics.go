@@ -0,0 +1,99 @@
+package node
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Algorithm specific metrics; exposed on the metric endpoint of the node process
+var (
+	// Messages (label `direction` is `in` or `out`)
+	messagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vaa_messages_total",
+		Help: "Messages sent/received by this node per message type",
+	}, []string{"type", "direction"})
+
+	// Leader election (label `type` is the message type of the extension using the election)
+	leaderElectionDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vaa_leader_election_duration_seconds",
+		Help: "Time between the first participation in an election and knowing the leader",
+	}, []string{"type"})
+	leaderExploreTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vaa_leader_explore_total",
+		Help: "Explore messages sent/received during leader election",
+	}, []string{"type", "direction"})
+	leaderEchoTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vaa_leader_echo_total",
+		Help: "Echo messages sent/received during leader election",
+	}, []string{"type", "direction"})
+	leaderTreeDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vaa_leader_tree_depth",
+		Help: "Depth of this node in the spanning tree (leader = 0); max() over all nodes is the tree depth",
+	}, []string{"type"})
+	leaderIsLeader = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vaa_leader_is_leader",
+		Help: "1 if this node won the election",
+	}, []string{"type"})
+
+	// Rumor
+	rumorTimeToTrust = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "vaa_rumor_time_to_trust_seconds",
+		Help:    "Time between first receiving a rumor and trusting it",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	rumorTrustedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "vaa_rumor_trusted_total",
+		Help: "Number of rumors this node trusts",
+	})
+
+	// Consensus
+	consensusProposalsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vaa_consensus_proposals_total",
+		Help: "Proposals handled by this node",
+	}, []string{"result"})
+	consensusTK = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vaa_consensus_tk",
+		Help: "Current (final after termination) discrete time t_k of this node",
+	})
+	consensusDoubleCountingIterations = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vaa_consensus_double_counting_iterations",
+		Help: "State collections the coordinator needed until the double counting converged",
+	})
+	consensusAgreement = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vaa_consensus_agreement",
+		Help: "Collected result on the coordinator; 1 if all nodes agreed on t_k, 0 if not",
+	})
+
+	// Banking
+	bankingLockWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "vaa_banking_lock_wait_seconds",
+		Help:    "Time between requesting the lamport mutex and entering the critical section",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	bankingCriticalSection = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "vaa_banking_critical_section_seconds",
+		Help:    "Time spent in the critical section",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	bankingSnapshotDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "vaa_banking_snapshot_duration_seconds",
+		Help:    "Time between starting a consistent snapshot and receiving the states of all nodes (coordinator only)",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	bankingBalance = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vaa_banking_balance",
+		Help: "Current balance of this node",
+	})
+
+	// Failure detector
+	failureNeighbourStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vaa_failure_detector_status",
+		Help: "Status of a neighbour; 0 alive, 1 suspected, 2 dead",
+	}, []string{"neighbour"})
+
+	// SWIM
+	swimMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vaa_swim_members",
+		Help: "Members known by the SWIM membership protocol by status",
+	}, []string{"status"})
+)
@@ -2,7 +2,10 @@ package node
 
 import (
 	"context"
+	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/xvzf/vaa/pkg/com"
@@ -12,6 +15,16 @@ import (
 type Handler interface {
 	Run(context.Context, chan *com.Message) error
 	Register(Extension, string)
+	// Record writes every input of the node to a trace; has to be called before Run
+	Record(io.Writer)
+	// Replay feeds a recorded trace into the node instead of running it
+	Replay(context.Context, io.Reader) ([]*com.Message, error)
+	// Persist makes the extension state durable in dir and restores it; has to be called before Run
+	Persist(dir string, checkpointInterval time.Duration) error
+	// DetectFailures exchanges heartbeats with the neighbours and notifies extensions about failures; has to be called before Run
+	DetectFailures(interval, suspectAfter, deadAfter time.Duration)
+	// Reloadable sets how the neighbours are re-read on CONTROL RELOAD; has to be called before Run
+	Reloadable(load func() (*neigh.Neighs, error))
 }
 
 // handler holds internal information & datastructures for a node
@@ -21,17 +34,41 @@ type handler struct {
 	exit   context.CancelFunc
 	wg     sync.WaitGroup
 	ext    map[string]Extension
+
+	env    env               // side effects (transport, timers, randomness)
+	rec    *recorder         // optional trace recording
+	timers chan *timer       // fired timers, executed on the node loop
+	input  chan *com.Message // incoming messages of Run; internal input is fed back here, so it is recorded as well
+	done   chan struct{}
+
+	cuts map[string]*cut // consistent snapshots in progress, by id
+
+	watchers map[string]bool // addresses receiving events
+	events   chan *outgoing  // events waiting for delivery, see eventQueue
+
+	store              *store // optional durable state
+	checkpointInterval time.Duration
+	holding            bool        // sends are held until the state is synced
+	held               []*outgoing // messages sent while holding
+
+	fd *failureDetector // optional heartbeat failure detector
+
+	load func() (*neigh.Neighs, error) // optional neighbour source for RELOAD
 }
 
 func New(uid uint, exitFunc context.CancelFunc, neighs *neigh.Neighs) Handler {
 	// Init datastructures of the node
-	return &handler{
+	h := &handler{
 		uid:    uid,
 		exit:   exitFunc,
 		wg:     sync.WaitGroup{},
 		neighs: neighs,
 		ext:    make(map[string]Extension),
+		timers: make(chan *timer),
+		done:   make(chan struct{}),
 	}
+	h.env = newNetEnv(h)
+	return h
 }
 
 func (h *handler) Register(e Extension, t string) {
@@ -39,36 +76,124 @@ func (h *handler) Register(e Extension, t string) {
 	h.ext[t] = e
 }
 
-func (h *handler) Run(ctx context.Context, c chan *com.Message) error {
-	// Receive until context exits
-	log.Info().Uint("uid", h.uid).Msg("Starting node")
-	log.Info().Uint("uid", h.uid).Msgf("Registered neighbors: %s", h.neighs.Nodes)
+func (h *handler) Record(w io.Writer) {
+	log.Info().Uint("uid", h.uid).Msg("Recording node input")
+	h.rec = newRecorder(w)
+	h.rec.start(h.uid)
+}
+
+func (h *handler) Persist(dir string, checkpointInterval time.Duration) error {
+	log.Info().Uint("uid", h.uid).Msgf("Persisting state to %s", dir)
+	s, err := openStore(dir)
+	if err != nil {
+		return err
+	}
+	h.store = s
+	h.checkpointInterval = checkpointInterval
+	return nil
+}
+
+// preflight triggers the preflight hooks of all extensions in a stable order (keeps random draws reproducible)
+func (h *handler) preflight(ctx context.Context) error {
 	log.Info().Uint("uid", h.uid).Msgf("Triggering Preflights")
-	for msgType, ext := range h.ext {
-		if err := ext.Preflight(ctx, h); err != nil {
+	h.hold()
+	defer h.commit()
+	types := []string{}
+	for msgType := range h.ext {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	for _, msgType := range types {
+		if err := h.ext[msgType].Preflight(ctx, h); err != nil {
 			log.Err(err).Msgf("Failed initialising extension with message type %s", msgType)
-			h.exit()
 			return err
 		}
 	}
+	if h.fd != nil {
+		h.fd.start(h)
+	}
+	return nil
+}
+
+func (h *handler) Run(ctx context.Context, c chan *com.Message) error {
+	defer close(h.done)
+	h.input = c
+	// Receive until context exits
+	log.Info().Uint("uid", h.uid).Msg("Starting node")
+	log.Info().Uint("uid", h.uid).Msgf("Registered neighbors: %v", h.neighs.Nodes)
+	if err := h.preflight(ctx); err != nil {
+		h.exit()
+		return err
+	}
+	var checkpoint <-chan time.Time // periodic checkpoints, only for durable nodes
+	if h.store != nil {
+		t := time.NewTicker(h.checkpointInterval)
+		defer t.Stop()
+		checkpoint = t.C
+	}
 	for {
 		select {
 		case msg := <-c:
+			if h.rec != nil {
+				h.rec.message(msg)
+			}
 			if err := h.handle(msg); err != nil {
 				log.Err(err).Str("req_id", *msg.UUID).Msg("Failed handling incoming message")
 			}
+		case t := <-h.timers:
+			if h.rec != nil {
+				h.rec.timer(t.name)
+			}
+			h.fire(t)
+		case <-checkpoint:
+			if err := h.store.checkpoint(); err != nil {
+				log.Err(err).Uint("uid", h.uid).Msg("Failed to write checkpoint")
+			}
 		case <-ctx.Done():
 			h.wg.Wait()
+			if h.store != nil {
+				if err := h.store.close(); err != nil {
+					log.Err(err).Uint("uid", h.uid).Msg("Failed to write checkpoint")
+				}
+			}
 			log.Info().Uint("uid", h.uid).Msg("Node shutdown complete")
 			return nil
 		}
 	}
 }
 
+// fire executes a timer callback
+func (h *handler) fire(t *timer) {
+	h.wg.Add(1)
+	defer h.wg.Done()
+	h.hold()
+	defer h.commit()
+
+	log.Debug().Uint("uid", h.uid).Str("timer", t.name).Msg("Timer fired")
+	t.f()
+}
+
 // handle routes incoming messages to the corresponding handlers
 func (h *handler) handle(msg *com.Message) error {
+	messagesTotal.WithLabelValues(*msg.Type, "in").Inc()
+
+	// Mark processing start/end; this allows us to gracefully shutdown on context cancelation
+	h.wg.Add(1)
+	defer h.wg.Done()
+	h.hold()
+	defer h.commit()
+
+	// Heartbeats are consumed by the failure detector; neither logged nor recorded in snapshots
+	if *msg.Type == "HEARTBEAT" {
+		if h.fd != nil {
+			h.fd.heartbeat(h, *msg.SourceUID)
+		}
+		return nil
+	}
+
 	// Log incoming message (in addition to the dispatcher, as the dispatcher runs async and uses channels for interfacing with the node process)
 	log.Info().
+		Uint("uid", h.uid).
 		Str("msg_direction", "incoming").
 		Str("req_id", *msg.UUID).
 		Time("timestamp", *msg.Timestamp).
@@ -77,12 +202,13 @@ func (h *handler) handle(msg *com.Message) error {
 		Str("payload", *msg.Payload).
 		Msg("<<<")
 
-	// Mark processing start/end; this allows us to gracefully shutdown on context cancelation
-	h.wg.Add(1)
-	defer h.wg.Done()
-
 	log.Debug().Uint("uid", h.uid).Uint("src_uid", *msg.SourceUID).Str("req_id", *msg.UUID).Msg("Routing to correct handler")
 
+	// Channel recording for snapshots; control messages are not part of the algorithm state
+	if len(h.cuts) > 0 && *msg.Type != "CONTROL" {
+		h.recordCut(msg)
+	}
+
 	// Pass to extension
 	if e, ok := h.ext[*msg.Type]; ok {
 		return e.Handle(h, msg)
@@ -0,0 +1,196 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// traceEvent is a single line of a recorded trace
+type traceEvent struct {
+	Kind  string       `json:"kind"` // start, message, timer, rand or now
+	Time  time.Time    `json:"time"`
+	UID   uint         `json:"uid,omitempty"`
+	Msg   *com.Message `json:"msg,omitempty"`
+	Timer string       `json:"timer,omitempty"`
+	N     int          `json:"n,omitempty"`
+	Value int          `json:"value"`
+	Now   *time.Time   `json:"now,omitempty"`
+}
+
+// recorder writes every input of a node (incoming messages, timer firings, random draws, clock readings) to a trace
+type recorder struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+func newRecorder(w io.Writer) *recorder {
+	return &recorder{enc: json.NewEncoder(w)}
+}
+
+func (r *recorder) write(e *traceEvent) {
+	r.Lock()
+	defer r.Unlock()
+	e.Time = time.Now().UTC()
+	if err := r.enc.Encode(e); err != nil {
+		log.Err(err).Msg("Failed to record trace event")
+	}
+}
+
+func (r *recorder) start(uid uint) {
+	r.write(&traceEvent{Kind: "start", UID: uid})
+}
+
+func (r *recorder) message(msg *com.Message) {
+	r.write(&traceEvent{Kind: "message", Msg: msg})
+}
+
+func (r *recorder) timer(name string) {
+	r.write(&traceEvent{Kind: "timer", Timer: name})
+}
+
+func (r *recorder) rand(n, v int) {
+	r.write(&traceEvent{Kind: "rand", N: n, Value: v})
+}
+
+func (r *recorder) now(t time.Time) {
+	r.write(&traceEvent{Kind: "now", Now: &t})
+}
+
+// replayEnv feeds recorded random draws, clock readings and timers back into the node; outgoing messages are captured
+// instead of transmitted
+type replayEnv struct {
+	dec      *json.Decoder
+	index    int                 // number of consumed trace events, handy for conditional breakpoints
+	pending  map[string][]func() // scheduled timers by name
+	captured []*com.Message
+	clock    time.Time // time of the last consumed event
+	err      error     // divergence between the trace and the execution
+}
+
+func newReplayEnv(r io.Reader) *replayEnv {
+	return &replayEnv{
+		dec:     json.NewDecoder(r),
+		pending: map[string][]func(){},
+	}
+}
+
+// next returns the next event of the trace, nil at the end
+func (e *replayEnv) next() (*traceEvent, error) {
+	ev := &traceEvent{}
+	if err := e.dec.Decode(ev); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	e.index = e.index + 1
+	e.clock = ev.Time
+	return ev, nil
+}
+
+func (e *replayEnv) diverged(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+	log.Err(err).Int("trace_index", e.index).Msg("Replay diverged from the recorded trace")
+}
+
+func (e *replayEnv) send(target string, msg *com.Message) error {
+	e.captured = append(e.captured, msg)
+	log.Info().
+		Str("msg_direction", "captured").
+		Uint("src_uid", *msg.SourceUID).
+		Str("type", *msg.Type).
+		Str("payload", *msg.Payload).
+		Msgf(">>> %s", target)
+	return nil
+}
+
+func (e *replayEnv) after(d time.Duration, name string, f func()) {
+	e.pending[name] = append(e.pending[name], f)
+}
+
+func (e *replayEnv) intn(n int) int {
+	ev, err := e.next()
+	if err != nil {
+		e.diverged(err)
+		return 0
+	}
+	if ev == nil || ev.Kind != "rand" || ev.N != n {
+		e.diverged(fmt.Errorf("expected random draw [0,%d), trace has %+v", n, ev))
+		return 0
+	}
+	return ev.Value
+}
+
+func (e *replayEnv) now() time.Time {
+	ev, err := e.next()
+	if err != nil {
+		e.diverged(err)
+		return e.clock
+	}
+	if ev == nil || ev.Kind != "now" || ev.Now == nil {
+		e.diverged(fmt.Errorf("expected clock reading, trace has %+v", ev))
+		return e.clock
+	}
+	return *ev.Now
+}
+
+// Replay feeds a recorded trace into this node; the execution is synchronous, outgoing messages are captured
+func (h *handler) Replay(ctx context.Context, r io.Reader) ([]*com.Message, error) {
+	e := newReplayEnv(r)
+	h.env = e
+	h.rec = nil
+
+	// The trace starts with the node UID
+	ev, err := e.next()
+	if err != nil {
+		return nil, err
+	}
+	if ev == nil || ev.Kind != "start" {
+		return nil, errors.New("trace does not start with a start event")
+	} else if ev.UID != h.uid {
+		return nil, fmt.Errorf("trace recorded for node %d, not %d", ev.UID, h.uid)
+	}
+
+	log.Info().Uint("uid", h.uid).Msg("Replaying trace")
+	if err := h.preflight(ctx); err != nil {
+		return e.captured, err
+	}
+
+	for e.err == nil {
+		ev, err := e.next()
+		if err != nil {
+			return e.captured, err
+		} else if ev == nil {
+			break
+		}
+
+		switch ev.Kind {
+		case "message":
+			if err := h.handle(ev.Msg); err != nil {
+				log.Err(err).Int("trace_index", e.index).Msg("Failed handling replayed message")
+			}
+		case "timer":
+			fs := e.pending[ev.Timer]
+			if len(fs) == 0 {
+				e.diverged(fmt.Errorf("timer %s fired but was never scheduled", ev.Timer))
+				continue
+			}
+			e.pending[ev.Timer] = fs[1:]
+			h.fire(&timer{name: ev.Timer, f: fs[0]})
+		default:
+			e.diverged(fmt.Errorf("unexpected %s event", ev.Kind))
+		}
+	}
+
+	log.Info().Uint("uid", h.uid).Int("events", e.index).Int("captured", len(e.captured)).Msg("Replay complete")
+	return e.captured, e.err
+}
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// TopologyListener is implemented by extensions adapting to neighbours added or removed at runtime
+type TopologyListener interface {
+	// TopologyChanged is called on the node loop after the neighbours were replaced
+	TopologyChanged(h *handler, added, removed []uint)
+}
+
+func (h *handler) Reloadable(load func() (*neigh.Neighs, error)) {
+	h.load = load
+}
+
+// reload re-reads the neighbours and applies the difference; added neighbours are greeted with HELLO
+func (h *handler) reload() error {
+	if h.load == nil {
+		return errors.New("node is not reloadable")
+	}
+	n, err := h.load()
+	if err != nil {
+		return err
+	}
+	added, removed := neighDiff(h.neighs.Nodes, n.Nodes)
+
+	for _, uid := range removed {
+		delete(h.neighs.Nodes, uid)
+		delete(h.neighs.Registered, uid)
+		if h.fd != nil {
+			h.fd.forget(uid)
+		}
+	}
+	for _, uid := range added {
+		h.neighs.Nodes[uid] = n.Nodes[uid]
+		h.neighs.Registered[uid] = false
+		if _, in := n.Incoming()[uid]; in && h.fd != nil {
+			h.fd.watch(h, uid)
+		}
+		if err := h.send(n.Nodes[uid], com.Msg(h.uid, "DISCOVERY", "HELLO")); err != nil {
+			log.Err(err).Uint("uid", h.uid).Msgf("Failed sending HELLO to %d", uid)
+		}
+	}
+	h.neighs.In = n.In
+	h.neighs.Links = n.Links
+	// The view is replaced as well; it might be shared with other nodes (simulation)
+	all := map[uint]string{h.uid: h.neighs.AllNodes[h.uid]}
+	for uid, addr := range n.AllNodes {
+		all[uid] = addr
+	}
+	h.neighs.AllNodes = all
+
+	log.Info().Uint("uid", h.uid).Msgf("Reloaded neighbours, added %v, removed %v", added, removed)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	types := []string{}
+	for msgType := range h.ext {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	for _, msgType := range types {
+		if l, ok := h.ext[msgType].(TopologyListener); ok {
+			l.TopologyChanged(h, added, removed)
+		}
+	}
+	return nil
+}
+
+// neighDiff returns the neighbours only in next (added) and only in prev (removed); a changed address counts as both
+func neighDiff(prev, next map[uint]string) (added, removed []uint) {
+	added, removed = []uint{}, []uint{}
+	for _, uid := range sortedUIDs(next) {
+		if addr, ok := prev[uid]; !ok || addr != next[uid] {
+			added = append(added, uid)
+		}
+	}
+	for _, uid := range sortedUIDs(prev) {
+		if addr, ok := next[uid]; !ok || addr != prev[uid] {
+			removed = append(removed, uid)
+		}
+	}
+	return added, removed
+}
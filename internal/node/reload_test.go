@@ -0,0 +1,91 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// topologyRecorder records the neighbour changes it is notified about
+type topologyRecorder struct {
+	added, removed []uint
+}
+
+func (r *topologyRecorder) Handle(h *handler, msg *com.Message) error       { return nil }
+func (r *topologyRecorder) Preflight(ctx context.Context, h *handler) error { return nil }
+func (r *topologyRecorder) TopologyChanged(h *handler, added, removed []uint) {
+	r.added, r.removed = added, removed
+}
+
+func TestReload(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}}}, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+	})
+	r := &topologyRecorder{}
+	s.nodes[1].Register(r, "TEST")
+	s.nodes[1].Reloadable(func() (*neigh.Neighs, error) {
+		return neigh.NeighsFor(1, c, &neigh.NeighMap{Neighs: map[uint][]uint{1: {3}, 2: {3}}}), nil
+	})
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+	s.Run(context.Background(), time.Second)
+
+	s.Inject(0, 1, com.Msg(0, "CONTROL", "RELOAD"))
+	s.Run(context.Background(), 2*time.Second)
+	assert.Equal(t, []uint{3}, r.added)
+	assert.Equal(t, []uint{2}, r.removed)
+	assert.Equal(t, map[uint]string{3: "n3:1"}, s.nodes[1].neighs.Nodes)
+	assert.True(t, s.nodes[3].neighs.Registered[1], "added neighbour was greeted")
+	assert.NotNil(t, s.nodes[2].reload(), "node without neighbour source")
+}
+
+func TestNeighDiff(t *testing.T) {
+	added, removed := neighDiff(map[uint]string{1: "a", 2: "b", 3: "c"}, map[uint]string{2: "b", 3: "x", 4: "d"})
+	assert.Equal(t, []uint{3, 4}, added)
+	assert.Equal(t, []uint{1, 3}, removed)
+}
+
+func TestReload_election(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1", 4: "n4:1"}}
+	line := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}, 3: {4}}}
+	chord := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2, 3}, 2: {3}, 3: {4}}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, line, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+		h.Register(NewDistributedBankingExtension())
+		h.Register(NewConsensusExtension(3, 5, 2, 3))
+	})
+	for _, uid := range s.UIDs() {
+		uid := uid
+		s.nodes[uid].Reloadable(func() (*neigh.Neighs, error) { return neigh.NeighsFor(uid, c, chord), nil })
+	}
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+	s.InjectAll(100*time.Millisecond, com.Msg(0, "BANKING", "coordinator"))
+	s.InjectAll(100*time.Millisecond, com.Msg(0, "CONSENSUS", "coordinator"))
+	s.Run(context.Background(), 2*time.Second)
+
+	// Only nodes 1 and 3 get a new neighbour, all nodes join the new election where every node is a candidate
+	s.InjectAll(0, com.Msg(0, "CONTROL", "RELOAD"))
+	s.Run(context.Background(), 4*time.Second)
+	for _, uid := range s.UIDs() {
+		for _, msgType := range []string{"BANKING", "CONSENSUS"} {
+			var l *Leader
+			if msgType == "BANKING" {
+				l = s.nodes[uid].ext[msgType].(*banking).leader
+			} else {
+				l = s.nodes[uid].ext[msgType].(*consensus).leader
+			}
+			assert.Equal(t, uint(4), l.leaderUID, "node %d knows the %s leader", uid, msgType)
+			assert.Greater(t, l.epoch, 0, "node %d restarted the %s election", uid, msgType)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package node
 import (
 	"context"
 	"errors"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/xvzf/vaa/pkg/com"
@@ -16,6 +18,8 @@ type rumor struct {
 	sync.Mutex
 	counter       map[string]int
 	trustedRumors map[string]bool
+	firstSeen     map[string]time.Time // used for the time-to-trust metric
+	timeToTrust   map[string]float64   // seconds between first receiving and trusting a rumor; the metric has no rumor label
 }
 
 // NewRumorExtension returns the rumor handler + the message type
@@ -23,11 +27,13 @@ func NewRumorExtension() (Extension, string) {
 	return &rumor{
 		counter:       make(map[string]int),
 		trustedRumors: make(map[string]bool),
+		firstSeen:     make(map[string]time.Time),
+		timeToTrust:   make(map[string]float64),
 	}, "RUMOR"
 }
 
 // Add adds a new or existing rumor to the datastructure. It returns the number of registered rumors
-func (r *rumor) add(rumor string) int {
+func (r *rumor) add(h *handler, rumor string) int {
 	r.Lock()
 	defer r.Unlock()
 
@@ -36,19 +42,82 @@ func (r *rumor) add(rumor string) int {
 		r.counter[rumor] = v + 1
 	} else {
 		r.counter[rumor] = 1
+		r.firstSeen[rumor] = h.now()
 	}
 
 	return r.counter[rumor]
 }
 
-func (r *rumor) trusted(rumor string) {
+// rumorState is the durable state of a single rumor
+type rumorState struct {
+	Counter     int       `json:"counter"`
+	Trusted     bool      `json:"trusted"`
+	FirstSeen   time.Time `json:"first_seen,omitempty"`
+	TimeToTrust float64   `json:"time_to_trust,omitempty"`
+}
+
+// persist writes the state of a rumor
+func (r *rumor) persist(h *handler, rumor string) {
+	r.Lock()
+	s := &rumorState{Counter: r.counter[rumor], Trusted: r.trustedRumors[rumor], FirstSeen: r.firstSeen[rumor], TimeToTrust: r.timeToTrust[rumor]}
+	r.Unlock()
+	h.persist("RUMOR/"+rumor, s)
+}
+
+// trusted marks a rumor as trusted and returns the time to trust in seconds; false if the rumor was first seen before a
+// restart and the state did not record when
+func (r *rumor) trusted(h *handler, rumor string) (float64, bool) {
 	r.Lock()
 	defer r.Unlock()
 	r.trustedRumors[rumor] = true
+	rumorTrustedTotal.Inc()
+	firstSeen, ok := r.firstSeen[rumor]
+	if !ok || firstSeen.IsZero() {
+		return 0, false
+	}
+	r.timeToTrust[rumor] = h.now().Sub(firstSeen).Seconds()
+	rumorTimeToTrust.Observe(r.timeToTrust[rumor])
+	return r.timeToTrust[rumor], true
+}
+
+// Inspect exposes the rumor counters, the trusted rumors and their time to trust
+func (r *rumor) Inspect() map[string]interface{} {
+	r.Lock()
+	defer r.Unlock()
+	counter := map[string]int{}
+	for rm, c := range r.counter {
+		counter[rm] = c
+	}
+	trusted := []string{}
+	for rm := range r.trustedRumors {
+		trusted = append(trusted, rm)
+	}
+	sort.Strings(trusted)
+	timeToTrust := map[string]float64{}
+	for rm, d := range r.timeToTrust {
+		timeToTrust[rm] = d
+	}
+	return map[string]interface{}{"counter": counter, "trusted": trusted, "time_to_trust": timeToTrust}
 }
 
+// Preflight restores the rumors seen before a restart
 func (r *rumor) Preflight(ctx context.Context, h *handler) error {
-	// not required
+	r.Lock()
+	defer r.Unlock()
+	for _, key := range h.restoreKeys("RUMOR/") {
+		s := &rumorState{}
+		if h.restore(key, s) {
+			rm := strings.TrimPrefix(key, "RUMOR/")
+			r.counter[rm] = s.Counter
+			r.trustedRumors[rm] = s.Trusted
+			if !s.FirstSeen.IsZero() {
+				r.firstSeen[rm] = s.FirstSeen
+			}
+			if s.Trusted && s.TimeToTrust > 0 {
+				r.timeToTrust[rm] = s.TimeToTrust
+			}
+		}
+	}
 	return nil
 }
 
@@ -70,12 +139,13 @@ func (r *rumor) Handle(h *handler, msg *com.Message) error {
 	}
 
 	// Increase rumor counter
-	s := r.add(rm)
+	s := r.add(h, rm)
 
 	// Seen this rumor the first time -> distribute
 	if s == 1 {
 		msgProgatate := com.MsgPropagate(h.uid, msg)
-		for nuid, netaddr := range h.neighs.Nodes {
+		for _, nuid := range sortedUIDs(h.neighs.Nodes) {
+			netaddr := h.neighs.Nodes[nuid]
 			if nuid == *msg.SourceUID {
 				// Skip sending the event to receiving edge
 				continue
@@ -83,7 +153,7 @@ func (r *rumor) Handle(h *handler, msg *com.Message) error {
 
 			// Propagate to neighbor
 			log.Info().Uint("uid", h.uid).Msgf("Propagating rumor `%s` to %d", *msgProgatate.Payload, nuid)
-			if err := com.Send(netaddr, msgProgatate); err != nil {
+			if err := h.send(netaddr, msgProgatate); err != nil {
 				log.Err(err).Uint("uid", h.uid).Msgf("Failed Rumor %s to %d", *msgProgatate.Payload, nuid)
 			}
 		}
@@ -93,12 +163,17 @@ func (r *rumor) Handle(h *handler, msg *com.Message) error {
 		Msgf("Counter increased")
 
 	if s == c { // Initially trusted
-		r.trusted(rm)
+		fields := map[string]interface{}{"rumor": rm, "seen": s}
+		if d, ok := r.trusted(h, rm); ok {
+			fields["time_to_trust"] = d
+		}
 		log.Info().Uint("uid", h.uid).Str("rumor", rm).Int("seen", s).
 			Msgf("Now trusted")
+		h.emit(EventRumorTrusted, fields)
 	} else if s > c { // Already trusted
 		log.Debug().Uint("uid", h.uid).Str("rumor", rm).Int("seen", s).
 			Msgf("Trusted since %d shares", s-c)
 	}
+	r.persist(h, rm)
 	return nil
 }
@@ -0,0 +1,328 @@
+package node
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// simEvent is a scheduled message delivery or timer firing in virtual time
+type simEvent struct {
+	at  time.Time
+	seq uint64 // tie breaker, keeps the execution deterministic
+	f   func()
+}
+
+// simQueue is a priority queue of events ordered by virtual time
+type simQueue []*simEvent
+
+func (q simQueue) Len() int { return len(q) }
+func (q simQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q simQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x interface{}) { *q = append(*q, x.(*simEvent)) }
+func (q *simQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// SimStats summarises a simulation run
+type SimStats struct {
+	Nodes        int            `json:"nodes"`
+	Events       int            `json:"events"`
+	Messages     int            `json:"messages"`
+	MessagesType map[string]int `json:"messages_type"`
+	LastMessage  map[string]int `json:"last_message_ms"` // virtual time of the last delivered message per type
+	VirtualTime  time.Duration  `json:"virtual_time_ns"`
+	WallTime     time.Duration  `json:"wall_time_ns"`
+	Violations   []*Violation   `json:"violations,omitempty"` // first violation of every invariant
+}
+
+// Simulation runs the extensions of many nodes in a single process on virtual time; there are no sockets or sleeps involved
+type Simulation struct {
+	start   time.Time
+	clock   time.Time
+	seq     uint64
+	queue   simQueue
+	rand    *rand.Rand
+	latency time.Duration
+	jitter  time.Duration
+
+	nodes   map[uint]*handler
+	addrs   map[string]uint       // connect string -> UID
+	links   map[[2]uint]time.Time // last delivery per link; keeps channels FIFO
+	stopped map[uint]bool
+	stats   *SimStats
+
+	// Invariants checked while running
+	invariants []*Invariant
+	checkEvery time.Duration
+	nextCheck  time.Time
+	violated   map[string]bool
+}
+
+// NewSimulation constructs an empty simulation; every message is delayed by latency plus a random jitter
+func NewSimulation(seed int64, latency, jitter time.Duration) *Simulation {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &Simulation{
+		start:   start,
+		clock:   start,
+		rand:    rand.New(rand.NewSource(seed)),
+		latency: latency,
+		jitter:  jitter,
+		nodes:   map[uint]*handler{},
+		addrs:   map[string]uint{},
+		links:   map[[2]uint]time.Time{},
+		stopped: map[uint]bool{},
+		stats:   &SimStats{MessagesType: map[string]int{}, LastMessage: map[string]int{}},
+
+		violated: map[string]bool{},
+	}
+}
+
+// AddNode adds a simulated node; extensions are registered on the returned handler
+func (s *Simulation) AddNode(uid uint, neighs *neigh.Neighs) Handler {
+	h := &handler{
+		uid:    uid,
+		neighs: neighs,
+		ext:    make(map[string]Extension),
+	}
+	h.exit = func() { s.stopped[uid] = true }
+	h.env = &simEnv{s: s, h: h}
+	s.nodes[uid] = h
+	if addr, ok := neighs.AllNodes[uid]; ok {
+		s.addrs[addr] = uid
+	}
+	return h
+}
+
+// AddNodes adds all nodes of a config + graph; register is called for every node to set up its extensions
+func (s *Simulation) AddNodes(c *neigh.Config, nm *neigh.NeighMap, register func(Handler)) {
+	// Build the adjacency once instead of scanning the graph for every node
+	adjacent, incoming := map[uint][]uint{}, map[uint][]uint{}
+	for a, v := range nm.Neighs {
+		for _, b := range v {
+			adjacent[a] = append(adjacent[a], b)
+			if nm.Directed {
+				incoming[b] = append(incoming[b], a)
+			} else {
+				adjacent[b] = append(adjacent[b], a)
+			}
+		}
+	}
+	for _, uid := range sortedUIDs(c.Nodes) {
+		n := &neigh.Neighs{Nodes: map[uint]string{}, Links: map[uint]neigh.EdgeAttrs{}, AllNodes: c.Nodes, Registered: map[uint]bool{}}
+		for _, b := range adjacent[uid] {
+			n.Nodes[b] = c.Nodes[b]
+			n.Links[b] = nm.Attr(uid, b)
+			if !nm.Directed {
+				n.Registered[b] = false
+			}
+		}
+		if nm.Directed {
+			// Same as neigh.NeighsFor; in-neighbours register themselves
+			n.In = map[uint]string{}
+			for _, a := range incoming[uid] {
+				n.In[a] = c.Nodes[a]
+				n.Links[a] = nm.Attr(a, uid)
+				n.Registered[a] = false
+			}
+		}
+		register(s.AddNode(uid, n))
+	}
+}
+
+// schedule adds an event at the given virtual time
+func (s *Simulation) schedule(at time.Time, f func()) {
+	s.seq = s.seq + 1
+	heap.Push(&s.queue, &simEvent{at: at, seq: s.seq, f: f})
+}
+
+// Inject delivers a message from outside of the cluster (like the client does) after d
+func (s *Simulation) Inject(d time.Duration, uid uint, msg *com.Message) {
+	cp := s.transmit(msg)
+	s.schedule(s.clock.Add(d), func() { s.deliver(uid, cp) })
+}
+
+// transmit copies a message and assigns a request id, like com.Send does
+func (s *Simulation) transmit(msg *com.Message) *com.Message {
+	s.seq = s.seq + 1
+	id := fmt.Sprintf("%08x", s.seq)
+	ts := s.clock
+	return &com.Message{
+		UUID:      &id,
+		Timestamp: &ts,
+		SourceUID: com.UintPointer(*msg.SourceUID),
+		Type:      com.StrPointer(*msg.Type),
+		Payload:   com.StrPointer(*msg.Payload),
+	}
+}
+
+// InjectAll delivers a message to all nodes after d
+func (s *Simulation) InjectAll(d time.Duration, msg *com.Message) {
+	for _, uid := range s.UIDs() {
+		s.Inject(d, uid, msg)
+	}
+}
+
+// UIDs returns all simulated node UIDs in ascending order
+func (s *Simulation) UIDs() []uint {
+	uids := []uint{}
+	for uid := range s.nodes {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// Now returns the elapsed virtual time
+func (s *Simulation) Now() time.Duration {
+	return s.clock.Sub(s.start)
+}
+
+func (s *Simulation) deliver(uid uint, msg *com.Message) {
+	h, ok := s.nodes[uid]
+	if !ok || s.stopped[uid] {
+		return
+	}
+	s.stats.Messages = s.stats.Messages + 1
+	s.stats.MessagesType[*msg.Type] = s.stats.MessagesType[*msg.Type] + 1
+	s.stats.LastMessage[*msg.Type] = int(s.Now().Milliseconds())
+	if err := h.handle(msg); err != nil {
+		log.Err(err).Uint("uid", uid).Str("req_id", *msg.UUID).Msg("Failed handling incoming message")
+	}
+}
+
+// Check evaluates invariants every interval of virtual time (after every event if 0); eventually invariants are evaluated at the end of Run
+func (s *Simulation) Check(interval time.Duration, invs ...*Invariant) {
+	s.invariants = append(s.invariants, invs...)
+	s.checkEvery = interval
+	s.nextCheck = s.clock
+}
+
+// States inspects all simulated nodes
+func (s *Simulation) States() map[uint]*NodeState {
+	states := map[uint]*NodeState{}
+	for uid, h := range s.nodes {
+		states[uid] = h.inspect()
+	}
+	return states
+}
+
+// check evaluates the invariants and records the first violation of each
+func (s *Simulation) check(final bool) {
+	for _, v := range CheckInvariants(s.States(), s.invariants, final) {
+		if s.violated[v.Invariant] {
+			continue
+		}
+		s.violated[v.Invariant] = true
+		log.Warn().Str("invariant", v.Invariant).Dur("virtual_time", s.Now()).Msgf("Invariant violated: %s", v.Error)
+		s.stats.Violations = append(s.stats.Violations, v)
+	}
+}
+
+// Start runs the preflight hooks of all nodes
+func (s *Simulation) Start(ctx context.Context) error {
+	for _, uid := range s.UIDs() {
+		if err := s.nodes[uid].preflight(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run processes events until there are none left, the virtual time exceeds until or the context is canceled
+func (s *Simulation) Run(ctx context.Context, until time.Duration) *SimStats {
+	wallStart := time.Now()
+	deadline := s.start.Add(until)
+	for s.queue.Len() > 0 {
+		if s.stats.Events%10000 == 0 && ctx.Err() != nil {
+			break
+		}
+		e := heap.Pop(&s.queue).(*simEvent)
+		if e.at.After(deadline) {
+			heap.Push(&s.queue, e) // keep it, Run can be resumed
+			s.clock = deadline
+			break
+		}
+		s.clock = e.at
+		s.stats.Events = s.stats.Events + 1
+		e.f()
+
+		if len(s.invariants) > 0 && !s.clock.Before(s.nextCheck) {
+			s.check(false)
+			s.nextCheck = s.clock.Add(s.checkEvery)
+		}
+	}
+	if len(s.invariants) > 0 {
+		s.check(true)
+	}
+
+	s.stats.Nodes = len(s.nodes)
+	s.stats.VirtualTime = s.Now()
+	s.stats.WallTime = s.stats.WallTime + time.Since(wallStart)
+	return s.stats
+}
+
+// simEnv routes the side effects of a node through the simulation
+type simEnv struct {
+	s *Simulation
+	h *handler
+}
+
+func (e *simEnv) send(target string, msg *com.Message) error {
+	uid, ok := e.s.addrs[target]
+	if !ok {
+		return fmt.Errorf("unknown target %s", target)
+	}
+
+	// Copy the message; extensions reuse the same message for multiple targets
+	cp := e.s.transmit(msg)
+
+	// Links with latency or bandwidth replace the global latency
+	at := e.s.clock.Add(e.s.latency)
+	if d := e.h.neighs.Link(uid).Delay(len(*msg.Payload)); d > 0 {
+		at = e.s.clock.Add(d)
+	}
+	if e.s.jitter > 0 {
+		at = at.Add(time.Duration(e.s.rand.Int63n(int64(e.s.jitter))))
+	}
+	// FIFO channels: never overtake the previous message on the same link
+	link := [2]uint{e.h.uid, uid}
+	if last, ok := e.s.links[link]; ok && at.Before(last) {
+		at = last
+	}
+	e.s.links[link] = at
+
+	e.s.schedule(at, func() { e.s.deliver(uid, cp) })
+	return nil
+}
+
+func (e *simEnv) after(d time.Duration, name string, f func()) {
+	e.s.schedule(e.s.clock.Add(d), func() {
+		if !e.s.stopped[e.h.uid] {
+			e.h.fire(&timer{name: name, f: f})
+		}
+	})
+}
+
+func (e *simEnv) intn(n int) int {
+	return e.s.rand.Intn(n)
+}
+
+func (e *simEnv) now() time.Time {
+	return e.s.clock
+}
@@ -0,0 +1,30 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func TestSimulation_linkLatency(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	nm := &neigh.NeighMap{Neighs: map[uint][]uint{1: {2, 3}}}
+	nm.SetAttr(1, 3, neigh.EdgeAttrs{Weight: 1, Latency: 200 * time.Millisecond})
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, nm, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+	})
+	assert.Nil(t, s.Start(context.Background()))
+	s.Inject(0, 1, com.Msg(0, "CONTROL", "STARTUP"))
+
+	s.Run(context.Background(), 100*time.Millisecond)
+	assert.True(t, s.nodes[2].neighs.Registered[1], "default latency")
+	assert.False(t, s.nodes[3].neighs.Registered[1], "HELLO still on the slow link")
+	s.Run(context.Background(), 300*time.Millisecond)
+	assert.True(t, s.nodes[3].neighs.Registered[1])
+}
@@ -0,0 +1,283 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	walFile        = "wal.jsonl"
+	checkpointFile = "checkpoint.json"
+)
+
+// walEntry is a single line of the write-ahead log; later entries of a key supersede earlier ones
+type walEntry struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+}
+
+// store persists extension state as key/value pairs in a write-ahead log; checkpoints compact the log
+type store struct {
+	sync.Mutex
+	dir    string
+	state  map[string]json.RawMessage
+	wal    *os.File
+	writes int  // entries written since the last checkpoint
+	dirty  bool // entries written since the last sync
+}
+
+// openStore restores the state from the last checkpoint and the write-ahead log of dir
+func openStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &store{dir: dir, state: map[string]json.RawMessage{}}
+
+	// Last checkpoint
+	b, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &s.state); err != nil {
+			return nil, err
+		}
+	}
+
+	// Entries written after the checkpoint
+	s.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(s.wal)
+	replayed := 0
+	for {
+		e := &walEntry{}
+		if err := dec.Decode(e); err == io.EOF {
+			break
+		} else if err != nil {
+			// A crash while appending leaves a torn last entry, it was never acknowledged
+			log.Warn().Err(err).Str("dir", dir).Msgf("Discarding write-ahead log after %d entries", replayed)
+			break
+		}
+		if e.Deleted {
+			delete(s.state, e.Key)
+		} else {
+			s.state[e.Key] = e.Value
+		}
+		replayed++
+	}
+	log.Info().Str("dir", dir).Msgf("Restored %d keys, replayed %d log entries", len(s.state), replayed)
+
+	// Start with a clean log; this also drops a torn entry
+	return s, s.checkpoint()
+}
+
+// put appends the value to the write-ahead log; it is durable once the log is synced
+func (s *store) put(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := s.append(&walEntry{Key: key, Value: b}); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.state[key] = b
+	return nil
+}
+
+// delete removes the key, the next checkpoint drops it
+func (s *store) delete(key string) error {
+	if err := s.append(&walEntry{Key: key, Deleted: true}); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	delete(s.state, key)
+	return nil
+}
+
+func (s *store) append(e *walEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, err := s.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.writes++
+	s.dirty = true
+	return nil
+}
+
+// sync flushes the entries written since the last sync to disk
+func (s *store) sync() error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// get decodes the value of key into v, false if the key was never written
+func (s *store) get(key string, v interface{}) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.state[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+// keys returns all keys with the prefix in ascending order
+func (s *store) keys(prefix string) []string {
+	s.Lock()
+	defer s.Unlock()
+	keys := []string{}
+	for k := range s.state {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkpoint writes the full state and truncates the write-ahead log
+func (s *store) checkpoint() error {
+	s.Lock()
+	defer s.Unlock()
+	b, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+
+	// Replace the checkpoint atomically; replaying the log on top of the new checkpoint is harmless if we crash before truncating
+	tmp := filepath.Join(s.dir, checkpointFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, checkpointFile)); err != nil {
+		return err
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	log.Debug().Str("dir", s.dir).Msgf("Checkpoint written, compacted %d log entries", s.writes)
+	s.writes = 0
+	s.dirty = false
+	return nil
+}
+
+// close writes a final checkpoint
+func (s *store) close() error {
+	if err := s.checkpoint(); err != nil {
+		s.wal.Close()
+		return err
+	}
+	return s.wal.Close()
+}
+
+// persist writes the value of key to the write-ahead log of the node; no-op if the node is not durable
+func (h *handler) persist(key string, v interface{}) {
+	if h.store == nil {
+		return
+	}
+	if err := h.store.put(key, v); err != nil {
+		log.Err(err).Uint("uid", h.uid).Str("key", key).Msg("Failed to persist state")
+	}
+}
+
+// forget removes the persisted value of key; no-op if the node is not durable
+func (h *handler) forget(key string) {
+	if h.store == nil {
+		return
+	}
+	if err := h.store.delete(key); err != nil {
+		log.Err(err).Uint("uid", h.uid).Str("key", key).Msg("Failed to forget state")
+	}
+}
+
+// hold defers the messages sent by a durable node until commit; the state changes causing them are written ahead
+func (h *handler) hold() {
+	h.holding = h.store != nil
+}
+
+// commit syncs the write-ahead log once for all state changes of the handled input and sends the held messages; send
+// errors can no longer be returned to the extension and are logged
+func (h *handler) commit() {
+	if !h.holding {
+		return
+	}
+	h.holding = false
+	if err := h.store.sync(); err != nil {
+		log.Err(err).Uint("uid", h.uid).Msg("Failed to sync the write-ahead log")
+	}
+	held := h.held
+	h.held = nil
+	for _, m := range held {
+		if m.event {
+			h.deliverEvent(m)
+			continue
+		}
+		if err := h.env.send(m.target, m.msg); err != nil {
+			log.Err(err).Uint("uid", h.uid).Msgf("Failed sending held message to %s", m.target)
+		}
+	}
+}
+
+// restore loads the persisted value of key into v; false if the node is not durable or the key was never written
+func (h *handler) restore(key string, v interface{}) bool {
+	if h.store == nil {
+		return false
+	}
+	ok, err := h.store.get(key, v)
+	if err != nil {
+		log.Err(err).Uint("uid", h.uid).Str("key", key).Msg("Failed to restore state")
+		return false
+	}
+	if ok {
+		log.Debug().Uint("uid", h.uid).Str("key", key).Msg("Restored state")
+	}
+	return ok
+}
+
+// restoreKeys returns the persisted keys with the prefix, e.g. to restore per-message state
+func (h *handler) restoreKeys(prefix string) []string {
+	if h.store == nil {
+		return nil
+	}
+	return h.store.keys(prefix)
+}
@@ -0,0 +1,155 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+func TestStore_recovery(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.put("BANKING/state", &bankingDurable{Balance: 10}))
+	assert.Nil(t, s.checkpoint())
+	assert.Nil(t, s.put("BANKING/state", &bankingDurable{Balance: 20, LamportClock: 3}))
+	assert.Nil(t, s.put("BANKING/known/a", true))
+	s.wal.Close() // crash, no final checkpoint
+
+	// Torn entry of an interrupted append
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.Nil(t, err)
+	f.WriteString(`{"key":"BANKING/state","val`)
+	f.Close()
+
+	s, err = openStore(dir)
+	assert.Nil(t, err)
+	defer s.close()
+	b := &bankingDurable{}
+	ok, err := s.get("BANKING/state", b)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, &bankingDurable{Balance: 20, LamportClock: 3}, b, "log is replayed on top of the checkpoint")
+	assert.Equal(t, []string{"BANKING/known/a"}, s.keys("BANKING/known/"))
+
+	ok, _ = s.get("RUMOR/x", &rumorState{})
+	assert.False(t, ok)
+}
+
+func TestStore_delete(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.put("BANKING/known/a", true))
+	assert.Nil(t, s.put("BANKING/known/b", true))
+	assert.Nil(t, s.checkpoint())
+	assert.Nil(t, s.delete("BANKING/known/a"))
+	assert.Nil(t, s.sync())
+	s.wal.Close() // crash, the deletion is only in the log
+
+	s, err = openStore(dir)
+	assert.Nil(t, err)
+	defer s.close()
+	assert.Equal(t, []string{"BANKING/known/b"}, s.keys("BANKING/known/"))
+}
+
+// sentEnv records the messages sent by a node
+type sentEnv struct {
+	exploreEnv
+	sent []string
+}
+
+func (e *sentEnv) send(target string, msg *com.Message) error {
+	e.sent = append(e.sent, target)
+	return nil
+}
+
+func TestHandler_writeAhead(t *testing.T) {
+	s, err := openStore(t.TempDir())
+	assert.Nil(t, err)
+	defer s.close()
+	e := &sentEnv{}
+	h := &handler{uid: 1, store: s, env: e}
+
+	h.hold()
+	h.persist("RUMOR/x", &rumorState{})
+	assert.Nil(t, h.send("node:2", com.Msg(1, "RUMOR", "x")))
+	assert.Empty(t, e.sent, "messages are held until the state is synced")
+	assert.True(t, s.dirty)
+
+	h.commit()
+	assert.False(t, s.dirty, "log is synced once for the handled input")
+	assert.Equal(t, []string{"node:2"}, e.sent)
+
+	assert.Nil(t, h.send("node:3", com.Msg(1, "RUMOR", "x")))
+	assert.Equal(t, []string{"node:2", "node:3"}, e.sent, "sends outside of a handled input are not held")
+}
+
+func TestLeader_restore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir)
+	assert.Nil(t, err)
+	h := &handler{uid: 2, store: s}
+
+	l := NewLeader("BANKING", true)
+	l.m, l.leaderUID, l.srcUID, l.depth = 5, 5, 3, 2
+	l.childUIDs = []uint{1, 4}
+	l.persist(h)
+	assert.Nil(t, s.close())
+
+	s, err = openStore(dir)
+	assert.Nil(t, err)
+	defer s.close()
+	h.store = s
+	r := NewLeader("BANKING", false)
+	r.restore(h)
+	assert.Equal(t, l.inspect(), r.inspect())
+	assert.True(t, r.wantLeader)
+	assert.True(t, r.ElectionComplete())
+}
+
+// clockEnv is an env with a settable clock
+type clockEnv struct {
+	exploreEnv
+	t time.Time
+}
+
+func (e *clockEnv) now() time.Time {
+	return e.t
+}
+
+func TestRumor_restore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir)
+	assert.Nil(t, err)
+	e := &clockEnv{t: time.Unix(100, 0)}
+	h := &handler{uid: 1, store: s, env: e}
+
+	ext, _ := NewRumorExtension()
+	ext.(*rumor).add(h, "x")
+	ext.(*rumor).persist(h, "x")
+	// State written without the first sighting
+	h.persist("RUMOR/y", &rumorState{Counter: 1})
+	assert.Nil(t, s.close())
+
+	s, err = openStore(dir)
+	assert.Nil(t, err)
+	defer s.close()
+	h.store = s
+	e.t = time.Unix(103, 0)
+	ext, _ = NewRumorExtension()
+	r := ext.(*rumor)
+	assert.Nil(t, r.Preflight(context.Background(), h))
+	d, ok := r.trusted(h, "x")
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, d, "the first sighting survives the restart")
+	_, ok = r.trusted(h, "y")
+	assert.False(t, ok, "no time to trust without the first sighting")
+	assert.Equal(t, map[string]float64{"x": 3}, r.Inspect()["time_to_trust"])
+}
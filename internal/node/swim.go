@@ -0,0 +1,372 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+const (
+	swimIndirectProbes   = 3 // members asked to probe a silent target
+	swimMaxPiggyback     = 6 // gossip updates per message
+	swimRetransmitMult   = 3 // an update is piggybacked swimRetransmitMult * log(n) times
+	swimSuspicionPeriods = 4 // a suspected member is dead after swimSuspicionPeriods * log(n) protocol periods
+)
+
+// swimMember is the state of a member as disseminated by gossip
+type swimMember struct {
+	Addr        string
+	Incarnation int
+	Status      NodeStatus
+}
+
+// swimUpdate is a membership update waiting to be piggybacked
+type swimUpdate struct {
+	uid  uint
+	sent int
+}
+
+// swimRelay is a ping sent on behalf of another member (indirect probe)
+type swimRelay struct {
+	requester uint
+	seq       int
+}
+
+// swim maintains an eventually consistent list of all members (SWIM: probe, indirect probe, suspicion, piggybacked gossip);
+// the view of all nodes (neigh.Neighs.AllNodes) is derived from it
+type swim struct {
+	seeds   []string      // addresses contacted until the first member is known
+	period  time.Duration // protocol period, one probe per period
+	timeout time.Duration // direct probe timeout, indirect probes afterwards
+
+	addr        string
+	incarnation int
+	left        bool // the own record is disseminated as dead
+	members     map[uint]*swimMember
+	updates     map[uint]*swimUpdate
+	probes      []uint // probe order of the current round
+	seq         int
+	pending     map[int]bool // own probes; true once acked
+	relays      map[int]swimRelay
+}
+
+// NewSwimExtension returns the SWIM membership handler; the node joins through the seed addresses
+func NewSwimExtension(seeds []string, period time.Duration) (Extension, string) {
+	return &swim{
+		seeds:   seeds,
+		period:  period,
+		timeout: period / 4,
+		members: map[uint]*swimMember{},
+		updates: map[uint]*swimUpdate{},
+		pending: map[int]bool{},
+		relays:  map[int]swimRelay{},
+	}, "SWIM"
+}
+
+func (s *swim) Preflight(ctx context.Context, h *handler) error {
+	addr, ok := h.neighs.AllNodes[h.uid]
+	if !ok {
+		return errors.New("address of the node unknown")
+	}
+	s.addr = addr
+	s.sync(h)
+	log.Info().Uint("uid", h.uid).Msgf("SWIM: probing every %s, %d seeds", s.period, len(s.seeds))
+	s.tick(h)
+	return nil
+}
+
+func (s *swim) Handle(h *handler, msg *com.Message) error {
+	ps := strings.Split(*msg.Payload, ";")
+	if ps[0] == "leave" { // sent by the client, this node leaves
+		return s.leave(h)
+	}
+	if len(ps) < 3 {
+		return errors.New("invalid message payload")
+	}
+	seq, err := strconv.Atoi(ps[1])
+	if err != nil {
+		return err
+	}
+	// The gossip contains the record of the sender; apply it first to know where to reply
+	if err := s.merge(h, ps[len(ps)-1]); err != nil {
+		return err
+	}
+
+	switch ps[0] {
+	case "ping":
+		return s.reply(h, *msg.SourceUID, fmt.Sprintf("ack;%d", seq))
+	case "ping-req":
+		target, err := strconv.Atoi(ps[2])
+		if err != nil || len(ps) != 4 {
+			return errors.New("invalid message payload")
+		}
+		relay := s.nextSeq()
+		s.relays[relay] = swimRelay{requester: *msg.SourceUID, seq: seq}
+		return s.reply(h, uint(target), fmt.Sprintf("ping;%d", relay))
+	case "ack":
+		if r, ok := s.relays[seq]; ok {
+			delete(s.relays, seq)
+			return s.reply(h, r.requester, fmt.Sprintf("ack;%d", r.seq))
+		}
+		if _, ok := s.pending[seq]; ok {
+			s.pending[seq] = true
+		}
+		return nil
+	}
+	return fmt.Errorf("payload `%s` not supported", *msg.Payload)
+}
+
+// tick probes the next member; without any member the seeds are contacted
+func (s *swim) tick(h *handler) {
+	h.after(s.period, "swim.probe", func() { s.tick(h) })
+
+	target, ok := s.nextTarget(h)
+	if !ok {
+		for _, seed := range s.seeds {
+			if seed != s.addr {
+				s.send(h, seed, fmt.Sprintf("ping;%d", s.nextSeq()))
+			}
+		}
+		return
+	}
+
+	seq := s.nextSeq()
+	s.pending[seq] = false
+	s.reply(h, target, fmt.Sprintf("ping;%d", seq))
+
+	// No ack: ask other members to probe the target
+	h.after(s.timeout, "swim.indirect", func() {
+		if s.pending[seq] {
+			return
+		}
+		for _, uid := range s.randomMembers(h, swimIndirectProbes, target) {
+			s.reply(h, uid, fmt.Sprintf("ping-req;%d;%d", seq, target))
+		}
+	})
+	// Still no ack at the end of the period: suspect the target
+	h.after(s.period, "swim.expired", func() {
+		acked := s.pending[seq]
+		delete(s.pending, seq)
+		if m, ok := s.members[target]; ok && !acked && m.Status == StatusAlive {
+			s.apply(h, target, swimMember{Addr: m.Addr, Incarnation: m.Incarnation, Status: StatusSuspected})
+		}
+	})
+}
+
+// nextTarget returns the next member of the current probe round; every round probes all members in random order
+func (s *swim) nextTarget(h *handler) (uint, bool) {
+	for {
+		if len(s.probes) == 0 {
+			s.probes = s.randomMembers(h, len(s.members), h.uid)
+			if len(s.probes) == 0 {
+				return 0, false
+			}
+		}
+		uid := s.probes[0]
+		s.probes = s.probes[1:]
+		if m, ok := s.members[uid]; ok && m.Status != StatusDead {
+			return uid, true
+		}
+	}
+}
+
+// randomMembers returns up to n random members that are not dead, excluding the given one
+func (s *swim) randomMembers(h *handler, n int, exclude uint) []uint {
+	uids := []uint{}
+	for _, uid := range s.sortedMembers() {
+		if uid != exclude && s.members[uid].Status != StatusDead {
+			uids = append(uids, uid)
+		}
+	}
+	// Fisher-Yates; draws go through the handler to keep executions reproducible
+	for i := len(uids) - 1; i > 0; i-- {
+		j := h.intn(i + 1)
+		uids[i], uids[j] = uids[j], uids[i]
+	}
+	if len(uids) > n {
+		uids = uids[:n]
+	}
+	return uids
+}
+
+// apply merges a member update; incarnation numbers decide which update is newer
+func (s *swim) apply(h *handler, uid uint, u swimMember) {
+	if uid == h.uid {
+		// Refute suspicion by announcing a newer incarnation
+		if u.Status != StatusAlive && u.Incarnation >= s.incarnation {
+			s.incarnation = u.Incarnation + 1
+			log.Warn().Uint("uid", h.uid).Msgf("SWIM: refuting %s with incarnation %d", u.Status, s.incarnation)
+		}
+		return
+	}
+
+	m, ok := s.members[uid]
+	if !ok {
+		if u.Status == StatusDead {
+			return
+		}
+		m = &swimMember{Status: StatusDead, Incarnation: -1}
+		s.members[uid] = m
+	}
+	if !u.overrides(m) {
+		return
+	}
+	old := m.Status
+	*m = u
+	s.updates[uid] = &swimUpdate{uid: uid}
+	log.Info().Uint("uid", h.uid).Uint("member", uid).Str("status", string(u.Status)).Msgf("SWIM: member %d is %s (incarnation %d)", uid, u.Status, u.Incarnation)
+
+	if u.Status == StatusSuspected {
+		inc := u.Incarnation
+		h.after(time.Duration(swimSuspicionPeriods*s.logN())*s.period, "swim.suspicion", func() {
+			if m := s.members[uid]; m.Status == StatusSuspected && m.Incarnation == inc {
+				s.apply(h, uid, swimMember{Addr: m.Addr, Incarnation: inc, Status: StatusDead})
+			}
+		})
+	}
+
+	// Only joins and deaths change the view; suspected members are still part of it
+	s.sync(h)
+	if (old == StatusDead) != (u.Status == StatusDead) {
+		_, neighbour := h.neighs.Nodes[uid]
+		h.membershipChanged(&MembershipChange{Join: u.Status != StatusDead, UID: uid, Addr: u.Addr, Neighbour: neighbour})
+	}
+}
+
+// overrides implements the SWIM precedence rules of an update u over the known state m
+func (u swimMember) overrides(m *swimMember) bool {
+	switch u.Status {
+	case StatusAlive:
+		return u.Incarnation > m.Incarnation
+	case StatusSuspected:
+		return u.Incarnation > m.Incarnation || (u.Incarnation == m.Incarnation && m.Status == StatusAlive)
+	case StatusDead:
+		return u.Incarnation >= m.Incarnation && m.Status != StatusDead
+	}
+	return false
+}
+
+// sync derives the view of all nodes from the members; the map is replaced, it might be shared with other nodes (simulation)
+func (s *swim) sync(h *handler) {
+	all := map[uint]string{h.uid: s.addr}
+	count := map[NodeStatus]int{}
+	for uid, m := range s.members {
+		count[m.Status]++
+		if m.Status != StatusDead {
+			all[uid] = m.Addr
+		}
+	}
+	h.neighs.AllNodes = all
+	for _, status := range []NodeStatus{StatusAlive, StatusSuspected, StatusDead} {
+		swimMembers.WithLabelValues(string(status)).Set(float64(count[status]))
+	}
+}
+
+// leave announces the own death to some members and stops the node
+func (s *swim) leave(h *handler) error {
+	log.Info().Uint("uid", h.uid).Msg("SWIM: leaving the cluster")
+	s.left = true
+	for _, uid := range s.randomMembers(h, swimIndirectProbes, h.uid) {
+		s.reply(h, uid, fmt.Sprintf("ping;%d", s.nextSeq()))
+	}
+	h.exit()
+	return nil
+}
+
+// reply sends a payload with piggybacked gossip to a member
+func (s *swim) reply(h *handler, uid uint, payload string) error {
+	m, ok := s.members[uid]
+	if !ok {
+		return fmt.Errorf("member %d unknown", uid)
+	}
+	return s.send(h, m.Addr, payload)
+}
+
+// send piggybacks the own record and the least disseminated updates on a payload
+func (s *swim) send(h *handler, addr, payload string) error {
+	self := swimMember{Addr: s.addr, Incarnation: s.incarnation, Status: StatusAlive}
+	if s.left {
+		self.Status = StatusDead
+	}
+	gossip := []string{s.encode(h.uid, self)}
+
+	pending := []*swimUpdate{}
+	for _, u := range s.updates {
+		pending = append(pending, u)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].sent != pending[j].sent {
+			return pending[i].sent < pending[j].sent
+		}
+		return pending[i].uid < pending[j].uid
+	})
+	limit := swimRetransmitMult * s.logN()
+	for i, u := range pending {
+		if i >= swimMaxPiggyback {
+			break
+		}
+		gossip = append(gossip, s.encode(u.uid, *s.members[u.uid]))
+		if u.sent = u.sent + 1; u.sent >= limit {
+			delete(s.updates, u.uid)
+		}
+	}
+
+	err := h.send(addr, com.Msg(h.uid, "SWIM", payload+";"+strings.Join(gossip, ",")))
+	if err != nil {
+		log.Debug().Err(err).Uint("uid", h.uid).Msgf("SWIM: failed sending to %s", addr)
+	}
+	return err
+}
+
+// encode serialises a member update as `<uid>/<incarnation>/<status>/<addr>`
+func (s *swim) encode(uid uint, m swimMember) string {
+	return fmt.Sprintf("%d/%d/%s/%s", uid, m.Incarnation, m.Status, m.Addr)
+}
+
+// merge applies the piggybacked gossip of a message
+func (s *swim) merge(h *handler, gossip string) error {
+	for _, e := range strings.Split(gossip, ",") {
+		f := strings.SplitN(e, "/", 4)
+		if len(f) != 4 {
+			return errors.New("invalid gossip")
+		}
+		uid, err := strconv.Atoi(f[0])
+		if err != nil {
+			return err
+		}
+		inc, err := strconv.Atoi(f[1])
+		if err != nil {
+			return err
+		}
+		s.apply(h, uint(uid), swimMember{Addr: f[3], Incarnation: inc, Status: NodeStatus(f[2])})
+	}
+	return nil
+}
+
+// logN scales dissemination and suspicion with the cluster size
+func (s *swim) logN() int {
+	return int(math.Ceil(math.Log2(float64(len(s.members) + 2))))
+}
+
+func (s *swim) nextSeq() int {
+	s.seq = s.seq + 1
+	return s.seq
+}
+
+// sortedMembers returns the UIDs of all members in ascending order
+func (s *swim) sortedMembers() []uint {
+	uids := []uint{}
+	for uid := range s.members {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
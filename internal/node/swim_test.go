@@ -0,0 +1,91 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+func TestSwim(t *testing.T) {
+	s := NewSimulation(1, 5*time.Millisecond, 5*time.Millisecond)
+	for uid := uint(1); uid <= 6; uid++ {
+		// Every node only knows itself and the seed
+		h := s.AddNode(uid, &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: map[uint]string{uid: addrOf(uid)}, Registered: map[uint]bool{}})
+		h.Register(NewControlExtension())
+		h.Register(NewSwimExtension([]string{addrOf(1)}, 100*time.Millisecond))
+	}
+	assert.Nil(t, s.Start(context.Background()))
+
+	s.Run(context.Background(), 3*time.Second)
+	for _, uid := range s.UIDs() {
+		assert.Len(t, s.nodes[uid].neighs.AllNodes, 6, "node %d knows all members", uid)
+	}
+
+	// Crash node 3; the others agree on its death
+	s.Inject(0, 3, com.Msg(0, "CONTROL", "SHUTDOWN"))
+	s.Run(context.Background(), 8*time.Second)
+	for _, uid := range []uint{1, 2, 4, 5, 6} {
+		assert.NotContains(t, s.nodes[uid].neighs.AllNodes, uint(3), "node %d removed the crashed member", uid)
+		assert.Len(t, s.nodes[uid].neighs.AllNodes, 5)
+	}
+
+	// Node 5 leaves gracefully
+	s.Inject(0, 5, com.Msg(0, "SWIM", "leave"))
+	s.Run(context.Background(), 10*time.Second)
+	for _, uid := range []uint{1, 2, 4, 6} {
+		assert.Len(t, s.nodes[uid].neighs.AllNodes, 4, "node %d removed the left member", uid)
+	}
+}
+
+func TestSwimMember_overrides(t *testing.T) {
+	alive := &swimMember{Incarnation: 2, Status: StatusAlive}
+	suspected := &swimMember{Incarnation: 2, Status: StatusSuspected}
+	dead := &swimMember{Incarnation: 2, Status: StatusDead}
+
+	assert.False(t, swimMember{Incarnation: 2, Status: StatusAlive}.overrides(suspected))
+	assert.True(t, swimMember{Incarnation: 3, Status: StatusAlive}.overrides(suspected), "refuted by a newer incarnation")
+	assert.True(t, swimMember{Incarnation: 2, Status: StatusSuspected}.overrides(alive))
+	assert.False(t, swimMember{Incarnation: 1, Status: StatusSuspected}.overrides(alive))
+	assert.True(t, swimMember{Incarnation: 2, Status: StatusDead}.overrides(suspected))
+	assert.False(t, swimMember{Incarnation: 2, Status: StatusAlive}.overrides(dead))
+	assert.True(t, swimMember{Incarnation: 3, Status: StatusAlive}.overrides(dead), "rejoin with a newer incarnation")
+}
+
+func addrOf(uid uint) string {
+	return fmt.Sprintf("n%d:1", uid)
+}
+
+func TestSwim_banking(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: addrOf(1), 2: addrOf(2), 3: addrOf(3), 4: addrOf(4)}}
+	s := NewSimulation(1, 5*time.Millisecond, 5*time.Millisecond)
+	s.AddNodes(c, &neigh.NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}, 3: {4}}}, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewDiscoveryExtension())
+		h.Register(NewSwimExtension([]string{addrOf(1)}, 100*time.Millisecond))
+		h.Register(NewDistributedBankingExtension())
+	})
+	// Node 5 only joins the view, the members learn about it at different times
+	n5 := s.AddNode(5, &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: map[uint]string{5: addrOf(5)}, Registered: map[uint]bool{}})
+	n5.Register(NewControlExtension())
+	n5.Register(NewSwimExtension([]string{addrOf(1)}, 100*time.Millisecond))
+	assert.Nil(t, s.Start(context.Background()))
+	s.InjectAll(0, com.Msg(0, "CONTROL", "STARTUP"))
+	s.InjectAll(100*time.Millisecond, com.Msg(0, "BANKING", "coordinator"))
+
+	s.Run(context.Background(), 5*time.Second)
+	var epoch int
+	for _, uid := range []uint{1, 2, 3, 4} {
+		l := s.nodes[uid].ext["BANKING"].(*banking).leader
+		if uid == 1 {
+			epoch = l.epoch
+		}
+		assert.Greater(t, l.epoch, 0, "node %d restarted the election", uid)
+		assert.Equal(t, epoch, l.epoch, "node %d joined the latest election", uid)
+		assert.Equal(t, uint(4), l.leaderUID, "node %d knows the leader", uid)
+	}
+}
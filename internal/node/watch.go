@@ -0,0 +1,128 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/pkg/com"
+)
+
+// Kinds of events reported to watchers
+const (
+	EventLeaderElected   = "leader_elected"
+	EventRumorTrusted    = "rumor_trusted"
+	EventConsensusResult = "consensus_result"
+	EventSnapshotBalance = "snapshot_balance"
+	EventLockAcquired    = "lock_acquired"
+	EventLockReleased    = "lock_released"
+)
+
+// EventKinds lists all kinds of events
+var EventKinds = []string{EventLeaderElected, EventRumorTrusted, EventConsensusResult, EventSnapshotBalance, EventLockAcquired, EventLockReleased}
+
+// Event is a state change of a node; reported as `EVENT <json>` to the addresses registered with `WATCH <addr>`
+type Event struct {
+	UID   uint                   `json:"uid"`
+	Kind  string                 `json:"kind"`
+	Time  time.Time              `json:"time"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// watch registers (or with unwatch removes) an address receiving the events of this node
+func (h *handler) watch(addr string, unwatch bool) {
+	if h.watchers == nil {
+		h.watchers = map[string]bool{}
+	}
+	if unwatch {
+		log.Info().Uint("uid", h.uid).Msgf("Removed watcher %s", addr)
+		delete(h.watchers, addr)
+		return
+	}
+	log.Info().Uint("uid", h.uid).Msgf("Added watcher %s", addr)
+	h.watchers[addr] = true
+}
+
+// eventQueueSize bounds the events waiting for delivery; further events are dropped
+const eventQueueSize = 256
+
+// emit reports an event to all watchers; unreachable watchers are removed
+func (h *handler) emit(kind string, attrs map[string]interface{}) {
+	if len(h.watchers) == 0 {
+		return
+	}
+	b, err := json.Marshal(&Event{UID: h.uid, Kind: kind, Time: h.now().UTC(), Attrs: attrs})
+	if err != nil {
+		log.Err(err).Uint("uid", h.uid).Msgf("Failed to encode %s event", kind)
+		return
+	}
+	m := com.Msg(h.uid, "CONTROL", fmt.Sprintf("EVENT %s", string(b)))
+	for addr := range h.watchers {
+		messagesTotal.WithLabelValues(*m.Type, "out").Inc()
+		o := &outgoing{target: addr, msg: m, event: true}
+		if h.holding {
+			// Same as h.send, watchers must not see state that is not synced yet
+			h.held = append(h.held, o)
+			continue
+		}
+		h.deliverEvent(o)
+	}
+}
+
+// deliverEvent sends an event; environments whose sends may block the node loop deliver it from the event queue
+func (h *handler) deliverEvent(o *outgoing) {
+	if _, ok := h.env.(asyncEnv); !ok {
+		if err := h.env.send(o.target, o.msg); err != nil {
+			h.unreachableWatcher(o.target, err)
+		}
+		return
+	}
+	cp := *o.msg // com.Send sets the UUID of each delivery
+	select {
+	case h.eventQueue() <- &outgoing{target: o.target, msg: &cp, event: true}:
+	default:
+		log.Warn().Uint("uid", h.uid).Msgf("Event queue full, dropping event to %s", o.target)
+	}
+}
+
+// eventQueue returns the queue of events, started on first use; a single goroutine delivers them in order and removes
+// unreachable watchers with an `UNWATCH` through the input of the node, so recorded traces contain the removal
+func (h *handler) eventQueue() chan *outgoing {
+	if h.events == nil {
+		h.events = make(chan *outgoing, eventQueueSize)
+		go func(q chan *outgoing, in chan *com.Message) {
+			for {
+				select {
+				case o := <-q:
+					err := com.Send(o.target, o.msg)
+					if err == nil {
+						continue
+					}
+					log.Warn().Err(err).Uint("uid", h.uid).Msgf("Watcher %s unreachable", o.target)
+					m := com.Msg(h.uid, "CONTROL", "UNWATCH "+o.target)
+					id := uuid.NewString()[0:8]
+					m.UUID = &id
+					select {
+					case in <- m:
+					case <-h.done:
+						return
+					}
+				case <-h.done:
+					return
+				}
+			}
+		}(h.events, h.input)
+	}
+	return h.events
+}
+
+// unreachableWatcher removes a watcher an event could not be delivered to
+func (h *handler) unreachableWatcher(addr string, err error) {
+	if !h.watchers[addr] {
+		return
+	}
+	log.Warn().Err(err).Uint("uid", h.uid).Msgf("Removed unreachable watcher %s", addr)
+	delete(h.watchers, addr)
+}
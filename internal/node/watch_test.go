@@ -0,0 +1,174 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xvzf/vaa/pkg/com"
+	"github.com/xvzf/vaa/pkg/neigh"
+)
+
+// eventRecorder decodes the events a watcher receives
+type eventRecorder struct {
+	events []*Event
+}
+
+func (r *eventRecorder) Preflight(ctx context.Context, h *handler) error { return nil }
+func (r *eventRecorder) Handle(h *handler, msg *com.Message) error {
+	e := &Event{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(*msg.Payload, "EVENT ")), e); err != nil {
+		return err
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+// sources returns the UIDs that reported events, in ascending order
+func (r *eventRecorder) sources() []uint {
+	uids := []uint{}
+	for _, e := range r.events {
+		uids = append(uids, e.UID)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+func TestWatch(t *testing.T) {
+	c := &neigh.Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	s := NewSimulation(1, 5*time.Millisecond, 0)
+	s.AddNodes(c, &neigh.NeighMap{Neighs: map[uint][]uint{1: {2, 3}, 2: {3}}}, func(h Handler) {
+		h.Register(NewControlExtension())
+		h.Register(NewRumorExtension())
+	})
+	r := &eventRecorder{}
+	s.AddNode(9, &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: map[uint]string{9: "watcher:1"}}).Register(r, "CONTROL")
+	assert.Nil(t, s.Start(context.Background()))
+
+	s.InjectAll(0, com.Msg(0, "CONTROL", "WATCH watcher:1"))
+	s.Inject(time.Millisecond, 1, com.Msg(0, "RUMOR", "1;foo"))
+	s.Run(context.Background(), time.Second)
+	assert.Equal(t, []uint{1, 2, 3}, r.sources())
+	assert.Equal(t, EventRumorTrusted, r.events[0].Kind)
+	assert.Equal(t, "foo", r.events[0].Attrs["rumor"])
+
+	// Removed watchers do not receive events anymore
+	r.events = nil
+	s.Inject(0, 2, com.Msg(0, "CONTROL", "UNWATCH watcher:1"))
+	s.Inject(time.Millisecond, 1, com.Msg(0, "RUMOR", "1;bar"))
+	s.Run(context.Background(), time.Second)
+	assert.Equal(t, []uint{1, 3}, r.sources())
+
+	// Unreachable watchers are removed on the next event
+	s.Inject(0, 1, com.Msg(0, "CONTROL", "WATCH gone:1"))
+	s.Inject(time.Millisecond, 1, com.Msg(0, "RUMOR", "1;baz"))
+	s.Run(context.Background(), time.Second)
+	assert.Equal(t, map[string]bool{"watcher:1": true}, s.nodes[1].watchers)
+}
+
+// freeAddr returns an address on 127.0.0.1 nobody listens on
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestWatch_eventQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	watcher, gone := l.Addr().String(), freeAddr(t)
+	received := make(chan *com.Message, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			m := &com.Message{}
+			if json.NewDecoder(conn).Decode(m) == nil {
+				received <- m
+			}
+			conn.Close()
+		}
+	}()
+
+	h := New(1, cancel, &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: map[uint]string{}}).(*handler)
+	h.Register(NewControlExtension())
+	h.Register(NewRumorExtension())
+	in := make(chan *com.Message)
+	stopped := make(chan struct{})
+	go func() {
+		h.Run(ctx, in)
+		close(stopped)
+	}()
+	for i, m := range []*com.Message{
+		com.Msg(0, "CONTROL", "WATCH "+watcher),
+		com.Msg(0, "CONTROL", "WATCH "+gone),
+		com.Msg(0, "RUMOR", "1;foo"),
+	} {
+		m.UUID = com.StrPointer(fmt.Sprint(i)) // set by com.Send otherwise
+		in <- m
+	}
+
+	select {
+	case m := <-received:
+		assert.Contains(t, *m.Payload, EventRumorTrusted)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	// The failed delivery removes the watcher on the node loop
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-stopped
+	assert.Equal(t, map[string]bool{watcher: true}, h.watchers)
+}
+
+func TestWatch_recordReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	neighs := &neigh.Neighs{Nodes: map[uint]string{}, AllNodes: map[uint]string{}}
+	trace := &bytes.Buffer{}
+
+	h := New(1, cancel, neighs).(*handler)
+	h.Register(NewControlExtension())
+	h.Register(NewRumorExtension())
+	h.Record(trace)
+	in := make(chan *com.Message)
+	stopped := make(chan struct{})
+	go func() {
+		h.Run(ctx, in)
+		close(stopped)
+	}()
+	for i, m := range []*com.Message{
+		com.Msg(0, "CONTROL", "WATCH "+freeAddr(t)),
+		com.Msg(0, "RUMOR", "1;foo"),
+	} {
+		m.UUID = com.StrPointer(fmt.Sprint(i))
+		in <- m
+	}
+	// The unreachable watcher is removed through the input of the node
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-stopped
+	assert.Empty(t, h.watchers)
+	assert.Contains(t, trace.String(), "UNWATCH")
+
+	r := New(1, func() {}, neighs).(*handler)
+	r.Register(NewControlExtension())
+	r.Register(NewRumorExtension())
+	_, err := r.Replay(context.Background(), trace)
+	assert.Nil(t, err, "replay follows the trace")
+	assert.Empty(t, r.watchers)
+	assert.Equal(t, h.ext["RUMOR"].(*rumor).Inspect(), r.ext["RUMOR"].(*rumor).Inspect(), "clock readings are replayed")
+}
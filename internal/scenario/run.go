@@ -0,0 +1,213 @@
+package scenario
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/xvzf/vaa/internal/cluster"
+	"github.com/xvzf/vaa/internal/node"
+)
+
+// Defaults of the timeouts
+const (
+	readyTimeout    = 30 * time.Second
+	snapshotTimeout = 5 * time.Second
+	waitTimeout     = 60 * time.Second
+	waitInterval    = time.Second
+	shutdownTimeout = 30 * time.Second
+)
+
+// Failure is returned when an assertion does not hold or a wait timed out, as opposed to errors running the cluster
+type Failure struct {
+	Step   int
+	Name   string
+	Reason string
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("step %d (%s) failed: %s", f.Step, f.Name, f.Reason)
+}
+
+// IsFailure reports whether err is a failed step
+func IsFailure(err error) bool {
+	var f *Failure
+	return errors.As(err, &f)
+}
+
+// Result of a single step
+type Result struct {
+	Step     string        `json:"step"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// runner executes the steps against a running cluster
+type runner struct {
+	s   *Scenario
+	c   *cluster.Cluster
+	ctx context.Context
+}
+
+// Run launches the cluster with opts, executes all steps and stops the cluster; the first failing step ends the run
+func Run(ctx context.Context, s *Scenario, opts cluster.Options) ([]*Result, error) {
+	c, err := cluster.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	configFile, graphFile := c.Files()
+	log.Info().Str("scenario", s.Name).Str("config", configFile).Str("graph", graphFile).Msgf("Starting %d nodes", len(c.Config.Nodes))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		return nil, err
+	}
+	defer c.Stop()
+	timeout := s.Cluster.ReadyTimeout
+	if timeout == 0 {
+		timeout = readyTimeout
+	}
+	if err := c.Ready(ctx, timeout); err != nil {
+		return nil, err
+	}
+
+	r := &runner{s: s, c: c, ctx: ctx}
+	results := []*Result{}
+	for i, st := range s.Steps {
+		log.Info().Str("scenario", s.Name).Msgf("Step %d/%d: %s", i+1, len(s.Steps), st)
+		start := time.Now()
+		err := r.step(st)
+		res := &Result{Step: st.String(), Duration: time.Since(start)}
+		results = append(results, res)
+		if err != nil {
+			if f := (*Failure)(nil); errors.As(err, &f) {
+				f.Step, f.Name = i+1, st.String()
+			} else {
+				err = fmt.Errorf("step %d (%s): %v", i+1, st, err)
+			}
+			res.Error = err.Error()
+			return results, err
+		}
+		log.Info().Str("scenario", s.Name).Msgf("Step %d/%d done after %s", i+1, len(s.Steps), res.Duration)
+	}
+	return results, nil
+}
+
+func (r *runner) step(st *Step) error {
+	switch {
+	case st.Send != nil:
+		return r.send(st.Send)
+	case st.Sleep != 0:
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-time.After(st.Sleep):
+			return nil
+		}
+	case st.Wait != nil:
+		return r.wait(st.Wait)
+	case st.Assert != "":
+		return r.assert(st.Assert)
+	case st.Shutdown:
+		return r.shutdown()
+	}
+	return nil
+}
+
+func (r *runner) send(s *Send) error {
+	if len(s.To) == 0 {
+		return r.c.SendAll(s.Type, s.Payload)
+	}
+	for _, uid := range s.To {
+		if err := r.c.Send(uid, s.Type, s.Payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check collects a snapshot and evaluates the invariants including eventually invariants
+func (r *runner) check(spec string) (string, error) {
+	invs, err := node.ParseInvariants(spec)
+	if err != nil {
+		return "", err
+	}
+	timeout := r.s.Cluster.SnapshotTimeout
+	if timeout == 0 {
+		timeout = snapshotTimeout
+	}
+	snap, err := r.c.Snapshot(r.ctx, timeout)
+	if err != nil {
+		return "", err
+	}
+	if len(snap.Missing) > 0 {
+		return fmt.Sprintf("nodes %v did not report their state", snap.Missing), nil
+	}
+	reasons := []string{}
+	for _, v := range node.CheckInvariants(snap.States, invs, true) {
+		uids := []uint{}
+		for _, st := range v.States {
+			uids = append(uids, st.UID)
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s %v", v.Invariant, v.Error, uids))
+	}
+	return strings.Join(reasons, "; "), nil
+}
+
+func (r *runner) wait(w *Wait) error {
+	timeout, interval := w.Timeout, w.Interval
+	if timeout == 0 {
+		timeout = waitTimeout
+	}
+	if interval == 0 {
+		interval = waitInterval
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		reason, err := r.check(w.Until)
+		if err != nil {
+			return err
+		} else if reason == "" {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return &Failure{Reason: fmt.Sprintf("timed out after %s, %s", timeout, reason)}
+		}
+		log.Debug().Msgf("Waiting: %s", reason)
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-r.c.Done():
+			return errors.New("all nodes exited")
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (r *runner) assert(spec string) error {
+	reason, err := r.check(spec)
+	if err != nil {
+		return err
+	} else if reason != "" {
+		return &Failure{Reason: reason}
+	}
+	return nil
+}
+
+func (r *runner) shutdown() error {
+	if err := r.c.SendAll("CONTROL", "SHUTDOWN"); err != nil {
+		return err
+	}
+	select {
+	case <-r.c.Done():
+		return nil
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-time.After(shutdownTimeout):
+		return &Failure{Reason: fmt.Sprintf("nodes still running %s after SHUTDOWN", shutdownTimeout)}
+	}
+}
@@ -0,0 +1,177 @@
+// Package scenario describes experiments as a sequence of steps executed against a local cluster
+package scenario
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/xvzf/vaa/internal/cluster"
+	"github.com/xvzf/vaa/internal/node"
+	"github.com/xvzf/vaa/pkg/neigh"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a cluster and the steps executed in order against it
+type Scenario struct {
+	Name    string  `yaml:"name"`
+	Cluster Cluster `yaml:"cluster"`
+	Steps   []*Step `yaml:"steps"`
+}
+
+// Cluster describes the launched cluster, see cluster.Options; relative paths are resolved against the scenario file
+type Cluster struct {
+	Config       string   `yaml:"config,omitempty"`
+	Graph        string   `yaml:"graph,omitempty"`
+	Topology     string   `yaml:"topology,omitempty"` // random overlay of the nodes if empty and no graph is set
+	N            uint     `yaml:"n,omitempty"`
+	M            uint     `yaml:"m,omitempty"`
+	K            uint     `yaml:"k,omitempty"`
+	Rows         uint     `yaml:"rows,omitempty"`
+	Cols         uint     `yaml:"cols,omitempty"`
+	Dim          uint     `yaml:"dim,omitempty"`
+	P            float64  `yaml:"p,omitempty"`
+	Beta         float64  `yaml:"beta,omitempty"`
+	Connectivity uint     `yaml:"connectivity,omitempty"`
+	Seed         int64    `yaml:"seed,omitempty"`
+	InProcess    bool     `yaml:"in_process,omitempty"`
+	NodeArgs     []string `yaml:"node_args,omitempty"` // ignored for in-process nodes
+
+	ReadyTimeout    time.Duration `yaml:"ready_timeout,omitempty"`    // time to wait for all nodes to accept connections
+	SnapshotTimeout time.Duration `yaml:"snapshot_timeout,omitempty"` // time to wait for all nodes to report their state
+}
+
+// Step is a single action; exactly one of send, sleep, wait, assert and shutdown has to be set
+type Step struct {
+	Name     string        `yaml:"name,omitempty"`
+	Send     *Send         `yaml:"send,omitempty"`
+	Sleep    time.Duration `yaml:"sleep,omitempty"`
+	Wait     *Wait         `yaml:"wait,omitempty"`
+	Assert   string        `yaml:"assert,omitempty"` // invariants that have to hold on a single snapshot, see node.ParseInvariants
+	Shutdown bool          `yaml:"shutdown,omitempty"`
+}
+
+// Send delivers a message to nodes
+type Send struct {
+	Type    string `yaml:"type"`
+	Payload string `yaml:"payload"`
+	To      []uint `yaml:"to,omitempty"` // all nodes if empty
+}
+
+// Wait polls the state of the cluster until the invariants hold
+type Wait struct {
+	Until    string        `yaml:"until"`              // see node.ParseInvariants, eventually invariants included
+	Timeout  time.Duration `yaml:"timeout,omitempty"`  // the scenario fails once exceeded; 60s if 0
+	Interval time.Duration `yaml:"interval,omitempty"` // 1s if 0
+}
+
+// Load reads and validates a YAML scenario
+func Load(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scenario{}
+	if err := yaml.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Relative to the scenario file
+	dir := filepath.Dir(path)
+	for _, p := range []*string{&s.Cluster.Config, &s.Cluster.Graph} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return s, nil
+}
+
+// Validate checks the steps, e.g. that the invariants exist
+func (s *Scenario) Validate() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("scenario has no steps")
+	}
+	for i, st := range s.Steps {
+		actions := 0
+		for _, set := range []bool{st.Send != nil, st.Sleep != 0, st.Wait != nil, st.Assert != "", st.Shutdown} {
+			if set {
+				actions++
+			}
+		}
+		if actions != 1 {
+			return fmt.Errorf("step %d (%s): exactly one of send, sleep, wait, assert and shutdown has to be set", i+1, st)
+		}
+		switch {
+		case st.Send != nil && st.Send.Type == "":
+			return fmt.Errorf("step %d (%s): message type missing", i+1, st)
+		case st.Sleep < 0:
+			return fmt.Errorf("step %d (%s): negative sleep", i+1, st)
+		case st.Wait != nil && st.Wait.Until == "":
+			return fmt.Errorf("step %d (%s): wait requires until", i+1, st)
+		}
+		for _, spec := range []string{st.Assert, st.until()} {
+			if _, err := node.ParseInvariants(spec); err != nil {
+				return fmt.Errorf("step %d (%s): %v", i+1, st, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Options converts the cluster description, defaults as in cmd/cluster
+func (c *Cluster) Options() cluster.Options {
+	p := neigh.TopologyParams{N: c.N, M: c.M, K: c.K, Rows: c.Rows, Cols: c.Cols, Dim: c.Dim, P: c.P, Beta: c.Beta, Connectivity: c.Connectivity}
+	for _, d := range []struct {
+		v   *uint
+		def uint
+	}{{&p.N, 7}, {&p.K, 4}, {&p.Rows, 3}, {&p.Cols, 3}, {&p.Dim, 3}, {&p.Connectivity, 1}} {
+		if *d.v == 0 {
+			*d.v = d.def
+		}
+	}
+	if p.P == 0 {
+		p.P = 0.3
+	}
+	if p.Beta == 0 {
+		p.Beta = 0.2
+	}
+	return cluster.Options{
+		Config:    c.Config,
+		Graph:     c.Graph,
+		Topology:  c.Topology,
+		Params:    p,
+		Seed:      c.Seed,
+		InProcess: c.InProcess,
+		NodeArgs:  c.NodeArgs,
+	}
+}
+
+// String is the name of the step or a short description of its action
+func (st *Step) String() string {
+	switch {
+	case st.Name != "":
+		return st.Name
+	case st.Send != nil:
+		return fmt.Sprintf("send %s %s", st.Send.Type, st.Send.Payload)
+	case st.Sleep != 0:
+		return fmt.Sprintf("sleep %s", st.Sleep)
+	case st.Wait != nil:
+		return fmt.Sprintf("wait until %s", st.Wait.Until)
+	case st.Assert != "":
+		return fmt.Sprintf("assert %s", st.Assert)
+	case st.Shutdown:
+		return "shutdown"
+	}
+	return "empty"
+}
+
+func (st *Step) until() string {
+	if st.Wait == nil {
+		return ""
+	}
+	return st.Wait.Until
+}
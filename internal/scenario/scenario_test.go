@@ -0,0 +1,90 @@
+package scenario
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "s.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+name: test
+cluster:
+  graph: graph.txt
+  in_process: true
+steps:
+  - send: {type: CONTROL, payload: STARTUP, to: [1, 2]}
+  - sleep: 500ms
+  - wait: {until: "elected:CONSENSUS", timeout: 1m}
+  - name: agreement
+    assert: consensus
+  - shutdown: true
+`), 0644))
+
+	s, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "graph.txt"), s.Cluster.Graph, "relative to the scenario")
+	assert.Len(t, s.Steps, 5)
+	assert.Equal(t, []uint{1, 2}, s.Steps[0].Send.To)
+	assert.Equal(t, 500*time.Millisecond, s.Steps[1].Sleep)
+	assert.Equal(t, time.Minute, s.Steps[2].Wait.Timeout)
+	assert.Equal(t, "wait until elected:CONSENSUS", s.Steps[2].String())
+	assert.Equal(t, "agreement", s.Steps[3].String())
+
+	opts := s.Cluster.Options()
+	assert.True(t, opts.InProcess)
+	assert.Equal(t, uint(7), opts.Params.N, "defaults of cmd/cluster")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []*Step
+	}{
+		{"No steps", nil},
+		{"No action", []*Step{{Name: "nothing"}}},
+		{"Two actions", []*Step{{Sleep: time.Second, Shutdown: true}}},
+		{"Missing type", []*Step{{Send: &Send{Payload: "STARTUP"}}}},
+		{"Missing until", []*Step{{Wait: &Wait{}}}},
+		{"Unknown invariant", []*Step{{Assert: "everything"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scenario{Steps: tt.steps}
+			assert.NotNil(t, s.Validate())
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := &Scenario{
+		Name:    "rumor",
+		Cluster: Cluster{Topology: "complete", N: 4, InProcess: true},
+		Steps: []*Step{
+			{Send: &Send{Type: "CONTROL", Payload: "STARTUP"}},
+			{Sleep: 200 * time.Millisecond},
+			{Send: &Send{Type: "RUMOR", Payload: "2;SomeRumor"}},
+			{Wait: &Wait{Until: "rumor:SomeRumor", Timeout: 10 * time.Second, Interval: 100 * time.Millisecond}},
+			{Assert: "rumor:OtherRumor"},
+			{Shutdown: true},
+		},
+	}
+	assert.Nil(t, s.Validate())
+	opts := s.Cluster.Options()
+	opts.Dir, opts.Logs = t.TempDir(), ioutil.Discard
+
+	results, err := Run(context.Background(), s, opts)
+	assert.True(t, IsFailure(err), "the rumor nobody heard of is not trusted")
+	assert.Contains(t, err.Error(), "step 5 (assert rumor:OtherRumor)")
+	if assert.Len(t, results, 5) {
+		assert.Empty(t, results[3].Error)
+		assert.Equal(t, err.Error(), results[4].Error)
+	}
+}
@@ -17,7 +17,7 @@ func TestCommunication_valid(t *testing.T) {
 	test_msg := &Message{
 		UUID:      StrPointer(uuid.NewString()),
 		Timestamp: timePointer(time.Now().UTC()),
-		SourceUID: uintPointer(2),
+		SourceUID: UintPointer(2),
 		Type:      StrPointer("CONTROL"),
 		Payload:   StrPointer("some payload 1234"),
 	}
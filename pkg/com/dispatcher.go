@@ -3,6 +3,7 @@ package com
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net"
 	"strings"
 
@@ -38,7 +39,9 @@ func (c *listenConfig) handleConn(ctx context.Context, conn net.Conn) {
 	msg := &Message{}
 	d := json.NewDecoder(conn)
 	err := d.Decode(msg)
-	if err != nil {
+	if err == io.EOF {
+		return // closed without a message, e.g. a readiness probe
+	} else if err != nil {
 		log.Err(err).Msg("failed to decode incoming message")
 		return
 	}
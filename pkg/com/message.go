@@ -38,7 +38,7 @@ func StrPointer(s string) *string {
 	return &s
 }
 
-func uintPointer(i uint) *uint {
+func UintPointer(i uint) *uint {
 	return &i
 }
 
@@ -50,7 +50,7 @@ func timePointer(t time.Time) *time.Time {
 func Msg(uid uint, msgType, msgPayload string) *Message {
 	return &Message{
 		Timestamp: timePointer(time.Now().UTC()),
-		SourceUID: uintPointer(uid),
+		SourceUID: UintPointer(uid),
 		Type:      StrPointer(msgType),
 		Payload:   StrPointer(msgPayload),
 	}
@@ -0,0 +1,224 @@
+package neigh
+
+import "sort"
+
+// Analysis summarizes the structure of a graph; the direction of edges is ignored
+type Analysis struct {
+	Nodes              uint          `json:"nodes"`
+	Edges              uint          `json:"edges"`
+	Directed           bool          `json:"directed"`
+	Components         [][]uint      `json:"components"`
+	Diameter           uint          `json:"diameter"` // longest shortest path within a component
+	Radius             uint          `json:"radius"`   // smallest eccentricity within a component
+	Center             []uint        `json:"center"`   // nodes with an eccentricity equal to the radius
+	Eccentricity       map[uint]uint `json:"eccentricity"`
+	Degrees            map[uint]uint `json:"degrees"`             // node -> degree
+	DegreeDistribution map[uint]uint `json:"degree_distribution"` // degree -> number of nodes
+	MinDegree          uint          `json:"min_degree"`
+	MaxDegree          uint          `json:"max_degree"`
+	MeanDegree         float64       `json:"mean_degree"`
+	Bridges            []Edge        `json:"bridges"`             // edges whose removal disconnects their component
+	ArticulationPoints []uint        `json:"articulation_points"` // nodes whose removal disconnects their component
+	Cycles             uint          `json:"cycles"`              // independent cycles (cyclomatic number m - n + c)
+	Girth              uint          `json:"girth"`               // length of the shortest cycle, 0 if acyclic
+	VertexConnectivity uint          `json:"vertex_connectivity"`
+}
+
+// Connected is true if the graph consists of a single component
+func (a *Analysis) Connected() bool {
+	return len(a.Components) == 1
+}
+
+// AnalyzeNodes analyzes the given nodes of a graph; UIDs don't have to be contiguous, nodes without edges are isolated
+// and edges to other nodes are ignored
+func AnalyzeNodes(nm *NeighMap, uids []uint) *Analysis {
+	uids = sortedSet(toSet(uids))
+	// Relabel to 1..n in ascending order, so the order of nodes, edges and components is kept
+	index := map[uint]uint{}
+	for i, uid := range uids {
+		index[uid] = uint(i + 1)
+	}
+	compact := newNeighMap()
+	compact.Directed = nm.Directed
+	for _, e := range nm.edges() {
+		a, okA := index[e[0]]
+		b, okB := index[e[1]]
+		if okA && okB {
+			compact.add(a, b)
+		}
+	}
+
+	a := Analyze(compact, uint(len(uids)))
+	uid := func(v uint) uint { return uids[v-1] }
+	relabel := func(vs []uint) []uint {
+		res := make([]uint, len(vs))
+		for i, v := range vs {
+			res[i] = uid(v)
+		}
+		return res
+	}
+	for i := range a.Components {
+		a.Components[i] = relabel(a.Components[i])
+	}
+	a.Center = relabel(a.Center)
+	a.ArticulationPoints = relabel(a.ArticulationPoints)
+	for i, e := range a.Bridges {
+		a.Bridges[i] = Edge{uid(e.From), uid(e.To)}
+	}
+	ecc, degrees := map[uint]uint{}, map[uint]uint{}
+	for v := range a.Eccentricity {
+		ecc[uid(v)] = a.Eccentricity[v]
+	}
+	for v := range a.Degrees {
+		degrees[uid(v)] = a.Degrees[v]
+	}
+	a.Eccentricity, a.Degrees = ecc, degrees
+	return a
+}
+
+// Analyze analyzes the nodes 1..n of a graph
+func Analyze(nm *NeighMap, n uint) *Analysis {
+	adj := nm.undirected(n)
+	a := &Analysis{
+		Nodes:              n,
+		Directed:           nm.Directed,
+		Components:         Components(nm, n),
+		Eccentricity:       make(map[uint]uint),
+		Degrees:            make(map[uint]uint),
+		DegreeDistribution: make(map[uint]uint),
+		Bridges:            []Edge{},
+		ArticulationPoints: []uint{},
+		Center:             []uint{},
+		VertexConnectivity: VertexConnectivity(nm, n),
+	}
+	if n == 0 {
+		return a
+	}
+
+	// Degrees
+	a.MinDegree = uint(len(adj[1]))
+	for v := uint(1); v <= n; v++ {
+		d := uint(len(adj[v]))
+		a.Degrees[v] = d
+		a.DegreeDistribution[d]++
+		a.Edges += d
+		if d < a.MinDegree {
+			a.MinDegree = d
+		}
+		if d > a.MaxDegree {
+			a.MaxDegree = d
+		}
+	}
+	a.MeanDegree = float64(a.Edges) / float64(n)
+	a.Edges /= 2
+	a.Cycles = a.Edges + uint(len(a.Components)) - n
+
+	// Eccentricities and girth with a breadth first search from every node
+	a.Radius = n
+	for s := uint(1); s <= n; s++ {
+		ecc, girth := bfs(adj, s)
+		a.Eccentricity[s] = ecc
+		if ecc > a.Diameter {
+			a.Diameter = ecc
+		}
+		if ecc < a.Radius {
+			a.Radius = ecc
+		}
+		if girth > 0 && (a.Girth == 0 || girth < a.Girth) {
+			a.Girth = girth
+		}
+	}
+	for v := uint(1); v <= n; v++ {
+		if a.Eccentricity[v] == a.Radius {
+			a.Center = append(a.Center, v)
+		}
+	}
+
+	a.Bridges, a.ArticulationPoints = cutElements(adj)
+	return a
+}
+
+// bfs returns the eccentricity of s within its component and the length of the shortest cycle through s (0 if none)
+func bfs(adj [][]uint, s uint) (uint, uint) {
+	dist := make([]int, len(adj))
+	parent := make([]uint, len(adj))
+	for i := range dist {
+		dist[i] = -1
+	}
+	dist[s] = 0
+	var ecc, girth uint
+	queue := []uint{s}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for _, v := range adj[u] {
+			if dist[v] < 0 {
+				dist[v], parent[v] = dist[u]+1, u
+				if uint(dist[v]) > ecc {
+					ecc = uint(dist[v])
+				}
+				queue = append(queue, v)
+			} else if parent[u] != v {
+				// A non-tree edge closes a cycle; the shortest one over all roots is the girth
+				if c := uint(dist[u] + dist[v] + 1); girth == 0 || c < girth {
+					girth = c
+				}
+			}
+		}
+	}
+	return ecc, girth
+}
+
+// cutElements finds bridges and articulation points with Tarjan's lowpoint depth first search
+func cutElements(adj [][]uint) ([]Edge, []uint) {
+	n := len(adj)
+	disc := make([]int, n) // discovery time, 0 if not visited
+	low := make([]int, n)
+	bridges := []Edge{}
+	points := map[uint]bool{}
+	time := 0
+
+	var dfs func(u, parent uint)
+	dfs = func(u, parent uint) {
+		time++
+		disc[u], low[u] = time, time
+		children := 0
+		for _, v := range adj[u] {
+			if disc[v] == 0 {
+				children++
+				dfs(v, u)
+				if low[v] < low[u] {
+					low[u] = low[v]
+				}
+				if low[v] > disc[u] {
+					l, h := u, v
+					if h < l {
+						l, h = h, l
+					}
+					bridges = append(bridges, Edge{l, h})
+				}
+				if parent != 0 && low[v] >= disc[u] {
+					points[u] = true
+				}
+			} else if v != parent && disc[v] < low[u] {
+				low[u] = disc[v]
+			}
+		}
+		if parent == 0 && children > 1 {
+			points[u] = true
+		}
+	}
+	for v := 1; v < n; v++ {
+		if disc[v] == 0 {
+			dfs(uint(v), 0)
+		}
+	}
+
+	sort.Slice(bridges, func(i, j int) bool {
+		if bridges[i].From != bridges[j].From {
+			return bridges[i].From < bridges[j].From
+		}
+		return bridges[i].To < bridges[j].To
+	})
+	return bridges, sortedSet(points)
+}
@@ -0,0 +1,71 @@
+package neigh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyze(t *testing.T) {
+	ring, _ := Ring(6)
+	a := Analyze(ring, 6)
+	assert.Equal(t, uint(6), a.Edges)
+	assert.True(t, a.Connected())
+	assert.Equal(t, uint(3), a.Diameter)
+	assert.Equal(t, uint(3), a.Radius)
+	assert.Len(t, a.Center, 6)
+	assert.Equal(t, map[uint]uint{2: 6}, a.DegreeDistribution)
+	assert.Empty(t, a.Bridges)
+	assert.Empty(t, a.ArticulationPoints)
+	assert.Equal(t, uint(1), a.Cycles)
+	assert.Equal(t, uint(6), a.Girth)
+	assert.Equal(t, uint(2), a.VertexConnectivity)
+
+	line, _ := Line(5)
+	a = Analyze(line, 5)
+	assert.Equal(t, uint(4), a.Diameter)
+	assert.Equal(t, uint(2), a.Radius)
+	assert.Equal(t, []uint{3}, a.Center)
+	assert.Equal(t, []Edge{{1, 2}, {2, 3}, {3, 4}, {4, 5}}, a.Bridges)
+	assert.Equal(t, []uint{2, 3, 4}, a.ArticulationPoints)
+	assert.Equal(t, uint(0), a.Cycles)
+	assert.Equal(t, uint(0), a.Girth)
+
+	// Two triangles sharing node 3 and an isolated node 6
+	bowtie := &NeighMap{Neighs: map[uint][]uint{1: {2, 3}, 2: {3}, 3: {4, 5}, 4: {5}}}
+	a = Analyze(bowtie, 6)
+	assert.False(t, a.Connected())
+	assert.Equal(t, [][]uint{{1, 2, 3, 4, 5}, {6}}, a.Components)
+	assert.Equal(t, []uint{3}, a.ArticulationPoints)
+	assert.Empty(t, a.Bridges)
+	assert.Equal(t, uint(2), a.Cycles)
+	assert.Equal(t, uint(3), a.Girth)
+	assert.Equal(t, uint(0), a.MinDegree)
+	assert.Equal(t, uint(4), a.MaxDegree)
+	assert.Equal(t, uint(2), a.Diameter)
+	assert.Equal(t, uint(0), a.Radius, "isolated node")
+}
+
+func TestAnalyzeNodes(t *testing.T) {
+	// 10 -- 20 -- 30 has no other nodes
+	line := &NeighMap{Neighs: map[uint][]uint{10: {20}, 20: {30}}}
+	a := AnalyzeNodes(line, line.UIDs())
+	assert.Equal(t, uint(3), a.Nodes)
+	assert.Equal(t, uint(2), a.Edges)
+	assert.True(t, a.Connected())
+	assert.Equal(t, [][]uint{{10, 20, 30}}, a.Components)
+	assert.Equal(t, uint(2), a.Diameter)
+	assert.Equal(t, uint(1), a.Radius)
+	assert.Equal(t, []uint{20}, a.Center)
+	assert.Equal(t, map[uint]uint{10: 2, 20: 1, 30: 2}, a.Eccentricity)
+	assert.Equal(t, map[uint]uint{10: 1, 20: 2, 30: 1}, a.Degrees)
+	assert.Equal(t, []Edge{{10, 20}, {20, 30}}, a.Bridges)
+	assert.Equal(t, []uint{20}, a.ArticulationPoints)
+	assert.Equal(t, uint(1), a.VertexConnectivity)
+
+	// Configured nodes without edges are isolated
+	a = AnalyzeNodes(line, []uint{30, 10, 20, 40})
+	assert.Equal(t, uint(4), a.Nodes)
+	assert.Equal(t, [][]uint{{10, 20, 30}, {40}}, a.Components)
+	assert.Equal(t, uint(0), a.Degrees[40])
+}
@@ -0,0 +1,164 @@
+package neigh
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awalterschulze/gographviz"
+)
+
+// Edge identifies a channel; undirected edges are stored from the lower to the higher UID
+type Edge struct {
+	From uint `json:"from"`
+	To   uint `json:"to"`
+}
+
+// EdgeAttrs are the properties of a channel
+type EdgeAttrs struct {
+	Weight    float64       `json:"weight"`
+	Latency   time.Duration `json:"latency"`   // one-way delay of every message
+	Bandwidth float64       `json:"bandwidth"` // bytes per second, unlimited if 0
+}
+
+// DefaultEdgeAttrs apply to all edges without attributes
+var DefaultEdgeAttrs = EdgeAttrs{Weight: 1}
+
+// Delay returns the time it takes to transmit size bytes over the channel
+func (a EdgeAttrs) Delay(size int) time.Duration {
+	d := a.Latency
+	if a.Bandwidth > 0 {
+		d = d + time.Duration(float64(size)/a.Bandwidth*float64(time.Second))
+	}
+	return d
+}
+
+// ParseEdgeAttrs reads the attributes `weight` (number), `latency` (duration, e.g. `20ms`) and `bandwidth` (bytes per
+// second, optionally with a `k`, `M` or `G` suffix); other attributes are ignored
+func ParseEdgeAttrs(attrs map[string]string) (EdgeAttrs, error) {
+	a := DefaultEdgeAttrs
+	var err error
+	if v, ok := attrs["weight"]; ok {
+		if a.Weight, err = strconv.ParseFloat(unquote(v), 64); err != nil {
+			return a, fmt.Errorf("invalid weight `%s`", v)
+		}
+	}
+	if v, ok := attrs["latency"]; ok {
+		if a.Latency, err = time.ParseDuration(unquote(v)); err != nil {
+			return a, fmt.Errorf("invalid latency `%s`", v)
+		}
+	}
+	if v, ok := attrs["bandwidth"]; ok {
+		if a.Bandwidth, err = parseBandwidth(unquote(v)); err != nil {
+			return a, fmt.Errorf("invalid bandwidth `%s`", v)
+		}
+	}
+	return a, nil
+}
+
+// Map returns the attributes differing from the defaults, formatted like ParseEdgeAttrs expects them
+func (a EdgeAttrs) Map() map[string]string {
+	m := map[string]string{}
+	if a.Weight != DefaultEdgeAttrs.Weight {
+		m["weight"] = strconv.FormatFloat(a.Weight, 'f', -1, 64)
+	}
+	if a.Latency != 0 {
+		m["latency"] = a.Latency.String()
+	}
+	if a.Bandwidth != 0 {
+		m["bandwidth"] = strconv.FormatFloat(a.Bandwidth, 'f', -1, 64)
+	}
+	return m
+}
+
+func parseBandwidth(s string) (float64, error) {
+	mult := 1.0
+	for suffix, m := range map[string]float64{"k": 1e3, "K": 1e3, "M": 1e6, "G": 1e9} {
+		if strings.HasSuffix(s, suffix) {
+			s, mult = strings.TrimSuffix(s, suffix), m
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v * mult, err
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"`)
+}
+
+// Attr returns the attributes of the edge between a and b; the defaults if the edge has none
+func (nm *NeighMap) Attr(a, b uint) EdgeAttrs {
+	if a > b && !nm.Directed {
+		a, b = b, a
+	}
+	if attrs, ok := nm.Attrs[Edge{a, b}]; ok {
+		return attrs
+	}
+	return DefaultEdgeAttrs
+}
+
+// SetAttr sets the attributes of the edge between a and b
+func (nm *NeighMap) SetAttr(a, b uint, attrs EdgeAttrs) {
+	if a > b && !nm.Directed {
+		a, b = b, a
+	}
+	if nm.Attrs == nil {
+		nm.Attrs = make(map[Edge]EdgeAttrs)
+	}
+	nm.Attrs[Edge{a, b}] = attrs
+}
+
+// RandomWeights assigns every edge a random integer weight in [1, max]
+func RandomWeights(r *rand.Rand, nm *NeighMap, max int) {
+	for _, e := range nm.edges() {
+		attrs := nm.Attr(e[0], e[1])
+		attrs.Weight = float64(1 + r.Intn(max))
+		nm.SetAttr(e[0], e[1], attrs)
+	}
+}
+
+// dotGraph collects the edges of a DOT file including attributes gographviz does not know (latency, bandwidth)
+type dotGraph struct {
+	*gographviz.Graph
+	edges []dotEdge
+}
+
+type dotEdge struct {
+	src, dst string
+	attrs    map[string]string
+}
+
+var customEdgeAttrs = []string{"latency", "bandwidth"}
+
+func (g *dotGraph) AddPortEdge(src, srcPort, dst, dstPort string, directed bool, attrs map[string]string) error {
+	g.edges = append(g.edges, dotEdge{src: src, dst: dst, attrs: attrs})
+	// Only standard attributes are validated
+	std := map[string]string{}
+	for k, v := range attrs {
+		std[k] = v
+	}
+	for _, k := range customEdgeAttrs {
+		delete(std, k)
+	}
+	return g.Graph.AddPortEdge(src, srcPort, dst, dstPort, directed, std)
+}
+
+func (g *dotGraph) AddEdge(src, dst string, directed bool, attrs map[string]string) error {
+	return g.AddPortEdge(src, "", dst, "", directed, attrs)
+}
+
+// dotAttrs converts edge attributes for gographviz; values are quoted where DOT requires it
+func dotAttrs(a EdgeAttrs) gographviz.Attrs {
+	attrs := gographviz.Attrs{}
+	for k, v := range a.Map() {
+		if k == "weight" {
+			attrs[gographviz.Attr(k)] = v
+		} else {
+			attrs[gographviz.Attr(k)] = strconv.Quote(v)
+		}
+	}
+	return attrs
+}
@@ -9,7 +9,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/awalterschulze/gographviz"
 	"github.com/rs/zerolog/log"
@@ -22,7 +21,9 @@ type Config struct {
 
 // NeighMap contains all neighbour relationships
 type NeighMap struct {
-	Neighs map[uint][]uint
+	Neighs   map[uint][]uint
+	Directed bool               // Neighs[a] are the out-neighbours of a; undirected edges are stored from the lower to the higher UID
+	Attrs    map[Edge]EdgeAttrs // Edges with attributes, see Attr
 }
 
 // LoadConfig reads a config file and outputs a config
@@ -60,12 +61,21 @@ func LoadConfig(path string) (*Config, error) {
 	return c, nil
 }
 
+// WriteConfig stores a config in the `<uid> <connect string>` line format
+func WriteConfig(path string, c *Config) error {
+	lines := []string{}
+	for _, uid := range sortedKeys(c.Nodes) {
+		lines = append(lines, fmt.Sprintf("%d %s\n", uid, c.Nodes[uid]))
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0644)
+}
+
 // LoadGraph reads a graph config file containing the UID<->UID pairs
 func LoadGraph(path string) (*NeighMap, error) {
 	nm := &NeighMap{
 		Neighs: make(map[uint][]uint),
 	}
-	b, err := ioutil.ReadFile(path) // Read file ontent
+	b, err := ioutil.ReadFile(path) // Read file content
 	if err != nil {
 		return nil, err
 	}
@@ -74,123 +84,109 @@ func LoadGraph(path string) (*NeighMap, error) {
 	if err != nil {
 		return nil, err
 	}
-	graph := gographviz.NewGraph()
+	graph := &dotGraph{Graph: gographviz.NewGraph()}
 	if err := gographviz.Analyse(graphAst, graph); err != nil {
 		return nil, err
 	}
+	nm.Directed = graph.Directed // `digraph`
 	// Parse graph and populate NeighMap
-	for _, e := range graph.Edges.Edges {
-		log.Debug().Msgf("Adding edge %s --- %s", e.Dst, e.Src)
+	for _, e := range graph.edges {
+		log.Debug().Msgf("Adding edge %s --- %s", e.dst, e.src)
 		// Extract UIDs from graph
-		ai, err := strconv.Atoi(e.Dst)
+		ai, err := strconv.Atoi(e.dst)
 		if err != nil {
 			return nil, err
 		} else if ai < 0 {
 			return nil, errors.New("uid is not positive")
 		}
-		bi, err := strconv.Atoi(e.Src)
+		bi, err := strconv.Atoi(e.src)
 		if err != nil {
 			return nil, err
 
 		} else if bi < 0 {
 			return nil, errors.New("uid is not positive")
 		}
-		// Add to neigh map
-		var l uint
-		var h uint
-		if bi < ai {
-			l, h = uint(bi), uint(ai)
-		} else {
-			h, l = uint(bi), uint(ai)
+		if ai == 0 || bi == 0 {
+			return nil, fmt.Errorf("UID 0 is reserved for the client")
 		}
-		if v, ok := nm.Neighs[l]; !ok {
-			nm.Neighs[l] = []uint{h}
-		} else {
-			nm.Neighs[l] = append(v, h)
+		// Add to neigh map, duplicates and self loops are dropped; directed edges keep their orientation
+		if !nm.add(uint(bi), uint(ai)) || len(e.attrs) == 0 {
+			continue
+		}
+		// Edge attributes, e.g. `[weight=3, latency="20ms"]`
+		attrs, err := ParseEdgeAttrs(e.attrs)
+		if err != nil {
+			return nil, err
 		}
+		nm.SetAttr(uint(bi), uint(ai), attrs)
 	}
 
 	return nm, nil
 }
 
-// GenGraph generates a random communication graph
-func GenGraph(path string, n, m uint) error {
-	// Seed PRGN
-	rand.Seed(time.Now().UTC().UnixNano())
-	// Check if we're okay
-	if m < n {
-		return errors.New("condition m > n failed")
-	} else if m > (n * (n - 1)) {
-		return errors.New("condition m < n*(n-1) failed")
+// GenGraph generates a random, connected communication graph; the same seed yields the same graph
+func GenGraph(path string, seed int64, n, m uint) error {
+	nm, err := RandomGraph(rand.New(rand.NewSource(seed)), n, m)
+	if err != nil {
+		return err
 	}
+	return WriteGraph(path, n, nm)
+}
 
-	edges := make(map[uint][]uint)
-
-	add := func(a, b uint) bool {
-		var l uint
-		var h uint
-
-		// We're inserting low -> high to keep consistency
-		if a < b {
-			l, h = a, b
-		} else {
-			h, l = a, b
-		}
-
-		if h == l {
-			return false
-		}
-
-		// Check if edge already exists
-		var ok bool
-		v, ok := edges[l]
-		if ok {
-			for _, i := range v {
-				if i == h {
-					return false
-				}
-			}
-			edges[l] = append(edges[l], h)
-		} else {
-			edges[l] = []uint{h}
+// RandomGraph generates a random, connected communication graph with n nodes and m edges
+func RandomGraph(r *rand.Rand, n, m uint) (*NeighMap, error) {
+	// Check if we're okay
+	if n < 2 {
+		return nil, errors.New("condition n >= 2 failed")
+	} else if m < n-1 {
+		return nil, errors.New("condition m >= n-1 failed")
+	} else if m > n*(n-1)/2 {
+		return nil, errors.New("condition m <= n*(n-1)/2 failed")
+	}
+
+	// A random spanning tree connects all nodes: every node is attached to one of the nodes before it in a random order
+	nm := newNeighMap()
+	order := r.Perm(int(n))
+	for i := 1; i < len(order); i++ {
+		nm.add(uint(order[i]+1), uint(order[r.Intn(i)]+1))
+	}
+	for e := n - 1; e < m; { // fill up edges until m is reached
+		a := 1 + r.Intn(int(n))
+		b := 1 + r.Intn(int(n))
+		if nm.add(uint(a), uint(b)) {
+			e++
 		}
-
-		return true
 	}
 
-	elen := func(edges map[uint][]uint) uint {
-		total := 0
-		for _, v := range edges {
-			total += len(v)
-		}
-		return uint(total)
-	}
+	return nm, nil
+}
 
-	var i uint
-	for i = 1; i <= n; i++ { // 1 ... n
-		for {
-			j := 1 + rand.Intn(int(n))
-			if add(i, uint(j)) {
-				break
-			}
-		}
-	}
-	for elen(edges) != m { // fill up edges until m is reached
-		a := 1 + rand.Intn(int(n))
-		b := 1 + rand.Intn(int(n))
-		add(uint(a), uint(b))
+// WriteGraph stores a communication graph with the nodes 1..n in the Graphviz format
+func WriteGraph(path string, n uint, nm *NeighMap) error {
+	uids := []uint{}
+	for uid := uint(1); uid <= n; uid++ {
+		uids = append(uids, uid)
 	}
+	return writeDOT(path, uids, nm)
+}
+
+// writeDOT stores a communication graph with the given nodes in the Graphviz format; UIDs do not have to be contiguous
+func writeDOT(path string, uids []uint, nm *NeighMap) error {
+	edges := nm.Neighs
 
 	// Build graph
 	graphAst, _ := gographviz.ParseString(`graph G {}`)
+	if nm.Directed {
+		graphAst, _ = gographviz.ParseString(`digraph G {}`)
+	}
 	graph := gographviz.NewGraph()
 	if err := gographviz.Analyse(graphAst, graph); err != nil {
 		return err
 	}
 
 	// Add nodes
-	for i = 1; i <= n; i++ { // 1 ... n
-		if err := graph.AddNode("G", fmt.Sprint(i), nil); err != nil {
+	for _, uid := range uids {
+		if err := graph.AddNode("G", fmt.Sprint(uid), nil); err != nil {
 			return err
 		}
 	}
@@ -198,9 +194,8 @@ func GenGraph(path string, n, m uint) error {
 	for k, v := range edges {
 		log.Info().Msgf("%s <-> %s", fmt.Sprint(k), fmt.Sprint(v))
 		for _, e := range v {
-			if err := graph.AddEdge(fmt.Sprint(k), fmt.Sprint(e), false, nil); err != nil {
-				return err
-			}
+			// Added directly, gographviz does not validate the custom attributes this way
+			graph.Edges.Add(&gographviz.Edge{Src: fmt.Sprint(k), Dst: fmt.Sprint(e), Dir: nm.Directed, Attrs: dotAttrs(nm.Attr(k, e))})
 		}
 	}
 
@@ -0,0 +1,187 @@
+package neigh
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// undirected returns the adjacency of the nodes 1..n (index 0 is unused), ignoring the direction of edges
+func (nm *NeighMap) undirected(n uint) [][]uint {
+	adj := make([][]uint, n+1)
+	for _, e := range nm.edges() {
+		a, b := e[0], e[1]
+		if a > n || b > n || nm.Directed && b < a && nm.has(b, a) {
+			continue
+		}
+		adj[a] = append(adj[a], b)
+		adj[b] = append(adj[b], a)
+	}
+	return adj
+}
+
+// Components returns the connected components of the nodes 1..n, ignoring the direction of edges; isolated nodes form a
+// component of their own
+func Components(nm *NeighMap, n uint) [][]uint {
+	adj := nm.undirected(n)
+	seen := make([]bool, n+1)
+	comps := [][]uint{}
+	for s := uint(1); s <= n; s++ {
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		comp := []uint{s}
+		for i := 0; i < len(comp); i++ {
+			for _, v := range adj[comp[i]] {
+				if !seen[v] {
+					seen[v] = true
+					comp = append(comp, v)
+				}
+			}
+		}
+		comps = append(comps, sortedSet(toSet(comp)))
+	}
+	return comps
+}
+
+// VertexConnectivity returns the minimum number of nodes whose removal disconnects the nodes 1..n (n-1 for a complete
+// graph), ignoring the direction of edges
+func VertexConnectivity(nm *NeighMap, n uint) uint {
+	k, _, _ := minSeparator(nm, n)
+	return k
+}
+
+// EnsureConnectivity adds random edges until the vertex connectivity of the nodes 1..n is at least k; k = 1 only connects
+// the graph
+func EnsureConnectivity(r *rand.Rand, nm *NeighMap, n, k uint) error {
+	if k > 0 && k >= n {
+		return fmt.Errorf("a vertex connectivity of %d needs more than %d nodes", k, n)
+	}
+	for {
+		c, a, b := minSeparator(nm, n)
+		if c >= k {
+			return nil
+		}
+		// a and b are separated by a minimum vertex cut, an edge across raises the number of disjoint paths
+		nm.add(a[r.Intn(len(a))], b[r.Intn(len(b))])
+	}
+}
+
+// VerifyConnectivity fails if the vertex connectivity of the nodes 1..n is less than k
+func VerifyConnectivity(nm *NeighMap, n, k uint) error {
+	if c := VertexConnectivity(nm, n); c < k {
+		return fmt.Errorf("graph has a vertex connectivity of %d, required is %d", c, k)
+	}
+	return nil
+}
+
+// minSeparator returns the vertex connectivity and the two sides of a minimum vertex cut (nil for a complete graph).
+// Following Even, only pairs with one of the first k+1 nodes have to be checked; the number of node disjoint paths is the
+// max flow through the split network
+func minSeparator(nm *NeighMap, n uint) (uint, []uint, []uint) {
+	if n < 2 {
+		return 0, nil, nil
+	}
+	adj := nm.undirected(n)
+	neigh := make([]map[uint]bool, n+1)
+	for v := range adj {
+		neigh[v] = toSet(adj[v])
+	}
+	f := newSplitNetwork(adj)
+	best := n - 1
+	var a, b []uint
+	for s := uint(1); s <= n && s <= best+1; s++ {
+		for t := s + 1; t <= n; t++ {
+			if neigh[s][t] {
+				continue
+			}
+			if c, side := f.disjointPaths(s, t, best); c < best {
+				best, a, b = c, nil, nil
+				sep := map[uint]bool{}
+				for v := uint(1); v <= n; v++ {
+					if side[2*v] && !side[2*v+1] {
+						sep[v] = true
+					}
+				}
+				for v := uint(1); v <= n; v++ {
+					if sep[v] {
+						continue
+					} else if side[2*v] {
+						a = append(a, v)
+					} else {
+						b = append(b, v)
+					}
+				}
+			}
+		}
+	}
+	return best, a, b
+}
+
+// splitNetwork is the flow network of a graph with every node v split into an in-node 2v and an out-node 2v+1 joined by
+// an arc of capacity 1; an edge u -- v becomes the arcs 2u+1 -> 2v and 2v+1 -> 2u
+type splitNetwork struct {
+	out      [][]int // node -> arcs; arc i^1 is the reverse of arc i
+	to       []uint
+	capacity []int
+}
+
+func newSplitNetwork(adj [][]uint) *splitNetwork {
+	n := uint(len(adj))
+	f := &splitNetwork{out: make([][]int, 2*n)}
+	arc := func(a, b uint, c int) {
+		f.out[a] = append(f.out[a], len(f.to))
+		f.out[b] = append(f.out[b], len(f.to)+1)
+		f.to = append(f.to, b, a)
+		f.capacity = append(f.capacity, c, 0)
+	}
+	for v := uint(1); v < n; v++ {
+		arc(2*v, 2*v+1, 1)
+		for _, u := range adj[v] {
+			arc(2*v+1, 2*u, int(n)) // never part of a minimum cut
+		}
+	}
+	return f
+}
+
+// disjointPaths counts the node disjoint paths between s and t, stopping at limit; if there are less, the second result
+// marks the split nodes reachable from s in the residual network
+func (f *splitNetwork) disjointPaths(s, t, limit uint) (uint, []bool) {
+	residual := append([]int{}, f.capacity...)
+	var paths uint
+	for {
+		// Breadth first search for an augmenting path from the out-node of s to the in-node of t
+		via := make([]int, len(f.out))
+		seen := make([]bool, len(f.out))
+		seen[2*s], seen[2*s+1] = true, true
+		queue := []uint{2*s + 1}
+		for len(queue) > 0 && !seen[2*t] {
+			x := queue[0]
+			queue = queue[1:]
+			for _, a := range f.out[x] {
+				if y := f.to[a]; !seen[y] && residual[a] > 0 {
+					seen[y], via[y] = true, a
+					queue = append(queue, y)
+				}
+			}
+		}
+		if !seen[2*t] {
+			return paths, seen
+		}
+		for y := 2 * t; y != 2*s+1; y = f.to[via[y]^1] {
+			residual[via[y]]--
+			residual[via[y]^1]++
+		}
+		if paths++; paths >= limit {
+			return paths, nil
+		}
+	}
+}
+
+func toSet(uids []uint) map[uint]bool {
+	s := map[uint]bool{}
+	for _, uid := range uids {
+		s[uid] = true
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package neigh
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVertexConnectivity(t *testing.T) {
+	ring, _ := Ring(8)
+	line, _ := Line(8)
+	star, _ := Star(8)
+	complete, _ := Complete(6)
+	cube, _ := Hypercube(4)
+	torus, _ := Grid(4, 4, true)
+	assert.Equal(t, uint(2), VertexConnectivity(ring, 8))
+	assert.Equal(t, uint(1), VertexConnectivity(line, 8))
+	assert.Equal(t, uint(1), VertexConnectivity(star, 8))
+	assert.Equal(t, uint(5), VertexConnectivity(complete, 6))
+	assert.Equal(t, uint(4), VertexConnectivity(cube, 16))
+	assert.Equal(t, uint(4), VertexConnectivity(torus, 16))
+
+	// Node 9 is isolated
+	assert.Equal(t, uint(0), VertexConnectivity(ring, 9))
+	assert.Equal(t, [][]uint{{1, 2, 3, 4, 5, 6, 7, 8}, {9}}, Components(ring, 9))
+
+	// Two triangles sharing node 3
+	bowtie := &NeighMap{Neighs: map[uint][]uint{1: {2, 3}, 2: {3}, 3: {4, 5}, 4: {5}}}
+	assert.Equal(t, uint(1), VertexConnectivity(bowtie, 5))
+	assert.NotNil(t, VerifyConnectivity(bowtie, 5, 2))
+	assert.Nil(t, VerifyConnectivity(bowtie, 5, 1))
+}
+
+func TestEnsureConnectivity(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for k := uint(1); k <= 4; k++ {
+		nm, _ := ErdosRenyi(r, 12, 0)
+		assert.Nil(t, EnsureConnectivity(r, nm, 12, k))
+		assert.Equal(t, k, VertexConnectivity(nm, 12), "k = %d", k)
+	}
+	nm, _ := ErdosRenyi(r, 4, 0)
+	assert.NotNil(t, EnsureConnectivity(r, nm, 4, 4))
+}
+
+func TestRandomGraph(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		nm, err := RandomGraph(rand.New(rand.NewSource(seed)), 20, 19)
+		assert.Nil(t, err)
+		assert.Len(t, nm.edges(), 19)
+		assert.Len(t, Components(nm, 20), 1, "seed %d", seed)
+	}
+	a, _ := RandomGraph(rand.New(rand.NewSource(42)), 10, 20)
+	b, _ := RandomGraph(rand.New(rand.NewSource(42)), 10, 20)
+	assert.Equal(t, a, b)
+
+	_, err := RandomGraph(rand.New(rand.NewSource(1)), 5, 11)
+	assert.NotNil(t, err)
+}
+
+func TestGenTopology_connectivity(t *testing.T) {
+	p := TopologyParams{N: 30, P: 0.05, Connectivity: 3}
+	a, err := GenTopology(rand.New(rand.NewSource(7)), "erdos-renyi", p)
+	assert.Nil(t, err)
+	assert.True(t, VertexConnectivity(a, 30) >= 3)
+	b, _ := GenTopology(rand.New(rand.NewSource(7)), "erdos-renyi", p)
+	assert.Equal(t, a, b)
+
+	// Deterministic topologies are only verified
+	_, err = GenTopology(rand.New(rand.NewSource(7)), "ring", TopologyParams{N: 6, Connectivity: 3})
+	assert.NotNil(t, err)
+	_, err = GenTopology(rand.New(rand.NewSource(7)), "hypercube", TopologyParams{Dim: 3, Connectivity: 3})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,395 @@
+package neigh
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported graph file formats
+const (
+	FormatDOT      = "dot"      // Graphviz, see LoadGraph
+	FormatEdgeList = "edgelist" // `<uid> <uid> [<weight>]` per line
+	FormatAdjList  = "adjlist"  // JSON object UID -> neighbour UIDs
+	FormatGraphML  = "graphml"  // GraphML XML
+	FormatSNAP     = "snap"     // Stanford SNAP edge list; arbitrary node IDs, mapped to UIDs 1..n
+)
+
+// Formats lists all supported graph formats
+var Formats = []string{FormatDOT, FormatEdgeList, FormatAdjList, FormatGraphML, FormatSNAP}
+
+// FormatFromPath guesses the graph format from the file extension; DOT is the default (e.g. `graph.txt`)
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".edges", ".edgelist":
+		return FormatEdgeList
+	case ".json":
+		return FormatAdjList
+	case ".graphml":
+		return FormatGraphML
+	case ".snap":
+		return FormatSNAP
+	}
+	return FormatDOT
+}
+
+// LoadGraphFormat reads a graph in the given format; duplicates and self loops are dropped. Only DOT (`digraph`) and GraphML
+// keep the direction of edges, undirected edges are stored from the lower to the higher UID
+func LoadGraphFormat(path, format string) (*NeighMap, error) {
+	switch format {
+	case FormatDOT:
+		return LoadGraph(path)
+	case FormatEdgeList:
+		return loadEdgeList(path, false)
+	case FormatSNAP:
+		return loadEdgeList(path, true)
+	case FormatAdjList:
+		return loadAdjList(path)
+	case FormatGraphML:
+		return loadGraphML(path)
+	}
+	return nil, fmt.Errorf("unknown graph format `%s`", format)
+}
+
+// WriteGraphFormat stores a graph in the given format
+func WriteGraphFormat(path, format string, nm *NeighMap) error {
+	switch format {
+	case FormatDOT:
+		return writeDOT(path, nm.UIDs(), nm)
+	case FormatEdgeList:
+		return writeEdgeList(path, nm, false)
+	case FormatSNAP:
+		return writeEdgeList(path, nm, true)
+	case FormatAdjList:
+		return writeAdjList(path, nm)
+	case FormatGraphML:
+		return writeGraphML(path, nm)
+	}
+	return fmt.Errorf("unknown graph format `%s`", format)
+}
+
+// add inserts an edge, undirected edges from the lower to the higher UID; false for duplicates and self loops
+func (nm *NeighMap) add(a, b uint) bool {
+	if nm.has(a, b) {
+		return false
+	}
+	if a > b && !nm.Directed {
+		a, b = b, a
+	}
+	nm.Neighs[a] = append(nm.Neighs[a], b)
+	return true
+}
+
+// edges returns all edges ordered by both UIDs
+func (nm *NeighMap) edges() [][2]uint {
+	edges := [][2]uint{}
+	for a, v := range nm.Neighs {
+		for _, b := range v {
+			edges = append(edges, [2]uint{a, b})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+	return edges
+}
+
+// UIDs returns all UIDs with at least one edge in ascending order; they are not necessarily contiguous
+func (nm *NeighMap) UIDs() []uint {
+	seen := map[uint]string{}
+	for _, e := range nm.edges() {
+		seen[e[0]], seen[e[1]] = "", ""
+	}
+	return sortedKeys(seen)
+}
+
+func (nm *NeighMap) maxUID() uint {
+	var max uint
+	for _, uid := range nm.UIDs() {
+		if uid > max {
+			max = uid
+		}
+	}
+	return max
+}
+
+// loadEdgeList reads whitespace separated pairs (and an optional weight) per line; `#` starts a comment. SNAP node IDs start at 0 and are not
+// contiguous, they are mapped to the UIDs 1..n in ascending order
+func loadEdgeList(path string, snap bool) (*NeighMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pairs := [][2]uint{}
+	weights := []string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		fs := strings.Fields(l)
+		if len(fs) < 2 {
+			return nil, fmt.Errorf("invalid line `%s`, has to follow `<uid> <uid>`", l)
+		}
+		a, err := strconv.ParseUint(fs[0], 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := strconv.ParseUint(fs[1], 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, [2]uint{uint(a), uint(b)})
+		weight := ""
+		if len(fs) > 2 {
+			weight = fs[2]
+		}
+		weights = append(weights, weight)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := map[uint]uint{}
+	if snap {
+		seen := map[uint]string{}
+		for _, p := range pairs {
+			seen[p[0]], seen[p[1]] = "", ""
+		}
+		for i, id := range sortedKeys(seen) {
+			ids[id] = uint(i + 1)
+		}
+	}
+	nm := &NeighMap{Neighs: make(map[uint][]uint)}
+	for i, p := range pairs {
+		if snap {
+			p = [2]uint{ids[p[0]], ids[p[1]]}
+		} else if p[0] == 0 || p[1] == 0 {
+			return nil, fmt.Errorf("UID 0 is reserved for the client")
+		}
+		if !nm.add(p[0], p[1]) || weights[i] == "" {
+			continue
+		}
+		attrs, err := ParseEdgeAttrs(map[string]string{"weight": weights[i]})
+		if err != nil {
+			return nil, err
+		}
+		nm.SetAttr(p[0], p[1], attrs)
+	}
+	return nm, nil
+}
+
+func writeEdgeList(path string, nm *NeighMap, snap bool) error {
+	var b strings.Builder
+	edges := nm.edges()
+	sep := " "
+	if snap {
+		sep = "\t"
+		kind := "Undirected"
+		if nm.Directed {
+			kind = "Directed"
+		}
+		fmt.Fprintf(&b, "# %s graph: %s\n# Nodes: %d Edges: %d\n# FromNodeId\tToNodeId\n", kind, filepath.Base(path), len(nm.UIDs()), len(edges))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "%d%s%d", e[0], sep, e[1])
+		if w, ok := nm.Attr(e[0], e[1]).Map()["weight"]; ok {
+			fmt.Fprintf(&b, "%s%s", sep, w)
+		}
+		b.WriteString("\n")
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// loadAdjList reads a JSON object mapping every UID to its neighbours, e.g. `{"1": [2, 3], "2": [1]}`
+func loadAdjList(path string) (*NeighMap, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	adj := map[uint][]uint{}
+	if err := json.Unmarshal(b, &adj); err != nil {
+		return nil, err
+	}
+	nm := &NeighMap{Neighs: make(map[uint][]uint)}
+	for _, a := range sortedAdj(adj) {
+		for _, b := range adj[a] {
+			if a == 0 || b == 0 {
+				return nil, fmt.Errorf("UID 0 is reserved for the client")
+			}
+			nm.add(a, b)
+		}
+	}
+	return nm, nil
+}
+
+// writeAdjList stores every undirected edge in both directions
+func writeAdjList(path string, nm *NeighMap) error {
+	adj := map[uint][]uint{}
+	for _, e := range nm.edges() {
+		adj[e[0]] = append(adj[e[0]], e[1])
+		if !nm.Directed {
+			adj[e[1]] = append(adj[e[1]], e[0])
+		}
+	}
+	for _, v := range adj {
+		sort.Slice(v, func(i, j int) bool { return v[i] < v[j] })
+	}
+	b, err := json.MarshalIndent(adj, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// graphML is the subset of GraphML needed for the communication graph
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr,omitempty"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// graphMLKey declares an attribute, the data elements refer to its id
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLNode struct {
+	ID string `xml:"id,attr"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphMLAttrs are the edge attributes written to GraphML and their types
+var graphMLAttrs = []graphMLKey{
+	{ID: "weight", For: "edge", Name: "weight", Type: "double"},
+	{ID: "latency", For: "edge", Name: "latency", Type: "string"},
+	{ID: "bandwidth", For: "edge", Name: "bandwidth", Type: "double"},
+}
+
+// loadGraphML reads a GraphML file; node IDs have to be UIDs, optionally prefixed with `n` (e.g. `n1`)
+func loadGraphML(path string) (*NeighMap, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g := &graphML{}
+	if err := xml.Unmarshal(b, g); err != nil {
+		return nil, err
+	}
+	nm := &NeighMap{Neighs: make(map[uint][]uint), Directed: g.Graph.EdgeDefault == "directed"}
+	uid := func(id string) (uint, error) {
+		v, err := strconv.ParseUint(strings.TrimPrefix(id, "n"), 10, 0)
+		if err != nil || v == 0 {
+			return 0, fmt.Errorf("node id `%s` is not a positive UID", id)
+		}
+		return uint(v), nil
+	}
+	// Attribute names by key id; data without declared key is named by the key id
+	names := map[string]string{}
+	for _, k := range g.Keys {
+		if k.Name != "" && (k.For == "edge" || k.For == "all") {
+			names[k.ID] = k.Name
+		}
+	}
+	for _, e := range g.Graph.Edges {
+		a, err := uid(e.Source)
+		if err != nil {
+			return nil, err
+		}
+		b, err := uid(e.Target)
+		if err != nil {
+			return nil, err
+		}
+		if !nm.add(a, b) || len(e.Data) == 0 {
+			continue
+		}
+		data := map[string]string{}
+		for _, d := range e.Data {
+			name, ok := names[d.Key]
+			if !ok {
+				name = d.Key
+			}
+			data[name] = strings.TrimSpace(d.Value)
+		}
+		attrs, err := ParseEdgeAttrs(data)
+		if err != nil {
+			return nil, err
+		}
+		nm.SetAttr(a, b, attrs)
+	}
+	return nm, nil
+}
+
+func writeGraphML(path string, nm *NeighMap) error {
+	g := &graphML{XMLNS: "http://graphml.graphdrawing.org/xmlns"}
+	g.Graph.EdgeDefault = "undirected"
+	if nm.Directed {
+		g.Graph.EdgeDefault = "directed"
+	}
+	for _, uid := range nm.UIDs() {
+		g.Graph.Nodes = append(g.Graph.Nodes, graphMLNode{ID: fmt.Sprint(uid)})
+	}
+	used := map[string]bool{}
+	for _, e := range nm.edges() {
+		edge := graphMLEdge{Source: fmt.Sprint(e[0]), Target: fmt.Sprint(e[1])}
+		attrs := nm.Attr(e[0], e[1]).Map()
+		for _, k := range graphMLAttrs {
+			if v, ok := attrs[k.Name]; ok {
+				edge.Data = append(edge.Data, graphMLData{Key: k.ID, Value: v})
+				used[k.ID] = true
+			}
+		}
+		g.Graph.Edges = append(g.Graph.Edges, edge)
+	}
+	for _, k := range graphMLAttrs {
+		if used[k.ID] {
+			g.Keys = append(g.Keys, k)
+		}
+	}
+	b, err := xml.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
+
+// sortedAdj returns the keys of an adjacency list in ascending order
+func sortedAdj(adj map[uint][]uint) []uint {
+	uids := []uint{}
+	for uid := range adj {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
@@ -0,0 +1,144 @@
+package neigh
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphFormats(t *testing.T) {
+	dir := t.TempDir()
+	nm := &NeighMap{Neighs: map[uint][]uint{1: {2, 4}, 2: {3}, 3: {4}}}
+	for _, format := range Formats {
+		path := filepath.Join(dir, "graph."+format)
+		assert.Nil(t, WriteGraphFormat(path, format, nm), format)
+		loaded, err := LoadGraphFormat(path, format)
+		assert.Nil(t, err, format)
+		assert.Equal(t, nm.edges(), loaded.edges(), format)
+	}
+}
+
+func TestLoadGraphFormat_snap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dataset.txt")
+	// Directed, 0-based and with duplicates
+	assert.Nil(t, ioutil.WriteFile(path, []byte("# comment\n0\t7\n7\t0\n7\t3\n3\t3\n"), 0644))
+	nm, err := LoadGraphFormat(path, FormatSNAP)
+	assert.Nil(t, err)
+	assert.Equal(t, [][2]uint{{1, 3}, {2, 3}}, nm.edges())
+
+	_, err = LoadGraphFormat(path, FormatEdgeList)
+	assert.NotNil(t, err, "UID 0 is not allowed in plain edge lists")
+
+	path = filepath.Join(t.TempDir(), "graph.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"1": [0, 2]}`), 0644))
+	_, err = LoadGraphFormat(path, FormatAdjList)
+	assert.NotNil(t, err, "UID 0 is not allowed in adjacency lists")
+}
+
+func TestWriteGraph_sparseUIDs(t *testing.T) {
+	dir := t.TempDir()
+	nm := &NeighMap{Neighs: map[uint][]uint{2: {7}}}
+	assert.Equal(t, []uint{2, 7}, nm.UIDs())
+
+	path := filepath.Join(dir, "graph.txt")
+	assert.Nil(t, WriteGraphFormat(path, FormatDOT, nm))
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "graph G {\n\t2--7;\n\t2;\n\t7;\n\n}\n", string(b), "no nodes besides the UIDs of the graph")
+
+	// Isolated nodes of the manifest are kept
+	m := NewManifest(&Config{Nodes: map[uint]string{2: "n2:1", 7: "n7:1", 9: "n9:1"}}, nm)
+	assert.Nil(t, m.WriteFiles(filepath.Join(dir, "config.txt"), path))
+	b, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "graph G {\n\t2--7;\n\t2;\n\t7;\n\t9;\n\n}\n", string(b))
+}
+
+func TestManifest_validate(t *testing.T) {
+	nodes := []ManifestNode{{UID: 1, Addr: "n1:1"}, {UID: 2, Addr: "n2:1"}}
+	m := &Manifest{Nodes: nodes, Edges: []ManifestEdge{{From: 1, To: 2}, {From: 2, To: 1}, {From: 1, To: 2}}}
+	assert.Nil(t, m.Validate())
+	assert.Equal(t, [][2]uint{{1, 2}}, m.NeighMap().edges(), "duplicate edges are merged")
+
+	m = &Manifest{Nodes: nodes, Edges: []ManifestEdge{{From: 2, To: 2}}}
+	assert.NotNil(t, m.Validate(), "self loops are not allowed")
+	m = &Manifest{Nodes: append(nodes, ManifestNode{UID: 0, Addr: "n0:1"})}
+	assert.NotNil(t, m.Validate(), "UID 0 is reserved for the client")
+}
+
+func TestFormatFromPath(t *testing.T) {
+	assert.Equal(t, FormatDOT, FormatFromPath("graph.txt"))
+	assert.Equal(t, FormatAdjList, FormatFromPath("graph.json"))
+	assert.Equal(t, FormatGraphML, FormatFromPath("graph.GraphML"))
+}
+
+func TestLoadGraph_directed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring.txt")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("digraph G {\n1 -> 2;\n2 -> 3;\n3 -> 1;\n}\n"), 0644))
+	nm, err := LoadGraph(path)
+	assert.Nil(t, err)
+	assert.True(t, nm.Directed)
+	assert.Equal(t, [][2]uint{{1, 2}, {2, 3}, {3, 1}}, nm.edges())
+
+	c := &Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}
+	n := NeighsFor(1, c, nm)
+	assert.Equal(t, map[uint]string{2: "n2:1"}, n.Nodes, "messages are sent to out-neighbours")
+	assert.Equal(t, map[uint]string{3: "n3:1"}, n.Incoming())
+
+	// Undirected graphs share both directions
+	n = NeighsFor(1, c, &NeighMap{Neighs: map[uint][]uint{1: {2}, 2: {3}}})
+	assert.Equal(t, n.Nodes, n.Incoming())
+}
+
+func TestLoadGraph_attributes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("graph G {\n1 -- 2 [weight=3, latency=\"20ms\"];\n3 -- 2 [bandwidth=\"1M\"];\n1 -- 3;\n}\n"), 0644))
+	nm, err := LoadGraph(path)
+	assert.Nil(t, err)
+	assert.Equal(t, EdgeAttrs{Weight: 3, Latency: 20 * time.Millisecond}, nm.Attr(2, 1))
+	assert.Equal(t, EdgeAttrs{Weight: 1, Bandwidth: 1e6}, nm.Attr(2, 3))
+	assert.Equal(t, DefaultEdgeAttrs, nm.Attr(1, 3))
+	assert.Equal(t, 20*time.Millisecond+time.Millisecond, EdgeAttrs{Latency: 20 * time.Millisecond, Bandwidth: 1e6}.Delay(1000))
+
+	// All attributes survive DOT, weights the edge list
+	out := filepath.Join(t.TempDir(), "graph")
+	assert.Nil(t, WriteGraphFormat(out, FormatDOT, nm))
+	loaded, err := LoadGraph(out)
+	assert.Nil(t, err)
+	assert.Equal(t, nm.Attrs, loaded.Attrs)
+	assert.Nil(t, WriteGraphFormat(out, FormatEdgeList, nm))
+	loaded, err = LoadGraphFormat(out, FormatEdgeList)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, loaded.Attr(1, 2).Weight)
+	assert.Nil(t, WriteGraphFormat(out, FormatGraphML, nm))
+	loaded, err = LoadGraphFormat(out, FormatGraphML)
+	assert.Nil(t, err)
+	assert.Equal(t, nm.Attrs, loaded.Attrs)
+
+	// Key ids of other tools refer to the attribute name
+	ml := `<graphml><key id="d0" for="edge" attr.name="weight" attr.type="double"/><graph edgedefault="undirected">` +
+		`<node id="n1"/><node id="n2"/><edge source="n1" target="n2"><data key="d0">4</data></edge></graph></graphml>`
+	assert.Nil(t, ioutil.WriteFile(out, []byte(ml), 0644))
+	loaded, err = LoadGraphFormat(out, FormatGraphML)
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, loaded.Attr(1, 2).Weight)
+
+	n := NeighsFor(2, &Config{Nodes: map[uint]string{1: "n1:1", 2: "n2:1", 3: "n3:1"}}, nm)
+	assert.Equal(t, 20*time.Millisecond, n.Link(1).Latency)
+}
+
+func TestLoadGraph_duplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("graph G {\n1 -- 2 [weight=3];\n2 -- 1;\n2 -- 2;\n2 -- 3;\n}\n"), 0644))
+	nm, err := LoadGraph(path)
+	assert.Nil(t, err)
+	assert.Equal(t, [][2]uint{{1, 2}, {2, 3}}, nm.edges(), "duplicates and self loops are dropped")
+	assert.Equal(t, 3.0, nm.Attr(1, 2).Weight, "attributes of the first edge are kept")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("graph G {\n0 -- 1;\n}\n"), 0644))
+	_, err = LoadGraph(path)
+	assert.EqualError(t, err, "UID 0 is reserved for the client")
+}
@@ -0,0 +1,215 @@
+package neigh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest describes a whole cluster in a single JSON or YAML file
+type Manifest struct {
+	Nodes      []ManifestNode    `json:"nodes" yaml:"nodes"`
+	Edges      []ManifestEdge    `json:"edges" yaml:"edges"`
+	Directed   bool              `json:"directed,omitempty" yaml:"directed,omitempty"`     // edges are unidirectional from -> to
+	Extensions map[string]Params `json:"extensions,omitempty" yaml:"extensions,omitempty"` // message type -> parameters
+}
+
+// ManifestNode is a node of the cluster
+type ManifestNode struct {
+	UID      uint              `json:"uid" yaml:"uid"`
+	Addr     string            `json:"addr" yaml:"addr"` // `<host>:<port>`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// ManifestEdge is a communication channel between two nodes
+type ManifestEdge struct {
+	From       uint              `json:"from" yaml:"from"`
+	To         uint              `json:"to" yaml:"to"`
+	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// Params holds the parameters of an extension
+type Params map[string]interface{}
+
+// Int returns an integer parameter or def if it is not set; numbers are float64 in JSON and int in YAML
+func (p Params) Int(key string, def int) int {
+	switch v := p[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return def
+}
+
+// String returns a parameter formatted as string or def if it is not set
+func (p Params) String(key, def string) string {
+	if v, ok := p[key]; ok {
+		return fmt.Sprint(v)
+	}
+	return def
+}
+
+// LoadManifest reads a manifest; `.json` files are parsed as JSON, everything else as YAML
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if isJSON(path) {
+		err = json.Unmarshal(b, m)
+	} else {
+		err = yaml.Unmarshal(b, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Write stores the manifest; the format follows the file extension like in LoadManifest
+func (m *Manifest) Write(path string) error {
+	var b []byte
+	var err error
+	if isJSON(path) {
+		b, err = json.MarshalIndent(m, "", "  ")
+	} else {
+		b, err = yaml.Marshal(m)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// Validate checks for duplicate or reserved UIDs, invalid addresses, self loops and edges between unknown nodes
+func (m *Manifest) Validate() error {
+	uids := map[uint]bool{}
+	for _, n := range m.Nodes {
+		if n.UID == 0 {
+			return fmt.Errorf("UID 0 is reserved for the client")
+		}
+		if uids[n.UID] {
+			return fmt.Errorf("duplicate node %d", n.UID)
+		}
+		if !strings.Contains(n.Addr, ":") {
+			return fmt.Errorf("address of node %d is invalid, needs to follow the expression <host>:<port>", n.UID)
+		}
+		uids[n.UID] = true
+	}
+	for _, e := range m.Edges {
+		if !uids[e.From] || !uids[e.To] {
+			return fmt.Errorf("edge %d -- %d connects an unknown node", e.From, e.To)
+		}
+		if e.From == e.To {
+			return fmt.Errorf("edge %d -- %d is a self loop", e.From, e.To)
+		}
+		if _, err := ParseEdgeAttrs(e.Attributes); err != nil {
+			return fmt.Errorf("edge %d -- %d: %v", e.From, e.To, err)
+		}
+	}
+	return nil
+}
+
+// Config returns the nodes of the manifest
+func (m *Manifest) Config() *Config {
+	c := &Config{Nodes: make(map[uint]string)}
+	for _, n := range m.Nodes {
+		c.Nodes[n.UID] = n.Addr
+	}
+	return c
+}
+
+// NeighMap returns the edges of the manifest; like LoadGraph, undirected edges are stored from the lower to the higher UID
+// and duplicate edges are merged
+func (m *Manifest) NeighMap() *NeighMap {
+	nm := &NeighMap{Neighs: make(map[uint][]uint), Directed: m.Directed}
+	for _, e := range m.Edges {
+		l, h := e.From, e.To
+		if h < l && !m.Directed {
+			l, h = h, l
+		}
+		nm.add(l, h)
+		if len(e.Attributes) > 0 {
+			attrs, _ := ParseEdgeAttrs(e.Attributes) // validated on load
+			nm.SetAttr(l, h, attrs)
+		}
+	}
+	return nm
+}
+
+// Params returns the parameters of an extension; empty if not configured
+func (m *Manifest) Params(msgType string) Params {
+	if p, ok := m.Extensions[msgType]; ok {
+		return p
+	}
+	return Params{}
+}
+
+// NewManifest converts a config and a graph into a manifest
+func NewManifest(c *Config, nm *NeighMap) *Manifest {
+	m := &Manifest{Nodes: []ManifestNode{}, Edges: []ManifestEdge{}, Directed: nm.Directed}
+	for _, uid := range sortedKeys(c.Nodes) {
+		m.Nodes = append(m.Nodes, ManifestNode{UID: uid, Addr: c.Nodes[uid]})
+	}
+	froms := []uint{}
+	for a := range nm.Neighs {
+		froms = append(froms, a)
+	}
+	sort.Slice(froms, func(i, j int) bool { return froms[i] < froms[j] })
+	for _, a := range froms {
+		for _, b := range nm.Neighs[a] {
+			e := ManifestEdge{From: a, To: b}
+			if attrs := nm.Attr(a, b).Map(); len(attrs) > 0 {
+				e.Attributes = attrs
+			}
+			m.Edges = append(m.Edges, e)
+		}
+	}
+	return m
+}
+
+// ManifestFromFiles converts the config and graph files into a manifest
+func ManifestFromFiles(config, graph string) (*Manifest, error) {
+	c, err := LoadConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	nm, err := LoadGraphFormat(graph, FormatFromPath(graph))
+	if err != nil {
+		return nil, err
+	}
+	return NewManifest(c, nm), nil
+}
+
+// WriteFiles stores the manifest as config and graph file; metadata, unknown edge attributes and extension parameters are lost
+func (m *Manifest) WriteFiles(config, graph string) error {
+	c := m.Config()
+	if err := WriteConfig(config, c); err != nil {
+		return err
+	}
+	return writeDOT(graph, sortedKeys(c.Nodes), m.NeighMap())
+}
+
+func isJSON(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".json"
+}
+
+// sortedKeys returns the UIDs of a node map in ascending order
+func sortedKeys(nodes map[uint]string) []uint {
+	uids := []uint{}
+	for uid := range nodes {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
@@ -1,51 +1,108 @@
 package neigh
 
 import (
+	"fmt"
 	"math/rand"
-	"time"
 )
 
 // Similar to Config but only contains a subset required for this node
 type Neighs struct {
-	Nodes      map[uint]string // Neighbor connect addresses
-	AllNodes   map[uint]string // All nodes
-	Registered map[uint]bool   // Keeps track if a neigh registered itself or not
+	Nodes      map[uint]string    // Neighbor connect addresses (outgoing channels)
+	In         map[uint]string    // Incoming channels; nil if the same as Nodes (undirected graph)
+	Links      map[uint]EdgeAttrs // Attributes of the channels to (and from) the neighbours; nil without a graph
+	AllNodes   map[uint]string    // All nodes
+	Registered map[uint]bool      // Keeps track if a neigh registered itself or not
 }
 
-// NeighsFromConfig gets neighbors
-func NeighsFromConfig(config string) (*Neighs, error) {
-	// Seed PRGN
-	rand.Seed(time.Now().UTC().UnixNano())
+// Link returns the attributes of the channel to (or from) a neighbour
+func (n *Neighs) Link(uid uint) EdgeAttrs {
+	if a, ok := n.Links[uid]; ok {
+		return a
+	}
+	return DefaultEdgeAttrs
+}
 
-	n := &Neighs{
-		Nodes:      make(map[uint]string),
-		Registered: make(map[uint]bool),
+// Incoming returns the neighbours this node receives messages from
+func (n *Neighs) Incoming() map[uint]string {
+	if n.In == nil {
+		return n.Nodes
 	}
+	return n.In
+}
 
+// NeighsFromConfig gets the neighbours of a node in a random overlay of all configured nodes; every node derives the same
+// connected k-regular graph from the shared seed (see Overlay)
+func NeighsFromConfig(uid uint, config string, seed int64, k uint) (*Neighs, error) {
 	c, err := LoadConfig(config)
 	if err != nil {
 		return nil, err
 	}
-
-	maxUID := len(c.Nodes)
-
-	for i := 0; i < 3; i++ {
-		nuid := uint(1 + rand.Intn(maxUID))
-		n.Nodes[nuid] = c.Nodes[nuid]
-		n.Registered[nuid] = false
+	nm, err := Overlay(c, seed, k)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, nil
+	return NeighsFor(uid, c, nm), nil
 }
 
-// NeighsFromConfig gets neighbors
-func NeighsFromConfigAndGraph(uid uint, config, graph string) (*Neighs, error) {
+// Overlay generates a random connected k-regular graph of the configured nodes; k is limited to n-1 (complete graph). If
+// n*k is odd, the node with the largest UID has degree k+1.
+func Overlay(c *Config, seed int64, k uint) (*NeighMap, error) {
+	uids := sortedKeys(c.Nodes)
+	n := uint(len(uids))
+	if k >= n {
+		k = n - 1
+	}
+	var nm *NeighMap
+	var err error
+	switch {
+	case n < 2:
+		return newNeighMap(), nil
+	case k == n-1:
+		nm, err = Complete(n)
+	case n*k%2 == 1:
+		nm, err = nearRegular(rand.New(rand.NewSource(seed)), n, k)
+	default:
+		nm, err = RandomRegular(rand.New(rand.NewSource(seed)), n, k)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("random overlay of %d nodes: %v", n, err)
+	}
+	// The generated graph uses the nodes 1..n, the config might not
+	overlay := newNeighMap()
+	for _, e := range nm.edges() {
+		overlay.add(uids[e[0]-1], uids[e[1]-1])
+	}
+	return overlay, nil
+}
 
-	n := &Neighs{
-		Nodes:      make(map[uint]string),
-		Registered: make(map[uint]bool),
+// nearRegular generates a connected graph of the nodes 1..n where all nodes but n have degree k; n*k is odd, so no
+// k-regular graph exists and node n gets degree k+1. Node n is inserted into (k+1)/2 disjoint edges of a random k-regular
+// graph of the other nodes; in a k-regular graph, every maximal matching covers at least k nodes, so enough edges exist.
+func nearRegular(r *rand.Rand, n, k uint) (*NeighMap, error) {
+	nm, err := RandomRegular(r, n-1, k)
+	if err != nil {
+		return nil, err
+	}
+	edges := nm.edges()
+	r.Shuffle(len(edges), func(i, j int) { edges[i], edges[j] = edges[j], edges[i] })
+	used := map[uint]bool{}
+	for _, e := range edges {
+		if uint(len(used)) == k+1 {
+			break
+		}
+		if used[e[0]] || used[e[1]] {
+			continue
+		}
+		used[e[0]], used[e[1]] = true, true
+		nm.remove(e[0], e[1])
+		nm.add(e[0], n)
+		nm.add(e[1], n)
 	}
+	return nm, nil
+}
 
+// NeighsFromConfigAndGraph gets the neighbours of a node based on a config and a graph
+func NeighsFromConfigAndGraph(uid uint, config, graph string) (*Neighs, error) {
 	// Load config
 	c, err := LoadConfig(config)
 	if err != nil {
@@ -53,12 +110,56 @@ func NeighsFromConfigAndGraph(uid uint, config, graph string) (*Neighs, error) {
 	}
 
 	// Load neighbour map
-	nm, err := LoadGraph(graph)
+	nm, err := LoadGraphFormat(graph, FormatFromPath(graph))
 	if err != nil {
 		return nil, err
 	}
 
+	return NeighsFor(uid, c, nm), nil
+}
+
+// NeighsFromManifest gets the neighbours of a node based on a manifest
+func NeighsFromManifest(uid uint, path string) (*Neighs, error) {
+	m, err := LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	return NeighsFor(uid, m.Config(), m.NeighMap()), nil
+}
+
+// NeighsFor extracts the neighbours of a node from an already loaded config and graph
+func NeighsFor(uid uint, c *Config, nm *NeighMap) *Neighs {
+	n := &Neighs{
+		Nodes:      make(map[uint]string),
+		Links:      make(map[uint]EdgeAttrs),
+		Registered: make(map[uint]bool),
+	}
+
 	n.AllNodes = c.Nodes
+	for a, v := range nm.Neighs {
+		for _, b := range v {
+			if a == uid {
+				n.Links[b] = nm.Attr(a, b)
+			} else if b == uid {
+				n.Links[a] = nm.Attr(a, b)
+			}
+		}
+	}
+	if nm.Directed {
+		// Messages are sent to out-neighbours; in-neighbours register themselves
+		n.In = make(map[uint]string)
+		for a, v := range nm.Neighs {
+			for _, b := range v {
+				if a == uid {
+					n.Nodes[b] = c.Nodes[b]
+				} else if b == uid {
+					n.In[a] = c.Nodes[a]
+					n.Registered[a] = false
+				}
+			}
+		}
+		return n
+	}
 	// Extract neighbours for the node UID based on the graph
 	for a, v := range nm.Neighs {
 		for _, b := range v {
@@ -72,5 +173,5 @@ func NeighsFromConfigAndGraph(uid uint, config, graph string) (*Neighs, error) {
 		}
 	}
 
-	return n, nil
+	return n
 }
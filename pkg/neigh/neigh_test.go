@@ -0,0 +1,71 @@
+package neigh
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNeighsFromConfig(t *testing.T) {
+	c := &Config{Nodes: map[uint]string{}}
+	for uid := uint(1); uid <= 12; uid++ {
+		c.Nodes[uid*10] = fmt.Sprintf("127.0.0.1:%d", 4000+uid)
+	}
+	path := filepath.Join(t.TempDir(), "config.txt")
+	assert.Nil(t, WriteConfig(path, c))
+
+	// All nodes derive the same symmetric overlay
+	neighs := map[uint]*Neighs{}
+	for uid := range c.Nodes {
+		n, err := NeighsFromConfig(uid, path, 42, 3)
+		assert.Nil(t, err)
+		assert.Len(t, n.Nodes, 3)
+		assert.Len(t, n.AllNodes, 12)
+		neighs[uid] = n
+	}
+	for uid, n := range neighs {
+		for nb, addr := range n.Nodes {
+			assert.Equal(t, c.Nodes[nb], addr)
+			assert.Contains(t, neighs[nb].Nodes, uid)
+		}
+	}
+	nm, _ := Overlay(c, 42, 3)
+	assert.Len(t, Components(nm, 120), 120-12+1, "only the configured UIDs are used")
+
+	// A different seed yields a different overlay, the degree is limited to n-1
+	other, _ := Overlay(c, 43, 3)
+	assert.NotEqual(t, nm, other)
+	complete, _ := Overlay(c, 42, 20)
+	assert.Len(t, complete.edges(), 12*11/2)
+
+	_, err := NeighsFromConfig(10, filepath.Join(t.TempDir(), "missing.txt"), 42, 3)
+	assert.NotNil(t, err)
+}
+
+func TestOverlay_oddDegreeSum(t *testing.T) {
+	for _, n := range []uint{5, 7, 9, 13} {
+		c := &Config{Nodes: map[uint]string{}}
+		for uid := uint(1); uid <= n; uid++ {
+			c.Nodes[uid*3] = fmt.Sprintf("127.0.0.1:%d", 4000+uid)
+		}
+		nm, err := Overlay(c, 42, 3)
+		assert.Nil(t, err, "n=%d", n)
+		// All nodes have degree 3 but the largest UID, which has degree 4
+		degree := map[uint]int{}
+		for _, e := range nm.edges() {
+			degree[e[0]]++
+			degree[e[1]]++
+		}
+		assert.Len(t, degree, int(n))
+		for uid := range c.Nodes {
+			if uid == n*3 {
+				assert.Equal(t, 4, degree[uid], "n=%d", n)
+			} else {
+				assert.Equal(t, 3, degree[uid], "n=%d", n)
+			}
+		}
+		assert.Len(t, Components(nm, n*3), int(n*3-n+1), "n=%d: connected", n)
+	}
+}
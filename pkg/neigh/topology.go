@@ -0,0 +1,329 @@
+package neigh
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// TopologyParams holds the parameters of all topologies; every topology uses a subset
+type TopologyParams struct {
+	N    uint    // nodes
+	M    uint    // edges (random), edges per new node (barabasi-albert)
+	K    uint    // children (tree), neighbours in the ring lattice (watts-strogatz), degree (regular)
+	Rows uint    // grid, torus
+	Cols uint    // grid, torus
+	Dim  uint    // hypercube
+	P    float64 // edge probability (erdos-renyi)
+	Beta float64 // rewiring probability (watts-strogatz)
+
+	Connectivity uint // minimum vertex connectivity, 1 for a connected graph; random topologies are augmented with edges
+}
+
+// topologies maps a name to its generator
+var topologies = map[string]func(r *rand.Rand, p TopologyParams) (*NeighMap, error){
+	"random":          func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return RandomGraph(r, p.N, p.M) },
+	"ring":            func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Ring(p.N) },
+	"line":            func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Line(p.N) },
+	"star":            func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Star(p.N) },
+	"grid":            func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Grid(p.Rows, p.Cols, false) },
+	"torus":           func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Grid(p.Rows, p.Cols, true) },
+	"hypercube":       func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Hypercube(p.Dim) },
+	"complete":        func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Complete(p.N) },
+	"tree":            func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return Tree(p.N, p.K) },
+	"erdos-renyi":     func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return ErdosRenyi(r, p.N, p.P) },
+	"barabasi-albert": func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return BarabasiAlbert(r, p.N, p.M) },
+	"watts-strogatz":  func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return WattsStrogatz(r, p.N, p.K, p.Beta) },
+	"regular":         func(r *rand.Rand, p TopologyParams) (*NeighMap, error) { return RandomRegular(r, p.N, p.K) },
+}
+
+// randomTopologies are augmented with random edges until they reach the requested connectivity
+var randomTopologies = map[string]bool{"random": true, "erdos-renyi": true, "barabasi-albert": true, "watts-strogatz": true}
+
+// Topologies returns the names of all topologies in alphabetical order
+func Topologies() []string {
+	names := []string{}
+	for name := range topologies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GenTopology generates a graph of the named topology with the nodes 1..n and verifies its connectivity; the same seeded
+// source yields the same graph
+func GenTopology(r *rand.Rand, name string, p TopologyParams) (*NeighMap, error) {
+	gen, ok := topologies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown topology `%s`", name)
+	}
+	nm, err := gen(r, p)
+	if err != nil {
+		return nil, err
+	}
+	n := nm.maxUID()
+	if randomTopologies[name] {
+		n = p.N
+		if err := EnsureConnectivity(r, nm, n, p.Connectivity); err != nil {
+			return nil, err
+		}
+	}
+	if err := VerifyConnectivity(nm, n, p.Connectivity); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return nm, nil
+}
+
+func newNeighMap() *NeighMap {
+	return &NeighMap{Neighs: make(map[uint][]uint)}
+}
+
+// Ring connects node i with i+1 and n with 1
+func Ring(n uint) (*NeighMap, error) {
+	if n < 3 {
+		return nil, errors.New("a ring needs at least 3 nodes")
+	}
+	nm, _ := Line(n)
+	nm.add(n, 1)
+	return nm, nil
+}
+
+// Line connects node i with i+1
+func Line(n uint) (*NeighMap, error) {
+	if n < 2 {
+		return nil, errors.New("a line needs at least 2 nodes")
+	}
+	nm := newNeighMap()
+	for i := uint(1); i < n; i++ {
+		nm.add(i, i+1)
+	}
+	return nm, nil
+}
+
+// Star connects node 1 with all other nodes
+func Star(n uint) (*NeighMap, error) {
+	if n < 2 {
+		return nil, errors.New("a star needs at least 2 nodes")
+	}
+	nm := newNeighMap()
+	for i := uint(2); i <= n; i++ {
+		nm.add(1, i)
+	}
+	return nm, nil
+}
+
+// Grid connects the nodes of a rows x cols lattice with their horizontal and vertical neighbours; a torus wraps around
+func Grid(rows, cols uint, torus bool) (*NeighMap, error) {
+	if rows*cols < 2 {
+		return nil, errors.New("a grid needs at least 2 nodes")
+	}
+	if torus && (rows < 3 || cols < 3) {
+		return nil, errors.New("a torus needs at least 3 rows and columns")
+	}
+	uid := func(i, j uint) uint { return (i%rows)*cols + j%cols + 1 }
+	nm := newNeighMap()
+	for i := uint(0); i < rows; i++ {
+		for j := uint(0); j < cols; j++ {
+			if j+1 < cols || torus {
+				nm.add(uid(i, j), uid(i, j+1))
+			}
+			if i+1 < rows || torus {
+				nm.add(uid(i, j), uid(i+1, j))
+			}
+		}
+	}
+	return nm, nil
+}
+
+// Hypercube connects the 2^dim nodes whose (0-based) UIDs differ in exactly one bit
+func Hypercube(dim uint) (*NeighMap, error) {
+	if dim < 1 || dim > 16 {
+		return nil, errors.New("the dimension of a hypercube has to be in [1, 16]")
+	}
+	nm := newNeighMap()
+	for i := uint(0); i < 1<<dim; i++ {
+		for b := uint(0); b < dim; b++ {
+			nm.add(i+1, i^(1<<b)+1)
+		}
+	}
+	return nm, nil
+}
+
+// Complete connects every pair of nodes
+func Complete(n uint) (*NeighMap, error) {
+	if n < 2 {
+		return nil, errors.New("a complete graph needs at least 2 nodes")
+	}
+	nm := newNeighMap()
+	for i := uint(1); i <= n; i++ {
+		for j := i + 1; j <= n; j++ {
+			nm.add(i, j)
+		}
+	}
+	return nm, nil
+}
+
+// Tree is a balanced k-ary tree with n nodes in level order, node 1 is the root
+func Tree(n, k uint) (*NeighMap, error) {
+	if n < 2 || k < 1 {
+		return nil, errors.New("a tree needs at least 2 nodes and 1 child per node")
+	}
+	nm := newNeighMap()
+	for i := uint(1); i < n; i++ {
+		nm.add((i-1)/k+1, i+1)
+	}
+	return nm, nil
+}
+
+// ErdosRenyi is the random graph G(n,p); every edge exists with probability p
+func ErdosRenyi(r *rand.Rand, n uint, p float64) (*NeighMap, error) {
+	if n < 2 || p < 0 || p > 1 {
+		return nil, errors.New("G(n,p) needs at least 2 nodes and p in [0, 1]")
+	}
+	nm := newNeighMap()
+	for i := uint(1); i <= n; i++ {
+		for j := i + 1; j <= n; j++ {
+			if r.Float64() < p {
+				nm.add(i, j)
+			}
+		}
+	}
+	return nm, nil
+}
+
+// BarabasiAlbert grows a scale-free graph; starting with a complete graph of m+1 nodes, every further node connects to m
+// distinct nodes chosen proportional to their degree (preferential attachment)
+func BarabasiAlbert(r *rand.Rand, n, m uint) (*NeighMap, error) {
+	if m < 1 || n <= m {
+		return nil, errors.New("barabasi-albert needs m >= 1 and n > m")
+	}
+	nm, _ := Complete(m + 1)
+	// Every node appears once per edge, a uniform draw is proportional to the degree
+	ends := []uint{}
+	for _, e := range nm.edges() {
+		ends = append(ends, e[0], e[1])
+	}
+	for i := m + 2; i <= n; i++ {
+		targets := map[uint]bool{}
+		for uint(len(targets)) < m {
+			targets[ends[r.Intn(len(ends))]] = true
+		}
+		for _, t := range sortedSet(targets) {
+			nm.add(i, t)
+			ends = append(ends, i, t)
+		}
+	}
+	return nm, nil
+}
+
+// WattsStrogatz is a small-world graph; a ring lattice connecting every node with its k nearest neighbours, every edge is
+// rewired to a random node with probability beta
+func WattsStrogatz(r *rand.Rand, n, k uint, beta float64) (*NeighMap, error) {
+	if k < 2 || k%2 != 0 || n <= k || beta < 0 || beta > 1 {
+		return nil, errors.New("watts-strogatz needs an even k >= 2, n > k and beta in [0, 1]")
+	}
+	nm := newNeighMap()
+	for i := uint(0); i < n; i++ {
+		for j := uint(1); j <= k/2; j++ {
+			nm.add(i+1, (i+j)%n+1)
+		}
+	}
+	for i := uint(0); i < n; i++ {
+		for j := uint(1); j <= k/2; j++ {
+			a, b := i+1, (i+j)%n+1
+			if r.Float64() >= beta {
+				continue
+			}
+			// Rewire a -- b to a -- c, keeping the graph simple
+			c := uint(1 + r.Intn(int(n)))
+			if nm.has(a, c) {
+				continue
+			}
+			nm.remove(a, b)
+			nm.add(a, c)
+		}
+	}
+	return nm, nil
+}
+
+// RandomRegular is a random connected k-regular graph; a ring lattice (with the diagonals for an odd k) is shuffled with
+// degree preserving edge swaps that keep the graph connected
+func RandomRegular(r *rand.Rand, n, k uint) (*NeighMap, error) {
+	if k < 2 || k >= n || n*k%2 != 0 {
+		return nil, errors.New("a k-regular graph needs 2 <= k < n and an even n*k")
+	}
+	nm := newNeighMap()
+	for i := uint(0); i < n; i++ {
+		for j := uint(1); j <= k/2; j++ {
+			nm.add(i+1, (i+j)%n+1)
+		}
+		if k%2 == 1 {
+			nm.add(i+1, (i+n/2)%n+1)
+		}
+	}
+	// a -- b, c -- d becomes a -- d, c -- b
+	edges := nm.edges()
+	for i := uint(0); i < n*k; i++ {
+		x, y := r.Intn(len(edges)), r.Intn(len(edges))
+		a, b, c, d := edges[x][0], edges[x][1], edges[y][0], edges[y][1]
+		if r.Intn(2) == 0 {
+			c, d = d, c
+		}
+		if a == d || c == b || nm.has(a, d) || nm.has(c, b) {
+			continue
+		}
+		nm.remove(a, b)
+		nm.remove(c, d)
+		nm.add(a, d)
+		nm.add(c, b)
+		if len(Components(nm, n)) > 1 {
+			nm.remove(a, d)
+			nm.remove(c, b)
+			nm.add(a, b)
+			nm.add(c, d)
+			continue
+		}
+		edges[x], edges[y] = [2]uint{a, d}, [2]uint{c, b}
+	}
+	return nm, nil
+}
+
+// has returns true if the edge exists (or a == b)
+func (nm *NeighMap) has(a, b uint) bool {
+	if a > b && !nm.Directed {
+		a, b = b, a
+	}
+	for _, v := range nm.Neighs[a] {
+		if v == b {
+			return true
+		}
+	}
+	return a == b
+}
+
+// remove deletes an edge
+func (nm *NeighMap) remove(a, b uint) {
+	if a > b && !nm.Directed {
+		a, b = b, a
+	}
+	v := nm.Neighs[a]
+	for i := range v {
+		if v[i] == b {
+			nm.Neighs[a] = append(v[:i], v[i+1:]...)
+			break
+		}
+	}
+	if len(nm.Neighs[a]) == 0 {
+		delete(nm.Neighs, a)
+	}
+}
+
+func sortedSet(s map[uint]bool) []uint {
+	uids := []uint{}
+	for uid := range s {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
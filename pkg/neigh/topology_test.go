@@ -0,0 +1,59 @@
+package neigh
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenTopology(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tc := range []struct {
+		name  string
+		p     TopologyParams
+		nodes int
+		edges int
+	}{
+		{"ring", TopologyParams{N: 6}, 6, 6},
+		{"line", TopologyParams{N: 6}, 6, 5},
+		{"star", TopologyParams{N: 6}, 6, 5},
+		{"grid", TopologyParams{Rows: 3, Cols: 4}, 12, 17},
+		{"torus", TopologyParams{Rows: 3, Cols: 4}, 12, 24},
+		{"hypercube", TopologyParams{Dim: 3}, 8, 12},
+		{"complete", TopologyParams{N: 5}, 5, 10},
+		{"tree", TopologyParams{N: 10, K: 3}, 10, 9},
+		{"barabasi-albert", TopologyParams{N: 20, M: 2}, 20, 3 + 17*2},
+		{"watts-strogatz", TopologyParams{N: 20, K: 4, Beta: 0.3}, 20, 40},
+		{"random", TopologyParams{N: 10, M: 15}, 10, 15},
+	} {
+		nm, err := GenTopology(r, tc.name, tc.p)
+		assert.Nil(t, err, tc.name)
+		assert.Len(t, nm.UIDs(), tc.nodes, tc.name)
+		assert.Len(t, nm.edges(), tc.edges, tc.name)
+	}
+
+	nm, _ := Tree(7, 2)
+	assert.Equal(t, map[uint][]uint{1: {2, 3}, 2: {4, 5}, 3: {6, 7}}, nm.Neighs)
+	nm, _ = ErdosRenyi(r, 10, 1)
+	assert.Len(t, nm.edges(), 45)
+
+	_, err := GenTopology(r, "moebius", TopologyParams{})
+	assert.NotNil(t, err)
+	_, err = WattsStrogatz(r, 10, 3, 0.1)
+	assert.NotNil(t, err, "k has to be even")
+}
+
+func TestRandomRegular(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tc := range [][2]uint{{10, 3}, {11, 4}, {20, 5}, {6, 2}} {
+		n, k := tc[0], tc[1]
+		nm, err := RandomRegular(r, n, k)
+		assert.Nil(t, err)
+		a := Analyze(nm, n)
+		assert.Equal(t, map[uint]uint{k: n}, a.DegreeDistribution, "n = %d, k = %d", n, k)
+		assert.True(t, a.Connected())
+	}
+	_, err := RandomRegular(r, 5, 3)
+	assert.NotNil(t, err, "n*k is odd")
+}